@@ -0,0 +1,383 @@
+// Package chattest 提供按聊天服务器分帧协议通信的脚本化客户端，用于集成测试和压力测试。
+//
+// 客户端在后台协程中持续读取数据包，Expect 按消息类型等待，先到的其他消息会被保留，
+// 因此测试不必关心进度通知等消息与目标消息之间的先后顺序。
+package chattest
+
+import (
+	"connection_server_linux/filetransfer"
+	"connection_server_linux/frame"
+	"connection_server_linux/user"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout 等待服务器响应的默认时间
+const DefaultTimeout = 5 * time.Second
+
+// ErrTimeout ExpectMatch 和 Next 在 Timeout 内没有等到数据包
+var ErrTimeout = errors.New("等待超时")
+
+// DefaultChunkSize SendFile 每个文件数据包携带的字节数
+const DefaultChunkSize = 64 * 1024
+
+// Packet 客户端收到的一个数据包
+type Packet struct {
+	Type    uint32                 // 包类型，见 frame.Type*
+	Payload []byte                 // 原始消息体
+	Msg     map[string]interface{} // JSON包和文件头包解析后的内容，文件数据包为nil
+}
+
+// MsgType 消息的type字段，文件数据包返回空字符串
+func (p Packet) MsgType() string {
+	s, _ := p.Msg["type"].(string)
+	return s
+}
+
+// String 返回消息中指定字段的字符串形式，数字按十进制输出
+func (p Packet) String(key string) string {
+	switch v := p.Msg[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Client 一个聊天连接
+type Client struct {
+	ID      string            // 登录成功后的用户ID
+	Name    string            // 登录使用的用户名
+	Friends []user.FriendInfo // 登录时服务器下发的好友列表
+	Timeout time.Duration     // Expect 等待的最长时间，为0时使用 DefaultTimeout
+
+	conn    net.Conn
+	packets chan Packet
+
+	mu      sync.Mutex
+	backlog []Packet // 已读取但尚未被 Expect 取走的数据包
+	readErr error    // 读取协程退出的原因
+}
+
+// Dial 建立TCP连接并开始在后台读取数据包，此时尚未登录
+func Dial(addr string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, DefaultTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("连接服务器失败: %v", err)
+	}
+	c := &Client{conn: conn, packets: make(chan Packet, 256)}
+	go c.readLoop()
+	return c, nil
+}
+
+// readLoop 持续读取数据包直到连接关闭
+func (c *Client) readLoop() {
+	defer close(c.packets)
+	for {
+		typ, payload, err := frame.Read(c.conn)
+		if err != nil {
+			c.mu.Lock()
+			c.readErr = err
+			c.mu.Unlock()
+			return
+		}
+		p := Packet{Type: typ, Payload: payload}
+		if typ != frame.TypeFileChunk {
+			_ = json.Unmarshal(payload, &p.Msg)
+		}
+		c.packets <- p
+	}
+}
+
+// Register 注册新用户，返回用户ID
+// 服务器处理完注册请求后会关闭连接，登录需要另外建立连接
+func Register(addr, name, password string) (string, error) {
+	c, err := Dial(addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	if err := c.Send(map[string]string{"type": "register", "name": name, "pwd": password}); err != nil {
+		return "", err
+	}
+	resp, err := c.Expect("register_response")
+	if err != nil {
+		return "", err
+	}
+	if resp.String("status") != "success" {
+		return "", fmt.Errorf("注册失败: %s", resp.String("message"))
+	}
+	return resp.String("userid"), nil
+}
+
+// Login 建立连接并登录，成功后读取好友列表
+// 登录被拒绝时返回的错误包含服务器的提示信息
+func Login(addr, name, password string) (*Client, error) {
+	c, err := Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.login(name, password); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) login(name, password string) error {
+	if err := c.Send(map[string]string{"type": "login", "name": name, "pwd": password}); err != nil {
+		return err
+	}
+	resp, err := c.Expect("login_response")
+	if err != nil {
+		return err
+	}
+	if success, _ := resp.Msg["success"].(bool); !success {
+		return fmt.Errorf("登录失败: %s", resp.String("message"))
+	}
+	id, ok := strings.CutPrefix(resp.String("message"), "id:")
+	if !ok {
+		return fmt.Errorf("无法识别的登录响应: %s", resp.String("message"))
+	}
+	c.ID = id
+	c.Name = name
+
+	list, err := c.Expect("friend_list")
+	if err != nil {
+		return err
+	}
+	var friends user.FriendListMessage
+	if err := json.Unmarshal(list.Payload, &friends); err != nil {
+		return fmt.Errorf("解析好友列表失败: %v", err)
+	}
+	c.Friends = friends.Friends
+	return nil
+}
+
+// Close 关闭连接
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// LocalAddr 连接的本地地址
+func (c *Client) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// Send 发送一条JSON消息(type=1)
+func (c *Client) Send(v interface{}) error {
+	return frame.WriteJSON(c.conn, v)
+}
+
+// SendRaw 发送任意类型的数据包，用于构造异常输入
+func (c *Client) SendRaw(packetType uint32, payload []byte) error {
+	return frame.Write(c.conn, packetType, payload)
+}
+
+// SendMessage 向 receiverID 发送聊天消息
+func (c *Client) SendMessage(receiverID, content string) error {
+	return c.Send(map[string]string{
+		"type":      "message",
+		"receiveid": receiverID,
+		"content":   content,
+		"sendTime":  time.Now().Format(time.RFC3339Nano),
+	})
+}
+
+// AddFriend 按用户名发送好友请求
+func (c *Client) AddFriend(name string) error {
+	return c.Send(map[string]interface{}{"type": "addfriend", "addname": name, "addid": nil})
+}
+
+// AcceptFriend 接受 name 发来的好友请求
+func (c *Client) AcceptFriend(name string) error {
+	return c.Send(map[string]string{"type": "acceptfriend", "addname": name})
+}
+
+// FileMessage 发送文件相关的JSON消息，如 file_accept、file_decline、file_cancel
+func (c *Client) FileMessage(msgType, transferID string) error {
+	return c.Send(map[string]string{"type": msgType, "transferid": transferID})
+}
+
+// SendFile 向 receiverID 上传文件：先发文件头(type=3)，再按 chunkSize 分块发送文件数据(type=2)
+// chunkSize 不大于0时使用 DefaultChunkSize；是否上传成功以随后收到的 file_uploaded 或 file_error 为准
+func (c *Client) SendFile(receiverID, transferID, filename string, data []byte, chunkSize int) error {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	sum := sha256.Sum256(data)
+	header, err := json.Marshal(map[string]string{
+		"type":       "file_transfer",
+		"transferid": transferID,
+		"filename":   filename,
+		"size":       strconv.Itoa(len(data)),
+		"receiveid":  receiverID,
+		"sha256":     hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		return fmt.Errorf("序列化文件头失败: %v", err)
+	}
+	if err := c.SendRaw(frame.TypeFileHeader, header); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(data); start += chunkSize {
+		end := start + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := c.SendRaw(frame.TypeFileChunk, filetransfer.EncodeChunk(transferID, data[start:end])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReceiveFile 确认文件邀约(file_accept)并收齐服务器下发的文件数据，校验文件通知中的SHA-256
+func (c *Client) ReceiveFile(transferID string) ([]byte, error) {
+	if err := c.FileMessage("file_accept", transferID); err != nil {
+		return nil, err
+	}
+	notify, err := c.ExpectMatch(func(p Packet) bool {
+		return p.MsgType() == "file_notify" && p.String("transferid") == transferID
+	})
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.Atoi(notify.String("size"))
+	if err != nil {
+		return nil, fmt.Errorf("文件大小格式错误: %q", notify.String("size"))
+	}
+
+	data := make([]byte, 0, size)
+	for len(data) < size {
+		p, err := c.ExpectMatch(func(p Packet) bool {
+			if p.Type != frame.TypeFileChunk {
+				return false
+			}
+			id, _, err := filetransfer.DecodeChunk(p.Payload)
+			return err == nil && id == transferID
+		})
+		if err != nil {
+			return nil, fmt.Errorf("已收到 %d/%d 字节: %v", len(data), size, err)
+		}
+		_, chunk, _ := filetransfer.DecodeChunk(p.Payload)
+		data = append(data, chunk...)
+	}
+
+	sum := sha256.Sum256(data)
+	if want := notify.String("sha256"); want != "" && want != hex.EncodeToString(sum[:]) {
+		return nil, errors.New("文件校验失败")
+	}
+	return data, nil
+}
+
+// Expect 等待指定类型的消息
+func (c *Client) Expect(msgType string) (Packet, error) {
+	p, err := c.ExpectMatch(func(p Packet) bool { return p.MsgType() == msgType })
+	if err != nil {
+		return Packet{}, fmt.Errorf("等待 %s 失败: %v", msgType, err)
+	}
+	return p, nil
+}
+
+// ExpectMatch 等待第一个满足 match 的数据包，不满足的数据包留给之后的 Expect
+func (c *Client) ExpectMatch(match func(Packet) bool) (Packet, error) {
+	c.mu.Lock()
+	for i, p := range c.backlog {
+		if match(p) {
+			c.backlog = append(c.backlog[:i], c.backlog[i+1:]...)
+			c.mu.Unlock()
+			return p, nil
+		}
+	}
+	c.mu.Unlock()
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case p, ok := <-c.packets:
+			if !ok {
+				c.mu.Lock()
+				err := c.readErr
+				c.mu.Unlock()
+				return Packet{}, fmt.Errorf("连接已关闭: %v", err)
+			}
+			if match(p) {
+				return p, nil
+			}
+			c.mu.Lock()
+			c.backlog = append(c.backlog, p)
+			c.mu.Unlock()
+		case <-timer.C:
+			return Packet{}, ErrTimeout
+		}
+	}
+}
+
+// Next 等待下一个数据包，包括之前 Expect 跳过的
+func (c *Client) Next() (Packet, error) {
+	return c.ExpectMatch(func(Packet) bool { return true })
+}
+
+// Drain 丢弃已收到但尚未取走的数据包，返回丢弃的数量
+func (c *Client) Drain() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.backlog)
+	c.backlog = nil
+	for {
+		select {
+		case _, ok := <-c.packets:
+			if !ok {
+				return n
+			}
+			n++
+		default:
+			return n
+		}
+	}
+}
+
+// WaitClosed 等待服务器关闭连接，期间收到的数据包保留给 Expect
+func (c *Client) WaitClosed() error {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case p, ok := <-c.packets:
+			if !ok {
+				return nil
+			}
+			c.mu.Lock()
+			c.backlog = append(c.backlog, p)
+			c.mu.Unlock()
+		case <-timer.C:
+			return errors.New("等待连接关闭超时")
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+//管理后台账号：列出和创建，用于首次部署时创建管理员
+import (
+	"connection_server_linux/databasetool"
+	"connection_server_linux/logincheck"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+func adminList(db *gorm.DB, args []string) error {
+	fs := newFlags("admin list")
+	asJSON := fs.Bool("json", false, "以JSON输出")
+	if rest, err := parseFlags(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usagef("多余的参数: %v", rest)
+	}
+
+	accounts, err := databasetool.ListAdminAccounts(db)
+	if err != nil {
+		return fmt.Errorf("查询管理员失败: %v", err)
+	}
+	if *asJSON {
+		return writeJSON(accounts)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tROLE\tCREATED\tCREATED BY")
+	for _, a := range accounts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.Name, a.Role, formatTime(a.CreatedAt), a.CreatedBy)
+	}
+	return w.Flush()
+}
+
+func adminCreate(db *gorm.DB, args []string) error {
+	fs := newFlags("admin create")
+	roleName := fs.String("role", string(logincheck.RoleAdmin), "角色: viewer、operator 或 admin")
+	passwordArg := passwordArgFlag(fs)
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *passwordArg && len(rest) != 2 {
+		return usagef("需要用户名和密码")
+	} else if !*passwordArg && len(rest) != 1 {
+		return usagef("需要用户名，密码从标准输入读取，或使用 -password-arg 在用户名后给出")
+	}
+	name := strings.TrimSpace(rest[0])
+	password, err := readPassword(*passwordArg, rest[len(rest)-1])
+	if err != nil {
+		return err
+	}
+	if name == "" || utf8.RuneCountInString(name) > databasetool.MaxUserNameLength {
+		return usagef("用户名不能为空且不能超过%d个字符", databasetool.MaxUserNameLength)
+	}
+	if len(password) < logincheck.MinAdminPasswordLength {
+		return usagef("密码不能少于%d个字符", logincheck.MinAdminPasswordLength)
+	}
+	role, err := logincheck.ParseRole(*roleName)
+	if err != nil {
+		return usagef("%v", err)
+	}
+
+	count, err := databasetool.CountAdminAccounts(db, "")
+	if err != nil {
+		return fmt.Errorf("查询管理员失败: %v", err)
+	}
+	if count == 0 && role != logincheck.RoleAdmin {
+		return usagef("第一个账号必须是管理员角色")
+	}
+	if _, err := databasetool.FindAdminAccount(db, name); err == nil {
+		return fmt.Errorf("管理员 %s 已存在", name)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("查询管理员失败: %v", err)
+	}
+
+	hash, err := logincheck.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if _, err := databasetool.CreateAdminAccount(db, name, hash, string(role), actor()); err != nil {
+		return fmt.Errorf("创建管理员失败: %v", err)
+	}
+	audit(db, databasetool.AuditCreateAdmin, "admin:"+name, "角色: "+string(role))
+	fmt.Printf("已创建管理后台账号 %s，角色 %s\n", name, role)
+	if count == 0 {
+		fmt.Println("这是第一个管理员账号，内置管理员已不能再登录，已有的内置管理员会话在过期前仍然有效")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+//暂存消息(Unsendchat)的查看和清理
+import (
+	"connection_server_linux/databasetool"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// backlogView 暂存消息列表中的一项
+type backlogView struct {
+	LogID    int       `json:"logid"`
+	SendID   string    `json:"sendid"`
+	ReciveID string    `json:"reciveid"`
+	Kind     string    `json:"kind"`
+	Content  string    `json:"content"`
+	SendTime time.Time `json:"send_time"`
+}
+
+// backlogKind 按内容前缀区分暂存消息的类型
+func backlogKind(content string) string {
+	switch {
+	case strings.HasPrefix(content, "file:"):
+		return "file"
+	case strings.HasPrefix(content, "addfriend_request:"):
+		return "friend_request"
+	case strings.HasPrefix(content, "friend_accepted:"):
+		return "friend_accepted"
+	case strings.HasPrefix(content, "announcement:"):
+		return "announcement"
+	default:
+		return "message"
+	}
+}
+
+// receiverFilter 把 -user 参数转换为接收者ID，为空时不过滤
+func receiverFilter(db *gorm.DB, ref string) (string, error) {
+	if ref == "" {
+		return "", nil
+	}
+	record, err := findUser(db, ref)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(uint64(record.ID), 10), nil
+}
+
+func backlogList(db *gorm.DB, args []string) error {
+	fs := newFlags("backlog list")
+	userRef := fs.String("user", "", "只列出发给该用户的暂存消息")
+	limit := fs.Int("limit", 0, "最多列出的条数，0为不限制")
+	asJSON := fs.Bool("json", false, "以JSON输出，包含完整内容")
+	if rest, err := parseFlags(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usagef("多余的参数: %v", rest)
+	}
+
+	reciveid, err := receiverFilter(db, *userRef)
+	if err != nil {
+		return err
+	}
+	chats, err := databasetool.ListUnsendChats(db, reciveid, *limit)
+	if err != nil {
+		return fmt.Errorf("查询暂存消息失败: %v", err)
+	}
+	views := make([]backlogView, 0, len(chats))
+	for _, c := range chats {
+		views = append(views, backlogView{LogID: c.Logid, SendID: c.Sendid, ReciveID: c.Reciveid, Kind: backlogKind(c.Content), Content: c.Content, SendTime: c.SendTime})
+	}
+	if *asJSON {
+		return writeJSON(views)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LOGID\tFROM\tTO\tSENT\tKIND\tCONTENT")
+	for _, v := range views {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", v.LogID, v.SendID, v.ReciveID, formatTime(v.SendTime), v.Kind, preview(v.Content, 40))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("共 %d 条\n", len(views))
+	return nil
+}
+
+func backlogPurge(db *gorm.DB, args []string) error {
+	fs := newFlags("backlog purge")
+	userRef := fs.String("user", "", "只删除发给该用户的暂存消息")
+	olderThan := fs.Duration("older-than", 0, "只删除早于该时长之前发送的，如 720h")
+	yes := fs.Bool("yes", false, "确认删除")
+	if rest, err := parseFlags(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usagef("多余的参数: %v", rest)
+	}
+	if !*yes {
+		return usagef("删除不可恢复，请加上 -yes 确认")
+	}
+	if *olderThan < 0 {
+		return usagef("-older-than 不能为负数")
+	}
+
+	reciveid, err := receiverFilter(db, *userRef)
+	if err != nil {
+		return err
+	}
+	var before time.Time
+	if *olderThan > 0 {
+		before = time.Now().Add(-*olderThan)
+	}
+	count, err := databasetool.PurgeUnsendChats(db, reciveid, before)
+	if err != nil {
+		return fmt.Errorf("删除暂存消息失败: %v", err)
+	}
+	fmt.Printf("已删除 %d 条暂存消息\n", count)
+	return nil
+}
+
+// preview 截断过长的内容，按字符计数
+func preview(s string, n int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
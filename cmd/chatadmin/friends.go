@@ -0,0 +1,122 @@
+package main
+
+//好友关系图：按每个用户的关系字节输出有向边
+import (
+	"connection_server_linux/databasetool"
+	"connection_server_linux/friendupdate"
+	"fmt"
+	"os"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+var relationNames = map[int]string{
+	friendupdate.Friend:  "friend",
+	friendupdate.Pending: "pending",
+	friendupdate.Blocked: "blocked",
+}
+
+// friendNode 关系图中的用户
+type friendNode struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// friendEdge 关系图中的一条边，表示 From 的关系字节中记录的与 To 的关系
+type friendEdge struct {
+	From     uint   `json:"from"`
+	To       uint   `json:"to"`
+	Relation string `json:"relation"`
+}
+
+type friendGraph struct {
+	Users []friendNode `json:"users"`
+	Edges []friendEdge `json:"edges"`
+}
+
+func friendsDump(db *gorm.DB, args []string) error {
+	fs := newFlags("friends")
+	format := fs.String("format", "text", "输出格式: text、json 或 dot")
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 1 {
+		return usagef("多余的参数: %v", rest[1:])
+	}
+	if *format != "text" && *format != "json" && *format != "dot" {
+		return usagef("未知的输出格式: %s", *format)
+	}
+
+	users, _, err := databasetool.ListUsers(db, "", 0, -1)
+	if err != nil {
+		return fmt.Errorf("查询用户失败: %v", err)
+	}
+	names := make(map[uint]string, len(users))
+	for _, u := range users {
+		names[u.ID] = u.Name
+	}
+
+	// 指定用户时只输出与他相关的边，包括其他用户指向他的边
+	var only uint
+	if len(rest) == 1 {
+		record, err := findUser(db, rest[0])
+		if err != nil {
+			return err
+		}
+		only = record.ID
+	}
+
+	graph := friendGraph{Users: []friendNode{}, Edges: []friendEdge{}}
+	related := make(map[uint]bool)
+	for _, u := range users {
+		statuses := friendupdate.AnalyzeRelationByte(u.Relation)
+		for i := 1; i < len(statuses); i++ {
+			name, ok := relationNames[statuses[i]]
+			if !ok {
+				continue
+			}
+			edge := friendEdge{From: u.ID, To: uint(i), Relation: name}
+			if only != 0 && edge.From != only && edge.To != only {
+				continue
+			}
+			graph.Edges = append(graph.Edges, edge)
+			related[edge.From] = true
+			related[edge.To] = true
+		}
+	}
+	for _, u := range users {
+		if only == 0 || related[u.ID] || u.ID == only {
+			graph.Users = append(graph.Users, friendNode{ID: u.ID, Name: u.Name})
+		}
+	}
+
+	switch *format {
+	case "json":
+		return writeJSON(graph)
+	case "dot":
+		fmt.Println("digraph friends {")
+		for _, n := range graph.Users {
+			fmt.Printf("  %d [label=%s];\n", n.ID, strconv.Quote(fmt.Sprintf("%d %s", n.ID, n.Name)))
+		}
+		for _, e := range graph.Edges {
+			fmt.Printf("  %d -> %d [label=%q];\n", e.From, e.To, e.Relation)
+		}
+		fmt.Println("}")
+	default:
+		for _, e := range graph.Edges {
+			fmt.Fprintf(os.Stdout, "%s -> %s  %s\n", nodeLabel(names, e.From), nodeLabel(names, e.To), e.Relation)
+		}
+		fmt.Printf("共 %d 个用户，%d 条关系\n", len(graph.Users), len(graph.Edges))
+	}
+	return nil
+}
+
+// nodeLabel 输出 ID(用户名)，关系指向已删除的用户时标记出来
+func nodeLabel(names map[uint]string, id uint) string {
+	if name, ok := names[id]; ok {
+		return fmt.Sprintf("%d(%s)", id, name)
+	}
+	return fmt.Sprintf("%d(已删除)", id)
+}
@@ -0,0 +1,216 @@
+// chatadmin 聊天服务器的离线管理工具，直接通过 databasetool 操作SQLite数据库
+//
+//	chatadmin [-db communication.db] <命令> [参数]
+//
+// 所有修改都会写入审计日志，操作者为 cli:<系统用户名>。
+// 需要密码的命令默认在终端无回显输入，或通过管道从标准输入读取第一行；
+// 只有指定 -password-arg 时才接受命令行参数中的密码。
+// 服务器运行时也可以使用，但不会断开在线客户端：被删除或重置密码的用户下次登录时才生效，
+// 需要立即生效时请使用管理后台。
+package main
+
+import (
+	"connection_server_linux/config"
+	"connection_server_linux/databasetool"
+	"connection_server_linux/logging"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	osuser "os/user"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// command 一个子命令，args 为命令名之后的参数
+type command struct {
+	name   string
+	usage  string
+	run    func(db *gorm.DB, args []string) error
+	create bool // 数据库文件不存在时是否创建，其余命令拒绝执行以免拼错路径时生成空库
+}
+
+var commands = []command{
+	{"user list", "[-q 关键字] [-json]  列出用户", userList, false},
+	{"user create", "<用户名> [-password-arg <密码>]  创建用户", userCreate, true},
+	{"user delete", "<ID|用户名>  删除用户及发给他的暂存消息，并清除其他用户与他的关系", userDelete, false},
+	{"user passwd", "<ID|用户名> [-password-arg <新密码>]  重置用户密码", userPasswd, false},
+	{"backlog list", "[-user ID|用户名] [-limit N] [-json]  列出暂存消息", backlogList, false},
+	{"backlog purge", "[-user ID|用户名] [-older-than 时长] -yes  删除暂存消息", backlogPurge, false},
+	{"friends", "[ID|用户名] [-format text|json|dot]  输出好友关系图", friendsDump, false},
+	{"admin list", "[-json]  列出管理后台账号", adminList, false},
+	{"admin create", "<用户名> [-password-arg <密码>] [-role viewer|operator|admin]  创建管理后台账号", adminCreate, true},
+	{"export", "[-o 文件]  导出用户、暂存消息和管理后台账号为JSON", exportData, false},
+	{"import", "[-i 文件]  从导出文件导入，ID或用户名冲突时整体回滚", importData, true},
+}
+
+// usageError 参数错误，退出码为2
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...interface{}) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "用法: chatadmin [-db 数据库文件] <命令> [参数]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "命令:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "数据库文件默认取环境变量 CHAT_DB，未设置时为", config.Default().Database)
+	fmt.Fprintln(w, "密码默认在终端无回显输入或从标准输入读取第一行，-password-arg 时才从命令行参数读取")
+}
+
+// findCommand 按最长匹配查找子命令，返回命令和剩余参数
+func findCommand(args []string) (*command, []string) {
+	for i := range commands {
+		words := strings.Fields(commands[i].name)
+		if len(args) < len(words) {
+			continue
+		}
+		if strings.Join(args[:len(words)], " ") == commands[i].name {
+			return &commands[i], args[len(words):]
+		}
+	}
+	return nil, nil
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("chatadmin", flag.ContinueOnError)
+	fs.Usage = func() { printUsage(fs.Output()) }
+	dbPath := fs.String("db", defaultDatabase(), "SQLite数据库文件")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	cmd, rest := findCommand(fs.Args())
+	if cmd == nil {
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	// SQL日志只输出警告(慢查询)和错误，避免干扰命令输出
+	if _, err := logging.Setup(os.Stderr, "warn", "text"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if _, err := os.Stat(*dbPath); err != nil && !cmd.create {
+		fmt.Fprintf(os.Stderr, "数据库文件不存在: %s\n", *dbPath)
+		return 1
+	}
+	db, err := databasetool.OpenDBWithPool(*dbPath, databasetool.PoolConfig{MaxIdleConns: 1, MaxOpenConns: 1})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "打开数据库失败: %v\n", err)
+		return 1
+	}
+	defer func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}()
+
+	if err := cmd.run(db, rest); err != nil {
+		var usage usageError
+		if errors.As(err, &usage) || errors.Is(err, flag.ErrHelp) {
+			if !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			fmt.Fprintf(os.Stderr, "用法: chatadmin %s %s\n", cmd.name, cmd.usage)
+			return 2
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// defaultDatabase 与服务器一致：环境变量 CHAT_DB，未设置时为配置的默认值
+func defaultDatabase() string {
+	if dsn := os.Getenv("CHAT_DB"); dsn != "" {
+		return dsn
+	}
+	return config.Default().Database
+}
+
+// newFlags 创建子命令的参数解析器，错误信息由 run 统一输出
+func newFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parseFlags 解析子命令参数，允许参数出现在位置参数之后，如 "user list -json"
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, usagef("%v", err)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// findUser 按ID或用户名查找用户：纯数字先按ID查找，找不到再按用户名
+func findUser(db *gorm.DB, ref string) (*databasetool.User, error) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		record, err := databasetool.FindUserById(db, id)
+		if err == nil {
+			return record, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("查询用户失败: %v", err)
+		}
+	}
+	record, err := databasetool.FindUserByName(db, ref)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("用户 %s 不存在", ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询用户失败: %v", err)
+	}
+	return record, nil
+}
+
+// actor 审计日志中的操作者
+func actor() string {
+	if u, err := osuser.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
+// audit 写入审计日志，与管理后台使用相同的操作类型
+func audit(db *gorm.DB, action, target string, detail string) {
+	entry := &databasetool.AuditLog{
+		Action:  action,
+		Actor:   actor(),
+		Target:  target,
+		Success: true,
+		Detail:  detail,
+	}
+	if err := databasetool.CreateAuditLog(db, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "写入审计日志失败: %v\n", err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"connection_server_linux/databasetool"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// withStdin 将 input 作为标准输入运行 fn
+func withStdin(t *testing.T, input string, fn func()) {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = old }()
+	fn()
+}
+
+// openDB 打开 run 修改过的数据库以检查结果
+func openDB(t *testing.T, path string) *gorm.DB {
+	t.Helper()
+	db, err := databasetool.OpenDB(path)
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestRunUserCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.db")
+
+	if code := run([]string{"-db", path, "user", "list"}); code != 1 {
+		t.Fatalf("数据库不存在时 user list 退出码 = %d, 期望 1", code)
+	}
+
+	cases := []struct {
+		name  string
+		args  []string
+		stdin string
+		code  int
+	}{
+		{"从标准输入读取密码", []string{"user", "create", "alice"}, "alice-pwd\n", 0},
+		{"显式从参数读取密码", []string{"user", "create", "bob", "-password-arg", "bob-pwd"}, "", 0},
+		{"未加参数时不接受位置参数中的密码", []string{"user", "create", "carol", "carol-pwd"}, "", 2},
+		{"标准输入为空", []string{"user", "create", "carol"}, "", 1},
+		{"用户名已存在", []string{"user", "create", "alice"}, "other\n", 1},
+		{"重置密码", []string{"user", "passwd", "bob"}, "bob-new\r\n", 0},
+		{"删除用户", []string{"user", "delete", "alice"}, "", 0},
+		{"删除不存在的用户", []string{"user", "delete", "alice"}, "", 1},
+	}
+	for _, c := range cases {
+		var code int
+		withStdin(t, c.stdin, func() {
+			code = run(append([]string{"-db", path}, c.args...))
+		})
+		if code != c.code {
+			t.Fatalf("%s: 退出码 = %d, 期望 %d", c.name, code, c.code)
+		}
+	}
+
+	db := openDB(t, path)
+	if _, err := databasetool.FindUserByName(db, "alice"); err == nil {
+		t.Fatal("alice 删除后仍存在")
+	}
+	if _, err := databasetool.FindUserByName(db, "carol"); err == nil {
+		t.Fatal("carol 不应被创建")
+	}
+	bob, err := databasetool.FindUserByName(db, "bob")
+	if err != nil {
+		t.Fatalf("查询 bob 失败: %v", err)
+	}
+	if bob.Password != "bob-new" {
+		t.Fatalf("bob 的密码 = %q, 期望 bob-new", bob.Password)
+	}
+
+	logs, _, err := databasetool.QueryAuditLogs(db, databasetool.AuditFilter{}, 0, -1)
+	if err != nil {
+		t.Fatalf("查询审计日志失败: %v", err)
+	}
+	var actions []string
+	for _, entry := range logs {
+		if !strings.HasPrefix(entry.Actor, "cli") {
+			t.Errorf("%s 的操作者 = %q, 期望 cli:<系统用户名>", entry.Action, entry.Actor)
+		}
+		actions = append(actions, entry.Action)
+	}
+	// 按时间倒序
+	want := []string{databasetool.AuditDeleteUser, databasetool.AuditChangePassword, databasetool.AuditRegister, databasetool.AuditRegister}
+	if strings.Join(actions, ",") != strings.Join(want, ",") {
+		t.Fatalf("审计日志 = %v, 期望 %v", actions, want)
+	}
+}
+
+func TestRunExportImport(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.db")
+	dst := filepath.Join(dir, "dst.db")
+	backup := filepath.Join(dir, "backup.json")
+
+	for _, name := range []string{"alice", "bob"} {
+		if code := run([]string{"-db", src, "user", "create", name, "-password-arg", name + "-pwd"}); code != 0 {
+			t.Fatalf("创建 %s 退出码 = %d", name, code)
+		}
+	}
+	if code := run([]string{"-db", src, "admin", "create", "root", "-password-arg", "long-password"}); code != 0 {
+		t.Fatalf("创建管理员退出码 = %d", code)
+	}
+
+	if code := run([]string{"-db", src, "export", "-o", backup}); code != 0 {
+		t.Fatalf("export 退出码 = %d", code)
+	}
+	if info, err := os.Stat(backup); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("导出文件权限 = %v, %v, 期望 0600", info.Mode().Perm(), err)
+	}
+	if code := run([]string{"-db", dst, "import", "-i", backup}); code != 0 {
+		t.Fatalf("import 退出码 = %d", code)
+	}
+	// 再次导入时ID冲突，整体回滚
+	if code := run([]string{"-db", dst, "import", "-i", backup}); code != 1 {
+		t.Fatalf("重复 import 退出码 = %d, 期望 1", code)
+	}
+
+	srcDB, dstDB := openDB(t, src), openDB(t, dst)
+	for _, name := range []string{"alice", "bob"} {
+		want, err := databasetool.FindUserByName(srcDB, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := databasetool.FindUserByName(dstDB, name)
+		if err != nil {
+			t.Fatalf("导入后查询 %s 失败: %v", name, err)
+		}
+		if got.ID != want.ID || got.Password != want.Password {
+			t.Errorf("导入后 %s = ID %d 密码 %q, 期望 ID %d 密码 %q", name, got.ID, got.Password, want.ID, want.Password)
+		}
+	}
+	if count, err := databasetool.CountAdminAccounts(dstDB, ""); err != nil || count != 1 {
+		t.Fatalf("导入后管理员数量 = %d, %v, 期望 1", count, err)
+	}
+}
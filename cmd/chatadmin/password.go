@@ -0,0 +1,108 @@
+package main
+
+//读取密码：默认从终端无回显输入或从管道读取，避免密码留在shell历史和进程列表中
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// passwordArgFlag 添加 -password-arg 参数，指定后密码作为最后一个位置参数传入
+func passwordArgFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("password-arg", false, "从命令行参数读取密码(会留在shell历史和进程列表中)")
+}
+
+// readPassword 读取密码：fromArg 时取 arg，标准输入是终端时无回显提示两次，否则读取标准输入的第一行
+func readPassword(fromArg bool, arg string) (string, error) {
+	if fromArg {
+		return arg, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if _, err := getTermios(fd); err != nil {
+		password, err := readLine(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("从标准输入读取密码失败: %v", err)
+		}
+		return password, nil
+	}
+
+	fmt.Fprint(os.Stderr, "密码: ")
+	password, err := readNoEcho(fd)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "再次输入: ")
+	confirm, err := readNoEcho(fd)
+	if err != nil {
+		return "", err
+	}
+	if password != confirm {
+		return "", errors.New("两次输入的密码不一致")
+	}
+	return password, nil
+}
+
+// readLine 逐字节读取一行，不多读后续内容，去掉行尾的换行符
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF {
+			if len(line) == 0 {
+				return "", errors.New("没有输入")
+			}
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
+
+// readNoEcho 关闭终端回显读取一行，读取后恢复终端设置
+func readNoEcho(fd int) (string, error) {
+	old, err := getTermios(fd)
+	if err != nil {
+		return "", fmt.Errorf("读取终端设置失败: %v", err)
+	}
+	noEcho := *old
+	noEcho.Lflag &^= syscall.ECHO
+	noEcho.Lflag |= syscall.ICANON | syscall.ISIG
+	if err := setTermios(fd, &noEcho); err != nil {
+		return "", fmt.Errorf("关闭终端回显失败: %v", err)
+	}
+	defer func() {
+		setTermios(fd, old)
+		fmt.Fprintln(os.Stderr)
+	}()
+	return readLine(os.Stdin)
+}
+
+// getTermios 获取终端设置，fd 不是终端时返回错误
+func getTermios(fd int) (*syscall.Termios, error) {
+	var t syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCGETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		return nil, errno
+	}
+	return &t, nil
+}
+
+func setTermios(fd int, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCSETS, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+//导出和导入：用于迁移或备份，格式见 databasetool.Snapshot
+import (
+	"connection_server_linux/databasetool"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"gorm.io/gorm"
+)
+
+func exportData(db *gorm.DB, args []string) error {
+	fs := newFlags("export")
+	output := fs.String("o", "-", "输出文件，- 为标准输出")
+	if rest, err := parseFlags(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usagef("多余的参数: %v", rest)
+	}
+
+	snap, err := databasetool.ExportSnapshot(db)
+	if err != nil {
+		return err
+	}
+
+	// 导出文件包含用户密码和管理员密码哈希，只允许所有者读写
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("创建导出文件失败: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(snap); err != nil {
+		return fmt.Errorf("写入导出文件失败: %v", err)
+	}
+	if *output != "-" {
+		fmt.Fprintf(os.Stderr, "已导出 %d 个用户、%d 条暂存消息、%d 个管理员到 %s\n", len(snap.Users), len(snap.Unsendchats), len(snap.Admins), *output)
+	}
+	return nil
+}
+
+func importData(db *gorm.DB, args []string) error {
+	fs := newFlags("import")
+	input := fs.String("i", "-", "导入文件，- 为标准输入")
+	if rest, err := parseFlags(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usagef("多余的参数: %v", rest)
+	}
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return fmt.Errorf("打开导入文件失败: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	var snap databasetool.Snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("解析导入文件失败: %v", err)
+	}
+
+	stats, err := databasetool.ImportSnapshot(db, &snap)
+	if err != nil {
+		return fmt.Errorf("导入失败，未做任何修改: %v", err)
+	}
+	fmt.Printf("已导入 %d 个用户、%d 条暂存消息、%d 个管理员\n", stats.Users, stats.Unsendchats, stats.Admins)
+	return nil
+}
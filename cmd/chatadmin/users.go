@@ -0,0 +1,178 @@
+package main
+
+//用户管理：列出、创建、删除和重置密码
+import (
+	"connection_server_linux/databasetool"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// userView 用户列表中的一项
+type userView struct {
+	ID           uint      `json:"id"`
+	Name         string    `json:"name"`
+	Ip           string    `json:"ip"`
+	RegisterTime time.Time `json:"register_time"`
+	LeaveTime    time.Time `json:"leave_time"`
+	Disabled     bool      `json:"disabled"`
+}
+
+func userList(db *gorm.DB, args []string) error {
+	fs := newFlags("user list")
+	query := fs.String("q", "", "按用户名模糊匹配或按ID精确匹配")
+	asJSON := fs.Bool("json", false, "以JSON输出")
+	if rest, err := parseFlags(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usagef("多余的参数: %v", rest)
+	}
+
+	users, _, err := databasetool.ListUsers(db, *query, 0, -1)
+	if err != nil {
+		return fmt.Errorf("查询用户失败: %v", err)
+	}
+	views := make([]userView, 0, len(users))
+	for _, u := range users {
+		views = append(views, userView{ID: u.ID, Name: u.Name, Ip: u.Ip, RegisterTime: u.RegisterTime, LeaveTime: u.LeaveTime, Disabled: u.Disabled})
+	}
+	if *asJSON {
+		return writeJSON(views)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tIP\tREGISTERED\tLAST SEEN\tDISABLED")
+	for _, v := range views {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%t\n", v.ID, v.Name, v.Ip, formatTime(v.RegisterTime), formatTime(v.LeaveTime), v.Disabled)
+	}
+	return w.Flush()
+}
+
+func userCreate(db *gorm.DB, args []string) error {
+	fs := newFlags("user create")
+	passwordArg := passwordArgFlag(fs)
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *passwordArg && len(rest) != 2 {
+		return usagef("需要用户名和密码")
+	} else if !*passwordArg && len(rest) != 1 {
+		return usagef("需要用户名，密码从标准输入读取，或使用 -password-arg 在用户名后给出")
+	}
+	name := rest[0]
+	password, err := readPassword(*passwordArg, rest[len(rest)-1])
+	if err != nil {
+		return err
+	}
+	if err := validateUser(name, password); err != nil {
+		return err
+	}
+
+	if _, err := databasetool.FindUserByName(db, name); err == nil {
+		return fmt.Errorf("用户名 %s 已存在", name)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("查询用户名失败: %v", err)
+	}
+	id, err := databasetool.RegisterUser(db, name, password, "")
+	if err != nil {
+		return fmt.Errorf("创建用户失败: %v", err)
+	}
+	audit(db, databasetool.AuditRegister, strconv.FormatUint(uint64(id), 10), "命令行创建，用户名: "+name)
+	fmt.Printf("已创建用户 %s，ID %d\n", name, id)
+	return nil
+}
+
+func userDelete(db *gorm.DB, args []string) error {
+	rest, err := parseFlags(newFlags("user delete"), args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return usagef("需要用户ID或用户名")
+	}
+	record, err := findUser(db, rest[0])
+	if err != nil {
+		return err
+	}
+
+	id := int(record.ID)
+	idStr := strconv.Itoa(id)
+	if err := databasetool.DeleteUser(db, id); err != nil {
+		return fmt.Errorf("删除用户失败: %v", err)
+	}
+	if err := databasetool.DeleteUnsendChatsByReciveID(db, idStr); err != nil {
+		fmt.Fprintf(os.Stderr, "删除用户的暂存消息失败: %v\n", err)
+	}
+	if err := databasetool.ClearRelations(db, id, record.Relation); err != nil {
+		fmt.Fprintf(os.Stderr, "清除好友关系失败: %v\n", err)
+	}
+	audit(db, databasetool.AuditDeleteUser, idStr, "用户名: "+record.Name)
+	fmt.Printf("已删除用户 %s(ID %d)\n", record.Name, id)
+	return nil
+}
+
+func userPasswd(db *gorm.DB, args []string) error {
+	fs := newFlags("user passwd")
+	passwordArg := passwordArgFlag(fs)
+	rest, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *passwordArg && len(rest) != 2 {
+		return usagef("需要用户ID或用户名和新密码")
+	} else if !*passwordArg && len(rest) != 1 {
+		return usagef("需要用户ID或用户名，新密码从标准输入读取，或使用 -password-arg 在用户后给出")
+	}
+	record, err := findUser(db, rest[0])
+	if err != nil {
+		return err
+	}
+	password, err := readPassword(*passwordArg, rest[len(rest)-1])
+	if err != nil {
+		return err
+	}
+	if err := validateUser(record.Name, password); err != nil {
+		return err
+	}
+
+	if err := databasetool.ChangePassword(db, int(record.ID), password); err != nil {
+		return fmt.Errorf("重置密码失败: %v", err)
+	}
+	audit(db, databasetool.AuditChangePassword, strconv.FormatUint(uint64(record.ID), 10), "命令行重置密码")
+	fmt.Printf("用户 %s 的密码已重置\n", record.Name)
+	return nil
+}
+
+// validateUser 校验用户名和密码长度，与User表的字段长度一致
+func validateUser(name, password string) error {
+	if name == "" || utf8.RuneCountInString(name) > databasetool.MaxUserNameLength {
+		return usagef("用户名不能为空且不能超过%d个字符", databasetool.MaxUserNameLength)
+	}
+	if password == "" || len(password) > databasetool.MaxUserPasswordLength {
+		return usagef("密码不能为空且不能超过%d个字符", databasetool.MaxUserPasswordLength)
+	}
+	return nil
+}
+
+// formatTime 输出本地时间，零值输出 -
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// writeJSON 以缩进的JSON输出到标准输出
+func writeJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,413 @@
+// loadgen 聊天服务器压力测试工具
+//
+// 启动N个模拟客户端，注册并登录后按设定的速率互相发送聊天消息和文件，
+// 结束时输出各类操作的延迟百分位、吞吐量和错误数。
+//
+//	go run ./cmd/loadgen -addr 127.0.0.1:12345 -clients 200 -duration 1m -msg-rate 2 -file-rate 0.1
+//
+// 用户名为 <prefix>-<序号>，已存在时直接登录，因此同一数据库可以重复压测。
+package main
+
+import (
+	"connection_server_linux/chattest"
+	"connection_server_linux/filetransfer"
+	"connection_server_linux/frame"
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	mrand "math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// messagePrefix 压测消息内容的前缀，后面是发送时间(UnixNano)
+const messagePrefix = "loadgen:"
+
+// options 命令行参数
+type options struct {
+	addr        string
+	clients     int
+	prefix      string
+	password    string
+	duration    time.Duration
+	msgRate     float64
+	fileRate    float64
+	fileSize    int
+	chunkSize   int
+	concurrency int
+	drain       time.Duration
+	jsonOutput  bool
+}
+
+func parseOptions(args []string) (*options, error) {
+	opts := &options{}
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	fs.StringVar(&opts.addr, "addr", "127.0.0.1:12345", "聊天服务器的TCP地址")
+	fs.IntVar(&opts.clients, "clients", 50, "模拟客户端数量，至少2个")
+	fs.StringVar(&opts.prefix, "prefix", "loadgen", "模拟用户的用户名前缀")
+	fs.StringVar(&opts.password, "password", "loadgen-pwd", "模拟用户的密码")
+	fs.DurationVar(&opts.duration, "duration", 30*time.Second, "收发阶段的时长")
+	fs.Float64Var(&opts.msgRate, "msg-rate", 1, "每个客户端每秒发送的聊天消息数，0为不发送")
+	fs.Float64Var(&opts.fileRate, "file-rate", 0, "每个客户端每秒发送的文件数，0为不发送")
+	fs.IntVar(&opts.fileSize, "file-size", 64*1024, "每个文件的字节数")
+	fs.IntVar(&opts.chunkSize, "chunk-size", chattest.DefaultChunkSize, "上传文件时每个数据包的字节数")
+	fs.IntVar(&opts.concurrency, "concurrency", 50, "注册和登录阶段同时进行的连接数")
+	fs.DurationVar(&opts.drain, "drain", 5*time.Second, "停止发送后等待在途消息和文件送达的时间")
+	fs.BoolVar(&opts.jsonOutput, "json", false, "以JSON格式输出报告")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	if opts.clients < 2 {
+		errs = append(errs, errors.New("-clients 至少为2"))
+	}
+	if opts.msgRate < 0 || opts.fileRate < 0 {
+		errs = append(errs, errors.New("-msg-rate 和 -file-rate 不能为负数"))
+	}
+	if opts.fileRate > 0 && opts.fileSize <= 0 {
+		errs = append(errs, errors.New("-file-size 必须大于0"))
+	}
+	if opts.concurrency < 1 {
+		errs = append(errs, errors.New("-concurrency 至少为1"))
+	}
+	if opts.duration <= 0 {
+		errs = append(errs, errors.New("-duration 必须大于0"))
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("参数无效: %v", errors.Join(errs...))
+	}
+	return opts, nil
+}
+
+func main() {
+	opts, err := parseOptions(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// 收到 Ctrl+C 时提前结束收发阶段，仍然输出报告
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	stats := newRecorder()
+	clients := connectAll(opts, stats)
+	if len(clients) < 2 {
+		slog.Error("成功登录的客户端不足2个，无法压测", "clients", len(clients))
+		os.Exit(1)
+	}
+	slog.Info("客户端已登录，开始收发", "clients", len(clients), "duration", opts.duration)
+
+	elapsed := run(ctx, opts, clients, stats)
+
+	rep := stats.report(len(clients), elapsed)
+	if opts.jsonOutput {
+		if err := rep.writeJSON(os.Stdout); err != nil {
+			slog.Error("输出报告失败", "err", err)
+			os.Exit(1)
+		}
+	} else {
+		rep.writeText(os.Stdout)
+	}
+	if len(rep.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+// connectAll 注册并登录所有模拟用户，最多同时进行 concurrency 个，返回登录成功的客户端
+func connectAll(opts *options, stats *recorder) []*simClient {
+	var (
+		mu      sync.Mutex
+		clients []*simClient
+		wg      sync.WaitGroup
+		slots   = make(chan struct{}, opts.concurrency)
+	)
+	for i := 0; i < opts.clients; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			c, err := connect(opts, stats, name)
+			if err != nil {
+				slog.Warn("模拟用户登录失败", "name", name, "err", err)
+				return
+			}
+			mu.Lock()
+			clients = append(clients, c)
+			mu.Unlock()
+		}(fmt.Sprintf("%s-%d", opts.prefix, i))
+	}
+	wg.Wait()
+	return clients
+}
+
+// connect 注册(用户名已存在时跳过)并登录一个模拟用户
+func connect(opts *options, stats *recorder, name string) (*simClient, error) {
+	start := time.Now()
+	if _, err := chattest.Register(opts.addr, name, opts.password); err == nil {
+		stats.attempt(opRegister)
+		stats.observe(opRegister, time.Since(start))
+	} else if !strings.Contains(err.Error(), "用户名已存在") {
+		stats.attempt(opRegister)
+		stats.fail("register")
+		return nil, err
+	}
+
+	stats.attempt(opLogin)
+	start = time.Now()
+	c, err := chattest.Login(opts.addr, name, opts.password)
+	if err != nil {
+		stats.fail("login")
+		return nil, err
+	}
+	stats.observe(opLogin, time.Since(start))
+	return &simClient{Client: c, stats: stats, uploads: make(map[string]time.Time), downloads: make(map[string]*download)}, nil
+}
+
+// run 启动所有客户端的收发，duration 结束(或 ctx 被取消)后停止发送，
+// 再等待 drain 让在途的消息和文件送达，返回实际的发送时长
+func run(ctx context.Context, opts *options, clients []*simClient, stats *recorder) time.Duration {
+	ids := make([]string, len(clients))
+	for i, c := range clients {
+		ids[i] = c.ID
+	}
+	payload := make([]byte, opts.fileSize)
+	rand.Read(payload)
+
+	// 重复压测时，上次未送达的消息会在登录后补发，只统计本次发出的
+	since := time.Now()
+
+	var readers sync.WaitGroup
+	for _, c := range clients {
+		c.Timeout = 200 * time.Millisecond
+		c.since = since
+		readers.Add(1)
+		go func(c *simClient) {
+			defer readers.Done()
+			c.receive()
+		}(c)
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+	start := time.Now()
+	var senders sync.WaitGroup
+	for _, c := range clients {
+		if opts.msgRate > 0 {
+			senders.Add(1)
+			go func(c *simClient) {
+				defer senders.Done()
+				every(sendCtx, opts.msgRate, func() { c.sendMessage(pickPeer(ids, c.ID)) })
+			}(c)
+		}
+		if opts.fileRate > 0 {
+			senders.Add(1)
+			go func(c *simClient) {
+				defer senders.Done()
+				every(sendCtx, opts.fileRate, func() { c.sendFile(pickPeer(ids, c.ID), payload, opts.chunkSize) })
+			}(c)
+		}
+	}
+	senders.Wait()
+	elapsed := time.Since(start)
+
+	// 等待在途的消息和文件，全部完成后提前结束
+	deadline := time.Now().Add(opts.drain)
+	for time.Now().Before(deadline) && ctx.Err() == nil && !allDone(clients, stats) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	for _, c := range clients {
+		c.Close()
+	}
+	readers.Wait()
+	return elapsed
+}
+
+// allDone 是否所有发出的消息和文件都已完成
+func allDone(clients []*simClient, stats *recorder) bool {
+	stats.mu.Lock()
+	done := len(stats.latencies[opMessage]) >= stats.sent[opMessage] &&
+		len(stats.latencies[opFile]) >= stats.sent[opFile]
+	stats.mu.Unlock()
+	if !done {
+		return false
+	}
+	for _, c := range clients {
+		c.mu.Lock()
+		pending := len(c.uploads) + len(c.downloads)
+		c.mu.Unlock()
+		if pending > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// every 以每秒 rate 次的平均速率调用 fn，直到 ctx 结束
+// 首次调用前随机等待一段时间，避免所有客户端同时发送
+func every(ctx context.Context, rate float64, fn func()) {
+	interval := time.Duration(float64(time.Second) / rate)
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Duration(mrand.Int63n(int64(interval) + 1))):
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fn()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pickPeer 随机选择一个不是自己的接收者
+func pickPeer(ids []string, self string) string {
+	for {
+		if id := ids[mrand.Intn(len(ids))]; id != self {
+			return id
+		}
+	}
+}
+
+// download 接收方正在收取的文件
+type download struct {
+	size     int
+	received int
+}
+
+// simClient 一个模拟客户端
+type simClient struct {
+	*chattest.Client
+	stats *recorder
+	since time.Time // 早于该时间发出的消息不计入统计
+
+	mu        sync.Mutex
+	seq       int
+	uploads   map[string]time.Time // 发送方的传输ID -> 开始上传的时间
+	downloads map[string]*download // 接收方的传输ID -> 收取进度
+}
+
+// sendMessage 发送一条带发送时间的聊天消息，接收方据此计算延迟
+func (c *simClient) sendMessage(to string) {
+	c.stats.attempt(opMessage)
+	content := messagePrefix + strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := c.SendMessage(to, content); err != nil {
+		c.stats.fail("send_message")
+	}
+}
+
+// sendFile 上传一个文件，上传完成和对方收齐的时间由 receive 记录
+func (c *simClient) sendFile(to string, data []byte, chunkSize int) {
+	c.mu.Lock()
+	c.seq++
+	transferID := fmt.Sprintf("lg-%s-%d", c.ID, c.seq)
+	c.uploads[transferID] = time.Now()
+	c.mu.Unlock()
+
+	c.stats.attempt(opUpload)
+	c.stats.attempt(opFile)
+	if err := c.SendFile(to, transferID, transferID+".bin", data, chunkSize); err != nil {
+		c.stats.fail("send_file")
+		c.mu.Lock()
+		delete(c.uploads, transferID)
+		c.mu.Unlock()
+	}
+}
+
+// receive 读取并处理服务器发来的数据包，直到连接关闭
+func (c *simClient) receive() {
+	for {
+		p, err := c.Next()
+		if errors.Is(err, chattest.ErrTimeout) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		c.handle(p)
+	}
+}
+
+func (c *simClient) handle(p chattest.Packet) {
+	now := time.Now()
+	if p.Type == frame.TypeFileChunk {
+		id, data, err := filetransfer.DecodeChunk(p.Payload)
+		if err != nil {
+			c.stats.fail("bad_chunk")
+			return
+		}
+		c.mu.Lock()
+		d, ok := c.downloads[id]
+		if ok {
+			d.received += len(data)
+			if d.received >= d.size {
+				delete(c.downloads, id)
+			}
+		}
+		c.mu.Unlock()
+		if !ok {
+			c.stats.fail("unexpected_chunk")
+			return
+		}
+		c.stats.addBytes(len(data))
+		return
+	}
+
+	switch p.MsgType() {
+	case "message":
+		sent, err := strconv.ParseInt(strings.TrimPrefix(p.String("content"), messagePrefix), 10, 64)
+		if err != nil || time.Unix(0, sent).Before(c.since) {
+			return // 不是本次压测发出的消息
+		}
+		c.stats.observe(opMessage, now.Sub(time.Unix(0, sent)))
+	case "file_offer":
+		if err := c.FileMessage("file_accept", p.String("transferid")); err != nil {
+			c.stats.fail("file_accept")
+		}
+	case "file_notify":
+		size, _ := strconv.Atoi(p.String("size"))
+		c.mu.Lock()
+		c.downloads[p.String("transferid")] = &download{size: size}
+		c.mu.Unlock()
+	case "file_uploaded":
+		c.mu.Lock()
+		start, ok := c.uploads[p.String("transferid")]
+		c.mu.Unlock()
+		if ok {
+			c.stats.observe(opUpload, now.Sub(start))
+		}
+	case "file_accepted":
+		c.mu.Lock()
+		start, ok := c.uploads[p.String("transferid")]
+		delete(c.uploads, p.String("transferid"))
+		c.mu.Unlock()
+		if ok {
+			c.stats.observe(opFile, now.Sub(start))
+		}
+	case "file_error", "file_declined", "file_cancelled":
+		c.mu.Lock()
+		delete(c.uploads, p.String("transferid"))
+		c.mu.Unlock()
+		c.stats.fail(p.MsgType())
+	case "server_shutdown":
+		c.stats.fail("server_shutdown")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+//延迟、吞吐量和错误统计
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 统计的操作类型
+const (
+	opRegister = "register" // 注册
+	opLogin    = "login"    // 登录：发出请求到收到好友列表
+	opMessage  = "message"  // 聊天消息：发送到对方收到
+	opUpload   = "upload"   // 文件上传：开始发送到收到 file_uploaded
+	opFile     = "file"     // 文件端到端：开始发送到对方收齐、发送方收到 file_accepted
+)
+
+var operations = []string{opRegister, opLogin, opMessage, opUpload, opFile}
+
+// recorder 并发安全的统计收集器
+type recorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	sent      map[string]int
+	errors    map[string]int
+	bytes     int64 // 接收方收齐的文件字节数
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		latencies: make(map[string][]time.Duration),
+		sent:      make(map[string]int),
+		errors:    make(map[string]int),
+	}
+}
+
+// observe 记录一次完成的操作及其耗时
+func (r *recorder) observe(op string, d time.Duration) {
+	r.mu.Lock()
+	r.latencies[op] = append(r.latencies[op], d)
+	r.mu.Unlock()
+}
+
+// attempt 记录一次发起的操作，用于计算未完成的数量
+func (r *recorder) attempt(op string) {
+	r.mu.Lock()
+	r.sent[op]++
+	r.mu.Unlock()
+}
+
+// fail 记录一次错误，kind 为错误类型
+func (r *recorder) fail(kind string) {
+	r.mu.Lock()
+	r.errors[kind]++
+	r.mu.Unlock()
+}
+
+// addBytes 累计接收方收到的文件字节数
+func (r *recorder) addBytes(n int) {
+	r.mu.Lock()
+	r.bytes += int64(n)
+	r.mu.Unlock()
+}
+
+// OpStats 一种操作的统计结果，延迟单位为毫秒
+type OpStats struct {
+	Sent       int     `json:"sent"`       // 发起次数
+	Completed  int     `json:"completed"`  // 完成次数
+	Throughput float64 `json:"throughput"` // 每秒完成次数
+	P50        float64 `json:"p50_ms"`
+	P90        float64 `json:"p90_ms"`
+	P99        float64 `json:"p99_ms"`
+	Max        float64 `json:"max_ms"`
+}
+
+// Report 压测报告
+type Report struct {
+	Clients     int                `json:"clients"`      // 成功登录的客户端数
+	Duration    float64            `json:"duration_s"`   // 收发阶段的时长(秒)
+	Operations  map[string]OpStats `json:"operations"`   // 按操作类型分组
+	FileBytes   int64              `json:"file_bytes"`   // 接收方收齐的文件字节数
+	FileBytesPS float64            `json:"file_bytes_s"` // 每秒收到的文件字节数
+	Errors      map[string]int     `json:"errors"`       // 按错误类型分组
+}
+
+// percentile 返回已排序延迟中的第p百分位(0-1)
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func millis(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
+
+// report 汇总统计结果，elapsed 为收发阶段的时长，用于计算吞吐量
+func (r *recorder) report(clients int, elapsed time.Duration) Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rep := Report{
+		Clients:    clients,
+		Duration:   elapsed.Seconds(),
+		Operations: make(map[string]OpStats),
+		FileBytes:  r.bytes,
+		Errors:     make(map[string]int, len(r.errors)),
+	}
+	for _, op := range operations {
+		values := append([]time.Duration(nil), r.latencies[op]...)
+		if len(values) == 0 && r.sent[op] == 0 {
+			continue
+		}
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+		stats := OpStats{
+			Sent:      r.sent[op],
+			Completed: len(values),
+			P50:       millis(percentile(values, 0.50)),
+			P90:       millis(percentile(values, 0.90)),
+			P99:       millis(percentile(values, 0.99)),
+			Max:       millis(percentile(values, 1)),
+		}
+		// 注册和登录只在准备阶段进行，不计算吞吐量
+		if op != opRegister && op != opLogin && elapsed > 0 {
+			stats.Throughput = float64(len(values)) / elapsed.Seconds()
+		}
+		rep.Operations[op] = stats
+	}
+	if elapsed > 0 {
+		rep.FileBytesPS = float64(r.bytes) / elapsed.Seconds()
+	}
+	for kind, n := range r.errors {
+		rep.Errors[kind] = n
+	}
+	return rep
+}
+
+// writeText 以表格形式输出报告
+func (rep Report) writeText(w io.Writer) {
+	fmt.Fprintf(w, "客户端: %d  收发时长: %.1fs\n\n", rep.Clients, rep.Duration)
+	// 表头用ASCII，避免中文宽度导致列不对齐；依次为发起次数、完成次数、每秒完成次数和延迟
+	fmt.Fprintf(w, "%-9s %8s %8s %9s %9s %9s %9s %9s\n", "op", "sent", "done", "ops/s", "p50(ms)", "p90(ms)", "p99(ms)", "max(ms)")
+	for _, op := range operations {
+		s, ok := rep.Operations[op]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "%-9s %8d %8d %9.1f %9.2f %9.2f %9.2f %9.2f\n", op, s.Sent, s.Completed, s.Throughput, s.P50, s.P90, s.P99, s.Max)
+	}
+	if rep.FileBytes > 0 {
+		fmt.Fprintf(w, "\n文件: 共收到 %d 字节，%.2f MB/s\n", rep.FileBytes, rep.FileBytesPS/1024/1024)
+	}
+
+	kinds := make([]string, 0, len(rep.Errors))
+	for kind := range rep.Errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	fmt.Fprintln(w)
+	if len(kinds) == 0 {
+		fmt.Fprintln(w, "错误: 无")
+		return
+	}
+	fmt.Fprintln(w, "错误:")
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "  %-20s %d\n", kind, rep.Errors[kind])
+	}
+}
+
+// writeJSON 以JSON输出报告，便于在CI中比较
+func (rep Report) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
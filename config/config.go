@@ -0,0 +1,293 @@
+// Package config 集中管理服务器的运行配置
+// 配置按 默认值 < JSON配置文件 < 环境变量 < 命令行参数 的顺序逐层覆盖，启动时统一校验，
+// 再由 main 注入到数据库、会话、文件传输和网络等各个模块，不同环境只需准备不同的配置文件。
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"connection_server_linux/logging"
+)
+
+// EnvConfigFile 指定配置文件路径的环境变量，命令行参数 -config 优先
+const EnvConfigFile = "CHAT_CONFIG"
+
+// Duration 可以在JSON中写成 "24h"、"30m" 形式的时长
+type Duration time.Duration
+
+// UnmarshalJSON 解析时长字符串
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("时长必须是字符串，例如 \"24h\": %v", err)
+	}
+	return d.Set(value)
+}
+
+// MarshalJSON 输出时长字符串
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Set 实现 flag.Value
+func (d *Duration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// String 实现 flag.Value
+func (d *Duration) String() string {
+	return time.Duration(*d).String()
+}
+
+// Config 服务器配置
+type Config struct {
+	TCPAddr   string `json:"tcp_addr"`   // 聊天客户端TCP监听地址
+	HTTPSAddr string `json:"https_addr"` // 管理后台和文件下载的HTTPS监听地址
+	TLSCert   string `json:"tls_cert"`   // HTTPS证书文件
+	TLSKey    string `json:"tls_key"`    // HTTPS私钥文件
+
+	Database          string   `json:"database"`             // SQLite数据库文件
+	DBMaxIdleConns    int      `json:"db_max_idle_conns"`    // 连接池最大空闲连接数
+	DBMaxOpenConns    int      `json:"db_max_open_conns"`    // 连接池最大连接数
+	DBConnMaxLifetime Duration `json:"db_conn_max_lifetime"` // 单个连接的最长使用时间
+
+	FileStorage string   `json:"file_storage"` // 文件传输的存储目录
+	FileTTL     Duration `json:"file_ttl"`     // 待接收文件的保存期限，超过后删除并通知双方
+
+	SessionIdleTimeout Duration `json:"session_idle_timeout"` // 管理后台会话空闲超时
+	SessionMaxLifetime Duration `json:"session_max_lifetime"` // 管理后台会话绝对有效期
+
+	ShutdownTimeout     Duration `json:"shutdown_timeout"`      // 优雅关闭时等待上传完成和连接结束的最长时间
+	HealthMaxGoroutines int      `json:"health_max_goroutines"` // 协程数超过该值时健康检查失败，为0时不检查
+
+	LogLevel     string `json:"log_level"`     // debug/info/warn/error
+	LogFormat    string `json:"log_format"`    // text/json
+	MetricsToken string `json:"metrics_token"` // 抓取 /metrics 的令牌，为空时只允许本机抓取
+}
+
+// Default 返回默认配置，与拆分配置前写死在代码中的值一致
+func Default() *Config {
+	return &Config{
+		TCPAddr:   "0.0.0.0:12345",
+		HTTPSAddr: ":8443",
+		TLSCert:   "certs/server.crt",
+		TLSKey:    "certs/server.key",
+
+		Database:          "communication.db",
+		DBMaxIdleConns:    10,
+		DBMaxOpenConns:    100,
+		DBConnMaxLifetime: Duration(time.Hour),
+
+		FileStorage: "file_storage",
+		FileTTL:     Duration(7 * 24 * time.Hour),
+
+		SessionIdleTimeout: Duration(24 * time.Hour),
+		SessionMaxLifetime: Duration(7 * 24 * time.Hour),
+
+		ShutdownTimeout:     Duration(30 * time.Second),
+		HealthMaxGoroutines: 10000,
+
+		LogLevel:  "info",
+		LogFormat: logging.FormatText,
+	}
+}
+
+// option 一个配置项对应的命令行参数和环境变量
+type option struct {
+	flag  string
+	env   string
+	usage string
+	bind  func(fs *flag.FlagSet, name, usage string)
+}
+
+// options 返回绑定到 cfg 各字段的配置项
+func (cfg *Config) options() []option {
+	str := func(p *string) func(*flag.FlagSet, string, string) {
+		return func(fs *flag.FlagSet, name, usage string) { fs.StringVar(p, name, *p, usage) }
+	}
+	num := func(p *int) func(*flag.FlagSet, string, string) {
+		return func(fs *flag.FlagSet, name, usage string) { fs.IntVar(p, name, *p, usage) }
+	}
+	dur := func(p *Duration) func(*flag.FlagSet, string, string) {
+		return func(fs *flag.FlagSet, name, usage string) { fs.Var(p, name, usage) }
+	}
+	return []option{
+		{"tcp-addr", "CHAT_TCP_ADDR", "聊天客户端TCP监听地址", str(&cfg.TCPAddr)},
+		{"https-addr", "CHAT_HTTPS_ADDR", "HTTPS监听地址", str(&cfg.HTTPSAddr)},
+		{"tls-cert", "CHAT_TLS_CERT", "HTTPS证书文件", str(&cfg.TLSCert)},
+		{"tls-key", "CHAT_TLS_KEY", "HTTPS私钥文件", str(&cfg.TLSKey)},
+		{"db", "CHAT_DB", "SQLite数据库文件", str(&cfg.Database)},
+		{"db-max-idle-conns", "CHAT_DB_MAX_IDLE_CONNS", "数据库连接池最大空闲连接数", num(&cfg.DBMaxIdleConns)},
+		{"db-max-open-conns", "CHAT_DB_MAX_OPEN_CONNS", "数据库连接池最大连接数", num(&cfg.DBMaxOpenConns)},
+		{"db-conn-max-lifetime", "CHAT_DB_CONN_MAX_LIFETIME", "数据库连接的最长使用时间", dur(&cfg.DBConnMaxLifetime)},
+		{"file-storage", "CHAT_FILE_STORAGE", "文件传输的存储目录", str(&cfg.FileStorage)},
+		{"file-ttl", "CHAT_FILE_TTL", "待接收文件的保存期限", dur(&cfg.FileTTL)},
+		{"session-idle-timeout", "CHAT_SESSION_IDLE_TIMEOUT", "管理后台会话空闲超时", dur(&cfg.SessionIdleTimeout)},
+		{"session-max-lifetime", "CHAT_SESSION_MAX_LIFETIME", "管理后台会话绝对有效期", dur(&cfg.SessionMaxLifetime)},
+		{"shutdown-timeout", "CHAT_SHUTDOWN_TIMEOUT", "优雅关闭的最长等待时间", dur(&cfg.ShutdownTimeout)},
+		{"health-max-goroutines", "CHAT_HEALTH_MAX_GOROUTINES", "健康检查允许的最大协程数，0为不检查", num(&cfg.HealthMaxGoroutines)},
+		{"log-level", "LOG_LEVEL", "日志级别 debug/info/warn/error", str(&cfg.LogLevel)},
+		{"log-format", "LOG_FORMAT", "日志格式 text/json", str(&cfg.LogFormat)},
+		{"metrics-token", "METRICS_TOKEN", "抓取 /metrics 的令牌，为空时只允许本机抓取", str(&cfg.MetricsToken)},
+	}
+}
+
+// flagSet 创建绑定到 cfg 的命令行参数集合
+func (cfg *Config) flagSet(name string, configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(configPath, "config", *configPath, "JSON配置文件路径 (环境变量 "+EnvConfigFile+")")
+	for _, opt := range cfg.options() {
+		opt.bind(fs, opt.flag, fmt.Sprintf("%s (环境变量 %s)", opt.usage, opt.env))
+	}
+	return fs
+}
+
+// Load 依次读取默认值、配置文件、环境变量和命令行参数，并校验结果
+// name 为程序名，用于 -h 的帮助信息；args 为不含程序名的命令行参数
+func Load(name string, args []string) (*Config, error) {
+	// 先解析一遍命令行，只为取得配置文件路径
+	configPath := os.Getenv(EnvConfigFile)
+	if err := Default().flagSet(name, &configPath).Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	if configPath != "" {
+		if err := cfg.loadFile(configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	fs := cfg.flagSet(name, &configPath)
+	fs.SetOutput(new(bytes.Buffer))
+	for _, opt := range cfg.options() {
+		if value, ok := os.LookupEnv(opt.env); ok && value != "" {
+			if err := fs.Set(opt.flag, value); err != nil {
+				return nil, fmt.Errorf("环境变量 %s 无效: %v", opt.env, err)
+			}
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile 读取JSON配置文件，文件中未出现的字段保持原值，出现未知字段时报错以发现拼写错误
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+	}
+	return nil
+}
+
+// Validate 校验配置，返回所有发现的问题
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	if err := validateAddr(cfg.TCPAddr); err != nil {
+		errs = append(errs, fmt.Errorf("tcp_addr: %v", err))
+	}
+	if err := validateAddr(cfg.HTTPSAddr); err != nil {
+		errs = append(errs, fmt.Errorf("https_addr: %v", err))
+	} else {
+		check(cfg.HTTPSPort() != 0, "https_addr: 端口不能为0，文件下载链接需要固定端口")
+	}
+	for _, file := range []struct{ key, path string }{{"tls_cert", cfg.TLSCert}, {"tls_key", cfg.TLSKey}} {
+		if file.path == "" {
+			errs = append(errs, fmt.Errorf("%s: 不能为空", file.key))
+		} else if _, err := os.Stat(file.path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", file.key, err))
+		}
+	}
+
+	check(cfg.Database != "", "database: 不能为空")
+	check(cfg.DBMaxOpenConns > 0, "db_max_open_conns: 必须大于0")
+	// 空闲连接数超过最大连接数时 database/sql 会自动降为最大连接数
+	check(cfg.DBMaxIdleConns >= 0, "db_max_idle_conns: 不能为负数")
+	check(cfg.DBConnMaxLifetime >= 0, "db_conn_max_lifetime: 不能为负数")
+
+	if cfg.FileStorage == "" {
+		errs = append(errs, errors.New("file_storage: 不能为空"))
+	} else if info, err := os.Stat(cfg.FileStorage); err == nil && !info.IsDir() {
+		errs = append(errs, fmt.Errorf("file_storage: %s 不是目录", cfg.FileStorage))
+	} else if err != nil && !os.IsNotExist(err) {
+		errs = append(errs, fmt.Errorf("file_storage: %v", err))
+	} else if err != nil {
+		// 目录不存在时启动时会自动创建，只要求上级目录存在
+		parent := filepath.Dir(filepath.Clean(cfg.FileStorage))
+		if _, err := os.Stat(parent); err != nil {
+			errs = append(errs, fmt.Errorf("file_storage: 上级目录不可用: %v", err))
+		}
+	}
+
+	check(cfg.FileTTL > 0, "file_ttl: 必须大于0")
+
+	check(cfg.SessionIdleTimeout > 0, "session_idle_timeout: 必须大于0")
+	check(cfg.SessionMaxLifetime > 0, "session_max_lifetime: 必须大于0")
+	check(cfg.SessionIdleTimeout <= cfg.SessionMaxLifetime, "session_idle_timeout: 不能超过 session_max_lifetime")
+	check(cfg.ShutdownTimeout > 0, "shutdown_timeout: 必须大于0")
+	check(cfg.HealthMaxGoroutines >= 0, "health_max_goroutines: 不能为负数")
+
+	if _, err := logging.ParseLevel(cfg.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %v", err))
+	}
+	check(cfg.LogFormat == logging.FormatText || cfg.LogFormat == logging.FormatJSON, "log_format: 只能是 text 或 json")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("配置无效: %v", errors.Join(errs...))
+	}
+	return nil
+}
+
+// validateAddr 校验 host:port 形式的监听地址
+func validateAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("无效的端口 %q", port)
+	}
+	return nil
+}
+
+// HTTPSPort HTTPS监听端口，用于生成文件下载链接
+func (cfg *Config) HTTPSPort() int {
+	_, port, err := net.SplitHostPort(cfg.HTTPSAddr)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(port)
+	return n
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "server.crt")
+	key := filepath.Join(dir, "server.key")
+	for _, p := range []string{cert, key} {
+		if err := os.WriteFile(p, []byte("test"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	fileA := writeConfig("a.json", `{"log_level": "warn", "shutdown_timeout": "10s", "db_max_open_conns": 20}`)
+	fileB := writeConfig("b.json", `{"log_level": "error"}`)
+	unknown := writeConfig("unknown.json", `{"log_levle": "warn"}`)
+	badDuration := writeConfig("duration.json", `{"shutdown_timeout": 10}`)
+	invalid := writeConfig("invalid.json", `{"db_max_open_conns": 0, "log_format": "xml", "session_idle_timeout": "48h", "session_max_lifetime": "24h"}`)
+
+	cases := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		wantErr string                 // 为空时期望成功
+		check   func(cfg *Config) bool // 成功时检查结果
+	}{
+		{"默认值", nil, nil, "", func(cfg *Config) bool {
+			return cfg.LogLevel == "info" && time.Duration(cfg.ShutdownTimeout) == 30*time.Second && cfg.DBMaxOpenConns == 100
+		}},
+		{"配置文件覆盖默认值", nil, []string{"-config", fileA}, "", func(cfg *Config) bool {
+			return cfg.LogLevel == "warn" && time.Duration(cfg.ShutdownTimeout) == 10*time.Second && cfg.DBMaxOpenConns == 20 && cfg.LogFormat == "text"
+		}},
+		{"环境变量指定配置文件", map[string]string{EnvConfigFile: fileA}, nil, "", func(cfg *Config) bool {
+			return cfg.LogLevel == "warn"
+		}},
+		{"命令行的配置文件优先于环境变量", map[string]string{EnvConfigFile: fileA}, []string{"-config", fileB}, "", func(cfg *Config) bool {
+			return cfg.LogLevel == "error" && time.Duration(cfg.ShutdownTimeout) == 30*time.Second
+		}},
+		{"环境变量覆盖配置文件", map[string]string{"LOG_LEVEL": "error", "CHAT_SHUTDOWN_TIMEOUT": "1m"}, []string{"-config", fileA}, "", func(cfg *Config) bool {
+			return cfg.LogLevel == "error" && time.Duration(cfg.ShutdownTimeout) == time.Minute && cfg.DBMaxOpenConns == 20
+		}},
+		{"空的环境变量不覆盖", map[string]string{"LOG_LEVEL": ""}, []string{"-config", fileA}, "", func(cfg *Config) bool {
+			return cfg.LogLevel == "warn"
+		}},
+		{"命令行覆盖环境变量", map[string]string{"LOG_LEVEL": "error", "CHAT_DB_MAX_OPEN_CONNS": "50"}, []string{"-config", fileA, "-log-level", "debug"}, "", func(cfg *Config) bool {
+			return cfg.LogLevel == "debug" && cfg.DBMaxOpenConns == 50 && time.Duration(cfg.ShutdownTimeout) == 10*time.Second
+		}},
+		{"命令行覆盖默认值", nil, []string{"-file-ttl", "1h", "-metrics-token", "secret"}, "", func(cfg *Config) bool {
+			return time.Duration(cfg.FileTTL) == time.Hour && cfg.MetricsToken == "secret"
+		}},
+
+		{"配置文件不存在", nil, []string{"-config", filepath.Join(dir, "missing.json")}, "读取配置文件失败", nil},
+		{"配置文件有未知字段", nil, []string{"-config", unknown}, "log_levle", nil},
+		{"配置文件时长不是字符串", nil, []string{"-config", badDuration}, "时长必须是字符串", nil},
+		{"环境变量无效", map[string]string{"CHAT_DB_MAX_OPEN_CONNS": "many"}, nil, "环境变量 CHAT_DB_MAX_OPEN_CONNS 无效", nil},
+		{"命令行参数无效", nil, []string{"-shutdown-timeout", "soon"}, "shutdown-timeout", nil},
+		{"未知的命令行参数", nil, []string{"-verbose"}, "verbose", nil},
+
+		{"连接数为0", nil, []string{"-db-max-open-conns", "0"}, "db_max_open_conns: 必须大于0", nil},
+		{"日志级别无效", map[string]string{"LOG_LEVEL": "loud"}, nil, "log_level", nil},
+		{"HTTPS端口为0", nil, []string{"-https-addr", ":0"}, "https_addr: 端口不能为0", nil},
+		{"监听地址无效", nil, []string{"-tcp-addr", "localhost"}, "tcp_addr", nil},
+		{"端口超出范围", nil, []string{"-tcp-addr", ":70000"}, "无效的端口", nil},
+		{"证书不存在", nil, []string{"-tls-cert", filepath.Join(dir, "missing.crt")}, "tls_cert", nil},
+		{"文件保存期限为0", map[string]string{"CHAT_FILE_TTL": "0s"}, nil, "file_ttl: 必须大于0", nil},
+		{"存储路径不是目录", nil, []string{"-file-storage", cert}, "不是目录", nil},
+		{"一次报告所有问题", nil, []string{"-config", invalid}, "db_max_open_conns", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// 清除外部环境中的配置，只使用本用例的环境变量
+			t.Setenv(EnvConfigFile, "")
+			for _, opt := range Default().options() {
+				t.Setenv(opt.env, "")
+			}
+			for k, v := range c.env {
+				t.Setenv(k, v)
+			}
+
+			args := append([]string{"-tls-cert", cert, "-tls-key", key}, c.args...)
+			cfg, err := Load("chat", args)
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("Load 错误 = %v, 期望包含 %q", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load 失败: %v", err)
+			}
+			if !c.check(cfg) {
+				t.Fatalf("配置不符合预期: %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestValidateReportsAllErrors(t *testing.T) {
+	cfg := Default()
+	cfg.TLSCert = ""
+	cfg.TLSKey = ""
+	cfg.DBMaxOpenConns = 0
+	cfg.LogFormat = "xml"
+	cfg.SessionIdleTimeout = Duration(48 * time.Hour)
+	cfg.SessionMaxLifetime = Duration(24 * time.Hour)
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("无效配置通过了校验")
+	}
+	for _, want := range []string{
+		"tls_cert: 不能为空",
+		"tls_key: 不能为空",
+		"db_max_open_conns: 必须大于0",
+		"log_format: 只能是 text 或 json",
+		"session_idle_timeout: 不能超过 session_max_lifetime",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("错误信息缺少 %q: %v", want, err)
+		}
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	var d Duration
+	if err := d.UnmarshalJSON([]byte(`"90m"`)); err != nil || time.Duration(d) != 90*time.Minute {
+		t.Fatalf("UnmarshalJSON = %v, %v", time.Duration(d), err)
+	}
+	if err := d.UnmarshalJSON([]byte(`"forever"`)); err == nil {
+		t.Fatal("无效时长应报错")
+	}
+	data, err := Duration(90 * time.Minute).MarshalJSON()
+	if err != nil || string(data) != `"1h30m0s"` {
+		t.Fatalf("MarshalJSON = %s, %v", data, err)
+	}
+}
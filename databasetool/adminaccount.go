@@ -0,0 +1,87 @@
+package databasetool
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 添加管理后台账号，passwordHash 由调用方计算
+func CreateAdminAccount(db *gorm.DB, name, passwordHash, role, createdBy string) (*AdminAccount, error) {
+	account := &AdminAccount{
+		Name:         name,
+		PasswordHash: passwordHash,
+		Role:         role,
+		CreatedAt:    time.Now(),
+		CreatedBy:    createdBy,
+	}
+	result := db.Create(account)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return account, nil
+}
+
+// 通过用户名查找管理后台账号
+func FindAdminAccount(db *gorm.DB, name string) (*AdminAccount, error) {
+	var account AdminAccount
+	result := db.First(&account, "name = ?", name)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &account, nil
+}
+
+// 查询所有管理后台账号，按创建时间排序
+func ListAdminAccounts(db *gorm.DB) ([]AdminAccount, error) {
+	var accounts []AdminAccount
+	result := db.Order("createdAt").Find(&accounts)
+	return accounts, result.Error
+}
+
+// 统计管理后台账号数量，可按角色过滤，role 为空时统计全部
+func CountAdminAccounts(db *gorm.DB, role string) (int64, error) {
+	var count int64
+	query := db.Model(&AdminAccount{})
+	if role != "" {
+		query = query.Where("role = ?", role)
+	}
+	result := query.Count(&count)
+	return count, result.Error
+}
+
+// 修改管理后台账号的角色
+func UpdateAdminRole(db *gorm.DB, name, role string) error {
+	result := db.Model(&AdminAccount{}).Where("name = ?", name).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// 修改管理后台账号的密码哈希
+func UpdateAdminPassword(db *gorm.DB, name, passwordHash string) error {
+	result := db.Model(&AdminAccount{}).Where("name = ?", name).Update("passwordHash", passwordHash)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// 删除管理后台账号
+func DeleteAdminAccount(db *gorm.DB, name string) error {
+	result := db.Delete(&AdminAccount{}, "name = ?", name)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package databasetool
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 审计操作类型，服务器和命令行工具共用
+// 客户端登录和断开记录的目标为用户ID，ResetStaleOnline 据此推算离线时间
+const (
+	AuditLogin          = "login"           // TCP客户端登录
+	AuditRegister       = "register"        // 注册账号
+	AuditLogout         = "logout"          // TCP客户端断开
+	AuditKick           = "kick"            // 管理员踢出或强制下线
+	AuditChangePassword = "change_password" // 用户修改密码或管理员重置密码
+	AuditRename         = "rename"          // 修改用户名
+	AuditFriendRequest  = "friend_request"  // 发送好友请求
+	AuditFriendAccept   = "friend_accept"   // 接受好友请求
+	AuditAdminLogin     = "admin_login"     // 管理后台登录
+	AuditAdminLogout    = "admin_logout"    // 管理后台退出
+	AuditDeleteUser     = "delete_user"     // 删除账号
+	AuditDisableUser    = "disable_user"    // 禁用账号
+	AuditEnableUser     = "enable_user"     // 启用账号
+	AuditBan            = "ban"             // 添加封禁
+	AuditUnban          = "unban"           // 解除封禁
+	AuditCreateAdmin    = "create_admin"    // 创建管理后台账号
+	AuditDeleteAdmin    = "delete_admin"    // 删除管理后台账号
+	AuditChangeRole     = "change_role"     // 修改管理后台账号的角色
+)
+
+// AuditFilter 审计日志的查询条件，零值字段不参与过滤
+type AuditFilter struct {
+	Action  string
+	Actor   string
+	Target  string
+	Ip      string
+	Success *bool
+	Since   time.Time
+	Until   time.Time
+}
+
+// 写入一条审计日志
+func CreateAuditLog(db *gorm.DB, entry *AuditLog) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	result := db.Create(entry)
+	return result.Error
+}
+
+// 按条件分页查询审计日志，按时间倒序，limit 小于0时不分页
+// 返回: 当前页的日志及符合条件的总数
+func QueryAuditLogs(db *gorm.DB, filter AuditFilter, offset int, limit int) ([]AuditLog, int64, error) {
+	tx := db.Model(&AuditLog{})
+	if filter.Action != "" {
+		tx = tx.Where("action = ?", filter.Action)
+	}
+	if filter.Actor != "" {
+		tx = tx.Where("actor = ?", filter.Actor)
+	}
+	if filter.Target != "" {
+		tx = tx.Where("target = ?", filter.Target)
+	}
+	if filter.Ip != "" {
+		tx = tx.Where("ip = ?", filter.Ip)
+	}
+	if filter.Success != nil {
+		tx = tx.Where("success = ?", *filter.Success)
+	}
+	if !filter.Since.IsZero() {
+		tx = tx.Where("createdAt >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		tx = tx.Where("createdAt < ?", filter.Until)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var logs []AuditLog
+	result := tx.Order("createdAt desc, id desc").Offset(offset).Limit(limit).Find(&logs)
+	return logs, total, result.Error
+}
@@ -0,0 +1,91 @@
+package databasetool
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 封禁类型
+const (
+	BanKindUser = "user"
+	BanKindIP   = "ip"
+)
+
+// Permanent 判断是否为永久封禁
+func (b *Ban) Permanent() bool {
+	return b.ExpiresAt.IsZero()
+}
+
+// 添加封禁记录，expiresAt 为零值时表示永久封禁
+func CreateBan(db *gorm.DB, kind, target, reason, issuedBy string, expiresAt time.Time) (*Ban, error) {
+	ban := &Ban{
+		Kind:      kind,
+		Target:    target,
+		Reason:    reason,
+		IssuedBy:  issuedBy,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	result := db.Create(ban)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return ban, nil
+}
+
+// 查找对指定用户ID或IP当前生效的封禁，永久封禁优先，其次是解封时间最晚的
+// 没有生效的封禁时返回 gorm.ErrRecordNotFound
+func FindActiveBan(db *gorm.DB, kind, target string, now time.Time) (*Ban, error) {
+	var bans []Ban
+	result := db.Where("kind = ? AND target = ?", kind, target).Find(&bans)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	var active *Ban
+	for i := range bans {
+		ban := &bans[i]
+		if !ban.Permanent() && !ban.ExpiresAt.After(now) {
+			continue
+		}
+		if active == nil || ban.Permanent() || (!active.Permanent() && ban.ExpiresAt.After(active.ExpiresAt)) {
+			active = ban
+		}
+	}
+	if active == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return active, nil
+}
+
+// 查询所有生效中的封禁，按封禁时间倒序
+func ListActiveBans(db *gorm.DB, now time.Time) ([]Ban, error) {
+	var bans []Ban
+	result := db.Where("expiresAt IS NULL OR expiresAt = ? OR expiresAt > ?", time.Time{}, now).
+		Order("createdAt desc").
+		Find(&bans)
+	return bans, result.Error
+}
+
+// 通过ID查找封禁记录
+func FindBanById(db *gorm.DB, id int) (*Ban, error) {
+	var ban Ban
+	result := db.First(&ban, "id = ?", id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &ban, nil
+}
+
+// 解除封禁
+func DeleteBan(db *gorm.DB, id int) error {
+	result := db.Delete(&Ban{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
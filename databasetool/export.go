@@ -0,0 +1,187 @@
+package databasetool
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SnapshotVersion 导出文件的格式版本，导入时不一致则拒绝
+const SnapshotVersion = 1
+
+// Snapshot 导出的用户、暂存消息和管理后台账号
+// 会话、封禁和审计日志不导出
+type Snapshot struct {
+	Version     int             `json:"version"`
+	ExportedAt  time.Time       `json:"exported_at"`
+	Users       []ExportedUser  `json:"users"`
+	Unsendchats []ExportedChat  `json:"unsendchats"`
+	Admins      []ExportedAdmin `json:"admins"`
+}
+
+// ExportedUser 导出的聊天用户，ID保持不变，因为好友关系按用户ID的位置保存在 Relation 中
+type ExportedUser struct {
+	ID           uint      `json:"id"`
+	Name         string    `json:"name"`
+	Password     string    `json:"password"`
+	Ip           string    `json:"ip"`
+	Relation     []byte    `json:"relation"` // JSON中为base64
+	RegisterTime time.Time `json:"register_time"`
+	LeaveTime    time.Time `json:"leave_time"`
+	Disabled     bool      `json:"disabled"`
+}
+
+// ExportedChat 导出的暂存消息，导入时重新分配 logid
+type ExportedChat struct {
+	SendID    string    `json:"sendid"`
+	ReceiveID string    `json:"reciveid"`
+	Content   string    `json:"content"`
+	SendTime  time.Time `json:"send_time"`
+}
+
+// ExportedAdmin 导出的管理后台账号，包含密码哈希
+type ExportedAdmin struct {
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"password_hash"`
+	Role         string    `json:"role"`
+	CreatedAt    time.Time `json:"created_at"`
+	CreatedBy    string    `json:"created_by"`
+}
+
+// 导出所有用户、暂存消息和管理后台账号
+func ExportSnapshot(db *gorm.DB) (*Snapshot, error) {
+	var users []User
+	if err := db.Order("Id").Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("查询用户失败: %v", err)
+	}
+	chats, err := ListUnsendChats(db, "", 0)
+	if err != nil {
+		return nil, fmt.Errorf("查询暂存消息失败: %v", err)
+	}
+	admins, err := ListAdminAccounts(db)
+	if err != nil {
+		return nil, fmt.Errorf("查询管理员失败: %v", err)
+	}
+
+	snap := &Snapshot{
+		Version:     SnapshotVersion,
+		ExportedAt:  time.Now(),
+		Users:       make([]ExportedUser, 0, len(users)),
+		Unsendchats: make([]ExportedChat, 0, len(chats)),
+		Admins:      make([]ExportedAdmin, 0, len(admins)),
+	}
+	for _, u := range users {
+		snap.Users = append(snap.Users, ExportedUser{
+			ID:           u.ID,
+			Name:         u.Name,
+			Password:     u.Password,
+			Ip:           u.Ip,
+			Relation:     u.Relation,
+			RegisterTime: u.RegisterTime,
+			LeaveTime:    u.LeaveTime,
+			Disabled:     u.Disabled,
+		})
+	}
+	for _, c := range chats {
+		snap.Unsendchats = append(snap.Unsendchats, ExportedChat{
+			SendID:    c.Sendid,
+			ReceiveID: c.Reciveid,
+			Content:   c.Content,
+			SendTime:  c.SendTime,
+		})
+	}
+	for _, a := range admins {
+		snap.Admins = append(snap.Admins, ExportedAdmin{
+			Name:         a.Name,
+			PasswordHash: a.PasswordHash,
+			Role:         a.Role,
+			CreatedAt:    a.CreatedAt,
+			CreatedBy:    a.CreatedBy,
+		})
+	}
+	return snap, nil
+}
+
+// ImportStats 导入的记录数
+type ImportStats struct {
+	Users       int
+	Unsendchats int
+	Admins      int
+}
+
+// 在一个事务中导入快照，用户ID或用户名、管理员名与已有记录冲突时整体回滚
+// 导入的用户均为离线状态
+func ImportSnapshot(db *gorm.DB, snap *Snapshot) (ImportStats, error) {
+	var stats ImportStats
+	if snap.Version != SnapshotVersion {
+		return stats, fmt.Errorf("不支持的导出文件版本: %d", snap.Version)
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, u := range snap.Users {
+			if u.ID == 0 || u.Name == "" {
+				return fmt.Errorf("用户记录缺少ID或用户名: id=%d name=%q", u.ID, u.Name)
+			}
+			var count int64
+			if err := tx.Model(&User{}).Where("Id = ? OR Name = ?", u.ID, u.Name).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return fmt.Errorf("用户 %d(%s) 与已有用户冲突", u.ID, u.Name)
+			}
+			relation := u.Relation
+			if len(relation) == 0 {
+				relation = make([]byte, 8)
+			}
+			record := &User{
+				ID:           u.ID,
+				Name:         u.Name,
+				Password:     u.Password,
+				Ip:           u.Ip,
+				Relation:     relation,
+				RegisterTime: u.RegisterTime,
+				LeaveTime:    u.LeaveTime,
+				Status:       0,
+				Disabled:     u.Disabled,
+			}
+			if err := tx.Create(record).Error; err != nil {
+				return fmt.Errorf("导入用户 %d 失败: %v", u.ID, err)
+			}
+			stats.Users++
+		}
+
+		for _, c := range snap.Unsendchats {
+			chat := &Unsendchat{Sendid: c.SendID, Reciveid: c.ReceiveID, Content: c.Content, SendTime: c.SendTime}
+			if err := tx.Create(chat).Error; err != nil {
+				return fmt.Errorf("导入暂存消息失败: %v", err)
+			}
+			stats.Unsendchats++
+		}
+
+		for _, a := range snap.Admins {
+			if _, err := FindAdminAccount(tx, a.Name); err == nil {
+				return fmt.Errorf("管理员 %s 已存在", a.Name)
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			account := &AdminAccount{
+				Name:         a.Name,
+				PasswordHash: a.PasswordHash,
+				Role:         a.Role,
+				CreatedAt:    a.CreatedAt,
+				CreatedBy:    a.CreatedBy,
+			}
+			if err := tx.Create(account).Error; err != nil {
+				return fmt.Errorf("导入管理员 %s 失败: %v", a.Name, err)
+			}
+			stats.Admins++
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportStats{}, err
+	}
+	return stats, nil
+}
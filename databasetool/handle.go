@@ -2,43 +2,76 @@ package databasetool
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
 	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-func InitDB() *gorm.DB {
-	dsn := "communication.db"
-	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+// PoolConfig 数据库连接池参数
+type PoolConfig struct {
+	MaxIdleConns    int           // 最大空闲连接数
+	MaxOpenConns    int           // 最大连接数
+	ConnMaxLifetime time.Duration // 单个连接的最长使用时间
+}
+
+// DefaultPool 默认的连接池参数
+var DefaultPool = PoolConfig{MaxIdleConns: 10, MaxOpenConns: 100, ConnMaxLifetime: time.Hour}
+
+// InitDB 打开数据库，失败时直接退出程序
+func InitDB(dsn string, pool PoolConfig) *gorm.DB {
+	db, err := OpenDBWithPool(dsn, pool)
 	if err != nil {
-		log.Fatal("failed to connect database")
+		slog.Error("打开数据库失败", "path", dsn, "err", err)
+		os.Exit(1)
+	}
+	return db
+}
+
+// OpenDB 使用默认连接池参数打开数据库
+func OpenDB(dsn string) (*gorm.DB, error) {
+	return OpenDBWithPool(dsn, DefaultPool)
+}
+
+// OpenDBWithPool 打开指定路径的SQLite数据库并完成表结构迁移
+// SQL日志写入当前的全局默认日志记录器
+func OpenDBWithPool(dsn string, pool PoolConfig) (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: NewGormLogger(slog.Default().With("component", "db")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect database: %v", err)
 	}
 
 	// 确认连接成功
 	sqlDB, err := db.DB()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	if err := db.AutoMigrate(&User{}, &Unsendchat{}); err != nil {
-		log.Fatal(err)
+	if err := registerMetricsCallbacks(db); err != nil {
+		return nil, err
+	}
+
+	if err := db.AutoMigrate(&User{}, &Unsendchat{}, &AdminSession{}, &Ban{}, &AuditLog{}, &AdminAccount{}, &PendingFile{}); err != nil {
+		return nil, err
 	}
 
 	// 设置连接池参数
-	sqlDB.SetMaxIdleConns(10)
-	sqlDB.SetMaxOpenConns(100)
-	sqlDB.SetConnMaxLifetime(time.Hour)
+	sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime)
 
-	return db
+	return db, nil
 }
 
 // 注册用户
 func RegisterUser(db *gorm.DB, name string, password string, ip string) (uint, error) {
 	now := time.Now()
 	relationBytes := make([]byte, 8)
-	user := &User{Name: name, Password: password, Ip: ip, Relation: relationBytes, RegisterTime: now, Status: 1}
+	user := &User{Name: name, Password: password, Ip: ip, Relation: relationBytes, RegisterTime: now, Status: 0}
 	result := db.Create(user) // 通过数据的指针来创建
 	if result.Error != nil {
 		return 0, result.Error
@@ -86,6 +119,31 @@ func UserOffline(db *gorm.DB, id int) error {
 	return result.Error
 }
 
+// MarkAllUsersOffline 将所有仍标记为在线的用户设为离线，返回修改的行数
+// leaveTime 作为这些用户的离线时间
+func MarkAllUsersOffline(db *gorm.DB, leaveTime time.Time) (int64, error) {
+	result := db.Model(&User{}).Where("Status = ?", 1).Updates(map[string]interface{}{"Status": 0, "LeaveTime": leaveTime})
+	return result.RowsAffected, result.Error
+}
+
+// ResetStaleOnline 启动时调用：进程异常退出后仍标记为在线的用户全部设为离线
+// 离线时间取该用户最后一次成功登录或断开的审计记录时间(不晚于 fallback)，没有记录时取 fallback，返回修改的用户数
+// 按目标匹配：登录和断开记录的目标是用户ID；旧版本写入的登录记录操作者为用户名，纯数字的用户名可能与其他用户的ID相同
+func ResetStaleOnline(db *gorm.DB, fallback time.Time) (int, error) {
+	last := db.Model(&AuditLog{}).
+		Select("MAX(createdAt)").
+		Where("target = CAST(User.Id AS TEXT) AND action IN ? AND success = ? AND createdAt <= ?",
+			[]string{AuditLogin, AuditLogout}, true, fallback)
+	result := db.Model(&User{}).Where("Status = ?", 1).Updates(map[string]interface{}{
+		"Status":    0,
+		"LeaveTime": gorm.Expr("COALESCE((?), ?)", last, fallback),
+	})
+	if result.Error != nil {
+		return 0, fmt.Errorf("重置在线用户状态失败: %v", result.Error)
+	}
+	return int(result.RowsAffected), nil
+}
+
 // 用户上线
 func UserOnline(db *gorm.DB, id int, ip string) error {
 	//更新用户登录状态及IP地址
@@ -217,3 +275,22 @@ func CreateUnsendChat(db *gorm.DB, sendid, reciveid string, content string) erro
 	result := db.Create(&newChat)
 	return result.Error
 }
+
+// 批量添加同一内容的记录，用于离线广播
+func CreateUnsendChats(db *gorm.DB, sendid string, reciveids []string, content string) error {
+	if len(reciveids) == 0 {
+		return nil
+	}
+	now := time.Now()
+	chats := make([]Unsendchat, 0, len(reciveids))
+	for _, reciveid := range reciveids {
+		chats = append(chats, Unsendchat{
+			Sendid:   sendid,
+			Reciveid: reciveid,
+			Content:  content,
+			SendTime: now,
+		})
+	}
+	result := db.CreateInBatches(chats, 100)
+	return result.Error
+}
@@ -0,0 +1,101 @@
+package databasetool
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "chat.db"))
+	if err != nil {
+		t.Fatalf("打开测试数据库失败: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestResetStaleOnline(t *testing.T) {
+	db := openTestDB(t)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	fallback := base.Add(time.Hour)
+
+	register := func(name string, status int) uint {
+		id, err := RegisterUser(db, name, "pwd", "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Model(&User{}).Where("Id = ?", id).Update("Status", status)
+		return id
+	}
+	// 用户名 "2" 与 bob 的ID相同
+	numeric := register("2", 1)
+	bob := register("bob", 1)
+	carol := register("carol", 1)
+	dave := register("dave", 0)
+	if bob != 2 {
+		t.Fatalf("bob 的ID = %d, 期望 2", bob)
+	}
+
+	logs := []AuditLog{
+		{Action: AuditLogin, Actor: "2", Target: "2", Success: true, CreatedAt: base},
+		{Action: AuditLogin, Actor: "2", Target: "1", Success: true, CreatedAt: base.Add(10 * time.Minute)}, // 旧版本记录，用户 "2" 登录，操作者为用户名，与bob的ID相同
+		{Action: AuditLogin, Actor: "2", Target: "2", Success: false, CreatedAt: base.Add(20 * time.Minute)},
+		{Action: "kick", Actor: "admin:root", Target: "2", Success: true, CreatedAt: base.Add(30 * time.Minute)},
+		{Action: AuditLogout, Actor: "3", Target: "3", Success: true, CreatedAt: base.Add(5 * time.Minute)},
+		{Action: AuditLogin, Actor: "3", Target: "3", Success: true, CreatedAt: base.Add(2 * time.Hour)}, // 晚于 fallback
+	}
+	for i := range logs {
+		if err := CreateAuditLog(db, &logs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := ResetStaleOnline(db, fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("ResetStaleOnline 返回 %d, 期望 3", count)
+	}
+
+	cases := []struct {
+		id    uint
+		leave time.Time
+	}{
+		{numeric, base.Add(10 * time.Minute)},
+		{bob, base},                        // 不受用户 "2" 的登录和失败登录、管理员操作影响
+		{carol, base.Add(5 * time.Minute)}, // 晚于 fallback 的记录不计
+	}
+	for _, c := range cases {
+		u, err := FindUserById(db, int(c.id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Status != 0 {
+			t.Errorf("用户 %d 仍在线", c.id)
+		}
+		if !u.LeaveTime.Equal(c.leave) {
+			t.Errorf("用户 %d 的离线时间 = %v, 期望 %v", c.id, u.LeaveTime, c.leave)
+		}
+	}
+
+	// 没有记录的在线用户取 fallback，离线用户不修改
+	eve := register("eve", 1)
+	if count, err := ResetStaleOnline(db, fallback); err != nil || count != 1 {
+		t.Fatalf("ResetStaleOnline = %d, %v, 期望 1", count, err)
+	}
+	if u, _ := FindUserById(db, int(eve)); !u.LeaveTime.Equal(fallback) {
+		t.Errorf("没有记录的用户离线时间 = %v, 期望 %v", u.LeaveTime, fallback)
+	}
+	if u, _ := FindUserById(db, int(dave)); !u.LeaveTime.IsZero() {
+		t.Errorf("离线用户的离线时间被修改为 %v", u.LeaveTime)
+	}
+}
@@ -0,0 +1,76 @@
+package databasetool
+
+//将gorm的日志转为结构化日志，SQL只记录占位符，不记录参数值
+import (
+	"connection_server_linux/logging"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// slowQueryThreshold 超过该耗时的SQL记为慢查询
+const slowQueryThreshold = 200 * time.Millisecond
+
+// gormLogger 实现 gorm 的 logger.Interface
+// 日志记录器优先取自 context(由调用方通过 db.WithContext 传入)，否则使用创建时传入的记录器
+type gormLogger struct {
+	logger *slog.Logger
+}
+
+// NewGormLogger 创建写入结构化日志的gorm日志适配器
+func NewGormLogger(logger *slog.Logger) gormlogger.Interface {
+	return &gormLogger{logger: logger}
+}
+
+func (l *gormLogger) from(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := logging.Lookup(ctx); ok {
+			return logger.With("component", "db")
+		}
+	}
+	return l.logger
+}
+
+// LogMode 级别由 slog 处理器统一控制，这里忽略
+func (l *gormLogger) LogMode(gormlogger.LogLevel) gormlogger.Interface {
+	return l
+}
+
+func (l *gormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	l.from(ctx).Info(fmt.Sprintf(msg, args...))
+}
+
+func (l *gormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	l.from(ctx).Warn(fmt.Sprintf(msg, args...))
+}
+
+func (l *gormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	l.from(ctx).Error(fmt.Sprintf(msg, args...))
+}
+
+// Trace 出错时记为error，慢查询记为warn，其余记为debug；记录不存在属于正常情况，不视为错误
+func (l *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	logger := l.from(ctx)
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		logger.Error("SQL执行失败", "sql", sql, "rows", rows, "elapsed", elapsed, "err", err)
+	case elapsed > slowQueryThreshold:
+		sql, rows := fc()
+		logger.Warn("慢查询", "sql", sql, "rows", rows, "elapsed", elapsed)
+	case logger.Enabled(ctx, slog.LevelDebug):
+		sql, rows := fc()
+		logger.Debug("SQL", "sql", sql, "rows", rows, "elapsed", elapsed)
+	}
+}
+
+// ParamsFilter 不把参数值拼接进SQL，避免密码、消息内容和关系字节出现在日志中
+func (l *gormLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
@@ -0,0 +1,68 @@
+package databasetool
+
+//数据库操作的监控指标，通过gorm回调统计每次操作的耗时和失败次数
+import (
+	"connection_server_linux/metrics"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	queryDuration = metrics.NewHistogramVec("chat_db_query_duration_seconds",
+		"数据库操作耗时，按操作类型(create/query/update/delete/row/raw)分组", metrics.DefBuckets, "operation")
+	queryErrors = metrics.NewCounterVec("chat_db_errors_total",
+		"数据库操作失败次数，不含记录不存在", "operation")
+)
+
+// metricsStartKey 保存操作开始时间的实例变量名
+const metricsStartKey = "metrics:start"
+
+// registerMetricsCallbacks 在gorm的各类操作前后注册计时回调
+func registerMetricsCallbacks(db *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet(metricsStartKey, time.Now())
+	}
+	after := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			if start, ok := tx.InstanceGet(metricsStartKey); ok {
+				queryDuration.WithLabelValues(operation).ObserveSince(start.(time.Time))
+			}
+			if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				queryErrors.WithLabelValues(operation).Inc()
+			}
+		}
+	}
+
+	callbacks := db.Callback()
+	steps := []struct {
+		operation string
+		before    func(string, func(*gorm.DB)) error
+		after     func(string, func(*gorm.DB)) error
+	}{
+		{"create", callbacks.Create().Before("gorm:create").Register, callbacks.Create().After("gorm:create").Register},
+		{"query", callbacks.Query().Before("gorm:query").Register, callbacks.Query().After("gorm:query").Register},
+		{"update", callbacks.Update().Before("gorm:update").Register, callbacks.Update().After("gorm:update").Register},
+		{"delete", callbacks.Delete().Before("gorm:delete").Register, callbacks.Delete().After("gorm:delete").Register},
+		{"row", callbacks.Row().Before("gorm:row").Register, callbacks.Row().After("gorm:row").Register},
+		{"raw", callbacks.Raw().Before("gorm:raw").Register, callbacks.Raw().After("gorm:raw").Register},
+	}
+	for _, step := range steps {
+		if err := step.before("metrics:before_"+step.operation, before); err != nil {
+			return fmt.Errorf("注册数据库监控回调失败: %v", err)
+		}
+		if err := step.after("metrics:after_"+step.operation, after(step.operation)); err != nil {
+			return fmt.Errorf("注册数据库监控回调失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// 统计暂存消息的数量，用于监控离线消息积压
+func CountUnsendChats(db *gorm.DB) (int64, error) {
+	var count int64
+	result := db.Model(&Unsendchat{}).Count(&count)
+	return count, result.Error
+}
@@ -0,0 +1,24 @@
+package databasetool
+
+import (
+	"gorm.io/gorm"
+)
+
+// 登记待接收文件
+func CreatePendingFile(db *gorm.DB, file *PendingFile) error {
+	result := db.Create(file)
+	return result.Error
+}
+
+// 删除待接收文件记录，记录不存在时不报错
+func DeletePendingFile(db *gorm.DB, fileKey string) error {
+	result := db.Delete(&PendingFile{}, "fileKey = ?", fileKey)
+	return result.Error
+}
+
+// 查询所有待接收文件，按上传完成时间排序
+func ListPendingFiles(db *gorm.DB) ([]PendingFile, error) {
+	var files []PendingFile
+	result := db.Order("sendTime").Find(&files)
+	return files, result.Error
+}
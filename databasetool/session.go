@@ -0,0 +1,42 @@
+package databasetool
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 保存管理会话，已存在则覆盖
+func SaveAdminSession(db *gorm.DB, session *AdminSession) error {
+	result := db.Save(session)
+	return result.Error
+}
+
+// 通过摘要查找管理会话
+func FindAdminSession(db *gorm.DB, key string) (*AdminSession, error) {
+	var session AdminSession
+	result := db.First(&session, "key = ?", key)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &session, nil
+}
+
+// 删除管理会话
+func DeleteAdminSession(db *gorm.DB, key string) error {
+	result := db.Delete(&AdminSession{}, "key = ?", key)
+	return result.Error
+}
+
+// 查询所有管理会话
+func ListAdminSessions(db *gorm.DB) ([]AdminSession, error) {
+	var sessions []AdminSession
+	result := db.Order("createdAt desc").Find(&sessions)
+	return sessions, result.Error
+}
+
+// 删除已过期的管理会话，返回删除的数量
+func DeleteExpiredAdminSessions(db *gorm.DB, now time.Time) (int64, error) {
+	result := db.Where("expiresAt < ? OR absoluteExpiresAt < ?", now, now).Delete(&AdminSession{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,110 @@
+package databasetool
+
+import (
+	"connection_server_linux/friendupdate"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 用户名和密码的最大长度，与User表的字段长度一致
+const (
+	MaxUserNameLength     = 30
+	MaxUserPasswordLength = 20
+)
+
+// 分页查询用户，query 非空时按用户名模糊匹配或按ID精确匹配
+// 返回: 当前页的用户及符合条件的总数
+func ListUsers(db *gorm.DB, query string, offset int, limit int) ([]User, int64, error) {
+	tx := db.Model(&User{})
+	if query != "" {
+		if id, err := strconv.Atoi(query); err == nil {
+			tx = tx.Where("Name LIKE ? OR Id = ?", "%"+query+"%", id)
+		} else {
+			tx = tx.Where("Name LIKE ?", "%"+query+"%")
+		}
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []User
+	result := tx.Order("Id").Offset(offset).Limit(limit).Find(&users)
+	return users, total, result.Error
+}
+
+// 禁用或启用用户
+func SetUserDisabled(db *gorm.DB, id int, disabled bool) error {
+	result := db.Model(&User{}).Where("Id = ?", id).Update("Disabled", disabled)
+	return result.Error
+}
+
+// 删除发给指定用户的所有暂存消息
+func DeleteUnsendChatsByReciveID(db *gorm.DB, reciveid string) error {
+	result := db.Where("reciveid = ?", reciveid).Delete(&Unsendchat{})
+	return result.Error
+}
+
+// 查询所有用户的ID
+func ListUserIDs(db *gorm.DB) ([]uint, error) {
+	var ids []uint
+	result := db.Model(&User{}).Order("Id").Pluck("Id", &ids)
+	return ids, result.Error
+}
+
+// 清除其他用户与指定用户之间的好友/请求/拉黑关系，用于删除用户
+// relation 为该用户的关系字节；单个用户更新失败时继续处理其余用户，返回所有错误
+func ClearRelations(db *gorm.DB, id int, relation []byte) error {
+	var errs []error
+	statuses := friendupdate.AnalyzeRelationByte(relation)
+	for otherID := 1; otherID < len(statuses); otherID++ {
+		if statuses[otherID] == friendupdate.NoRelation {
+			continue
+		}
+		other, err := FindUserById(db, otherID)
+		if err != nil {
+			continue
+		}
+		updated, err := SetRelationBit(other.Relation, id, friendupdate.NoRelation)
+		if err != nil {
+			continue
+		}
+		if err := UpdateRelation(db, otherID, updated); err != nil {
+			errs = append(errs, fmt.Errorf("用户 %d: %v", otherID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 查询暂存消息，按发送时间排序；reciveid 为空时查询所有接收者，limit 小于等于0时不限制数量
+func ListUnsendChats(db *gorm.DB, reciveid string, limit int) ([]Unsendchat, error) {
+	tx := db.Order("sendTime").Order("logid")
+	if reciveid != "" {
+		tx = tx.Where("reciveid = ?", reciveid)
+	}
+	if limit > 0 {
+		tx = tx.Limit(limit)
+	}
+	var chats []Unsendchat
+	result := tx.Find(&chats)
+	return chats, result.Error
+}
+
+// 删除暂存消息，reciveid 为空时不限接收者，before 为零值时不限发送时间
+// 返回: 删除的条数
+func PurgeUnsendChats(db *gorm.DB, reciveid string, before time.Time) (int64, error) {
+	tx := db.Where("1 = 1")
+	if reciveid != "" {
+		tx = tx.Where("reciveid = ?", reciveid)
+	}
+	if !before.IsZero() {
+		tx = tx.Where("sendTime < ?", before)
+	}
+	result := tx.Delete(&Unsendchat{})
+	return result.RowsAffected, result.Error
+}
@@ -14,6 +14,7 @@ type User struct {
 	RegisterTime time.Time `gorm:"column:RegisterTime;type:datetime;not null"` // 注册时间，不允许为空
 	LeaveTime    time.Time `gorm:"column:LeaveTime;type:datetime"`             // 离开时间，允许为空
 	Status       int       `gorm:"column:Status;type:int(1);not null"`         // 状态，类型为bit(1)，不允许为空
+	Disabled     bool      `gorm:"column:Disabled;not null;default:false"`     // 是否被管理员禁用，禁用后无法登录
 }
 
 func (User) TableName() string {
@@ -32,3 +33,82 @@ type Unsendchat struct {
 func (Unsendchat) TableName() string {
 	return "Unsendchat" // 指定表名为Unsendchat
 }
+
+
+// 管理后台登录会话表
+type AdminSession struct {
+	Key               string    `gorm:"column:key;primaryKey;type:varchar(64)"`          // 会话ID的SHA-256摘要
+	UserID            string    `gorm:"column:userid;type:varchar(30);not null"`         // 管理员用户名
+	CreatedAt         time.Time `gorm:"column:createdAt;type:datetime;not null"`         // 创建时间
+	ExpiresAt         time.Time `gorm:"column:expiresAt;type:datetime;not null"`         // 空闲过期时间，随访问顺延
+	AbsoluteExpiresAt time.Time `gorm:"column:absoluteExpiresAt;type:datetime;not null"` // 绝对过期时间，不会顺延
+	Ip                string    `gorm:"column:ip;type:varchar(64)"`                      // 登录IP
+	UserAgent         string    `gorm:"column:userAgent;type:text"`                      // 浏览器标识
+	CsrfToken         string    `gorm:"column:csrfToken;type:varchar(64)"`               // CSRF令牌
+	Role              string    `gorm:"column:role;type:varchar(10)"`                    // 管理员角色
+}
+
+func (AdminSession) TableName() string {
+	return "AdminSession" // 指定表名为AdminSession
+}
+
+// 管理后台账号表，密码以PBKDF2哈希保存
+type AdminAccount struct {
+	Name         string    `gorm:"column:name;primaryKey;type:varchar(30)" json:"name"`       // 管理员用户名
+	PasswordHash string    `gorm:"column:passwordHash;type:varchar(128);not null" json:"-"`   // 密码哈希
+	Role         string    `gorm:"column:role;type:varchar(10);not null" json:"role"`         // 角色：viewer、operator 或 admin
+	CreatedAt    time.Time `gorm:"column:createdAt;type:datetime;not null" json:"created_at"` // 创建时间
+	CreatedBy    string    `gorm:"column:createdBy;type:varchar(30)" json:"created_by"`       // 创建该账号的管理员
+}
+
+func (AdminAccount) TableName() string {
+	return "AdminAccount" // 指定表名为AdminAccount
+}
+
+// 封禁记录表，封禁账号或IP，过期后自动失效
+type Ban struct {
+	ID        uint      `gorm:"column:id;primaryKey;autoIncrement" json:"id"`              // 主键
+	Kind      string    `gorm:"column:kind;type:varchar(10);not null" json:"kind"`         // 封禁类型：user 或 ip
+	Target    string    `gorm:"column:target;type:varchar(64);not null" json:"target"`     // 被封禁的用户ID或IP
+	Reason    string    `gorm:"column:reason;type:text" json:"reason"`                     // 封禁原因
+	IssuedBy  string    `gorm:"column:issuedBy;type:varchar(30)" json:"issued_by"`         // 执行封禁的管理员
+	CreatedAt time.Time `gorm:"column:createdAt;type:datetime;not null" json:"created_at"` // 封禁时间
+	ExpiresAt time.Time `gorm:"column:expiresAt;type:datetime" json:"expires_at"`          // 解封时间，零值表示永久封禁
+}
+
+func (Ban) TableName() string {
+	return "Ban" // 指定表名为Ban
+}
+
+// 审计日志表，记录登录、注册、踢出、资料修改和好友操作
+type AuditLog struct {
+	ID        uint      `gorm:"column:id;primaryKey;autoIncrement" json:"id"`                    // 主键
+	Action    string    `gorm:"column:action;type:varchar(30);not null;index" json:"action"`     // 操作类型
+	Actor     string    `gorm:"column:actor;type:varchar(64);index" json:"actor"`                // 操作者：聊天用户ID、admin:管理员名或 cli:系统用户名，账号不存在或已锁定的登录尝试为空
+	Target    string    `gorm:"column:target;type:varchar(64);index" json:"target"`              // 操作对象，通常是用户ID
+	Ip        string    `gorm:"column:ip;type:varchar(64)" json:"ip"`                            // 操作者IP
+	Success   bool      `gorm:"column:success;not null" json:"success"`                          // 操作是否成功
+	Detail    string    `gorm:"column:detail;type:text" json:"detail"`                           // 补充说明
+	CreatedAt time.Time `gorm:"column:createdAt;type:datetime;not null;index" json:"created_at"` // 记录时间
+}
+
+func (AuditLog) TableName() string {
+	return "AuditLog" // 指定表名为AuditLog
+}
+
+// 待接收文件表：已上传到服务器、等待接收者确认的文件，服务器重启后据此恢复
+type PendingFile struct {
+	FileKey          string    `gorm:"column:fileKey;primaryKey;type:varchar(255)"`       // 存储目录中的文件名
+	TransferID       string    `gorm:"column:transferId;type:varchar(64);not null"`       // 面向接收者的传输ID
+	SenderTransferID string    `gorm:"column:senderTransferId;type:varchar(64)"`          // 发送方原始的传输ID
+	Filename         string    `gorm:"column:filename;type:varchar(255);not null"`        // 文件名
+	FileSize         int64     `gorm:"column:fileSize;not null"`                          // 文件大小
+	Hash             string    `gorm:"column:hash;type:varchar(64)"`                      // 文件SHA-256(十六进制)
+	SendTime         time.Time `gorm:"column:sendTime;type:datetime;not null"`            // 上传完成时间，用于计算保存期限
+	SenderID         string    `gorm:"column:senderId;type:varchar(20);not null"`         // 发送者ID
+	ReceiverID       string    `gorm:"column:receiverId;type:varchar(20);not null;index"` // 接收者ID
+}
+
+func (PendingFile) TableName() string {
+	return "PendingFile" // 指定表名为PendingFile
+}
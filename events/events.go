@@ -0,0 +1,96 @@
+package events
+
+//服务器事件广播，供管理后台通过SSE实时订阅
+import (
+	"sync"
+	"time"
+)
+
+// 事件类型
+const (
+	ClientConnected    = "client_connected"    // 客户端登录成功
+	ClientDisconnected = "client_disconnected" // 客户端断开连接
+	ClientKicked       = "client_kicked"       // 客户端被管理员踢出或强制下线
+	MessageCount       = "message_count"       // 客户端消息计数变化
+	FileTransfer       = "file_transfer"       // 文件传输状态变化
+)
+
+// subscriberBuffer 每个订阅者缓存的事件数，订阅者处理不过来时丢弃新事件，不阻塞发布方
+const subscriberBuffer = 64
+
+// Event 一条服务器事件
+type Event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// Hub 事件中心，负责把事件分发给所有订阅者
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	closed bool
+}
+
+// Default 全局事件中心
+var Default = NewHub()
+
+// NewHub 创建事件中心
+func NewHub() *Hub {
+	return &Hub{subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe 订阅事件，返回事件通道和取消订阅函数
+func (h *Hub) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	h.mu.Lock()
+	if h.closed {
+		// 已关闭的事件中心返回关闭的通道，订阅方会立即结束
+		close(ch)
+	} else {
+		h.subs[ch] = struct{}{}
+	}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs, ch)
+			h.mu.Unlock()
+		})
+	}
+}
+
+// Publish 向所有订阅者发布事件
+func (h *Hub) Publish(eventType string, data interface{}) {
+	event := Event{Type: eventType, Time: time.Now(), Data: data}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Close 关闭所有订阅者的事件通道，服务器关闭时用来结束SSE长连接
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for ch := range h.subs {
+		close(ch)
+		delete(h.subs, ch)
+	}
+}
+
+// Publish 向全局事件中心发布事件
+func Publish(eventType string, data interface{}) {
+	Default.Publish(eventType, data)
+}
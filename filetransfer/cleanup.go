@@ -0,0 +1,99 @@
+package filetransfer
+
+//存储目录清理：删除超过保存期限仍未被收取的文件，以及不属于任何待接收文件或上传的遗留文件
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Cleanup 删除 SendTime 早于 now-ttl 的待接收文件并通知双方(file_expired)，
+// 再删除存储目录中修改时间早于 now-ttl、且不属于任何待接收文件或上传的遗留文件。
+// 正在下发的文件等下发结束后再处理。ttl 为0时不做任何清理，返回删除的过期文件数和遗留文件数
+func (m *Manager) Cleanup(now time.Time) (expired int, orphaned int) {
+	if m.ttl <= 0 {
+		return 0, 0
+	}
+	deadline := now.Add(-m.ttl)
+
+	m.mu.Lock()
+	var expiredFiles []*PendingFile
+	for _, file := range m.pending {
+		if _, delivering := m.deliveries[file.TransferID]; delivering {
+			continue
+		}
+		if file.SendTime.Before(deadline) {
+			expiredFiles = append(expiredFiles, file)
+		}
+	}
+	for _, file := range expiredFiles {
+		m.unlistLocked(file)
+	}
+	known := make(map[string]bool, len(m.pending)+len(m.uploads))
+	for key := range m.pending {
+		known[key] = true
+	}
+	for _, up := range m.uploads {
+		known[up.fileKey] = true
+	}
+	m.mu.Unlock()
+
+	for _, file := range expiredFiles {
+		m.dropFile(file)
+		m.notify(file.SenderID, expiredMessage(file.SenderTransferID, file))
+		m.notify(file.ReceiverID, expiredMessage(file.TransferID, file))
+		m.publishFileEvent(stageExpired, file)
+		logger().Info("文件超过保存期限未被收取，已删除", "file", file.Filename, "sender", file.SenderID, "receiver", file.ReceiverID)
+	}
+
+	entries, err := os.ReadDir(m.storageDir)
+	if err != nil {
+		logger().Error("读取文件存储目录失败", "dir", m.storageDir, "err", err)
+		return len(expiredFiles), 0
+	}
+	for _, entry := range entries {
+		// 跳过子目录和健康检查等临时文件
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || known[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(deadline) {
+			continue
+		}
+		path := filepath.Join(m.storageDir, entry.Name())
+		if err := os.Remove(path); err != nil {
+			logger().Error("删除遗留文件失败", "path", path, "err", err)
+			continue
+		}
+		orphaned++
+		logger().Info("已删除存储目录中的遗留文件", "path", path)
+	}
+	return len(expiredFiles), orphaned
+}
+
+// RunCleanup 启动时以及每隔 interval 调用一次 Cleanup，直到 ctx 结束
+func (m *Manager) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if expired, orphaned := m.Cleanup(time.Now()); expired > 0 || orphaned > 0 {
+			logger().Info("文件存储清理完成", "expired", expired, "orphaned", orphaned)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expiredMessage 组装文件过期通知，发送方和接收者使用各自的传输ID
+func expiredMessage(transferID string, file *PendingFile) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "file_expired",
+		"transferid": transferID,
+		"filename":   file.Filename,
+	}
+}
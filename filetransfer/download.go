@@ -0,0 +1,110 @@
+package filetransfer
+
+//文件下载链接：在HTTPS服务器上为待接收文件签发限时链接
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// downloadLinkTTL 下载链接的有效期
+const downloadLinkTTL = 15 * time.Minute
+
+// signDownload 计算传输ID和过期时间的签名
+func (m *Manager) signDownload(transferID string, expires int64) string {
+	mac := hmac.New(sha256.New, m.secret)
+	fmt.Fprintf(mac, "%s|%d", transferID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyDownload 校验下载链接的签名和有效期
+func (m *Manager) verifyDownload(transferID, expiresStr, sig string) bool {
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	expected := m.signDownload(transferID, expires)
+	return hmac.Equal([]byte(expected), []byte(sig))
+}
+
+// downloadURL 为待接收文件生成限时下载链接
+// 主机取自客户端连接到的本机地址，保证客户端能访问到同一台服务器
+func (m *Manager) downloadURL(conn net.Conn, file *PendingFile) (string, int64) {
+	expires := time.Now().Add(downloadLinkTTL).Unix()
+
+	host := "localhost"
+	if tcpAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok && tcpAddr.IP != nil && !tcpAddr.IP.IsUnspecified() {
+		host = tcpAddr.IP.String()
+	}
+
+	link := url.URL{
+		Scheme: "https",
+		Host:   net.JoinHostPort(host, strconv.Itoa(m.httpsPort)),
+		Path:   "/files/" + file.TransferID,
+		RawQuery: url.Values{
+			"expires": {strconv.FormatInt(expires, 10)},
+			"sig":     {m.signDownload(file.TransferID, expires)},
+		}.Encode(),
+	}
+	return link.String(), expires
+}
+
+// findByTransferID 按面向接收者的传输ID查找待接收文件
+func (m *Manager) findByTransferID(transferID string) (*PendingFile, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, file := range m.pending {
+		if file.TransferID == transferID {
+			return file, true
+		}
+	}
+	return nil, false
+}
+
+// ServeDownload 通过签名链接下载待接收文件，支持Range断点续传
+func (m *Manager) ServeDownload(w http.ResponseWriter, r *http.Request, transferID string) {
+	query := r.URL.Query()
+	if !m.verifyDownload(transferID, query.Get("expires"), query.Get("sig")) {
+		http.Error(w, "下载链接无效或已过期", http.StatusForbidden)
+		return
+	}
+
+	file, ok := m.findByTransferID(transferID)
+	if !ok {
+		http.Error(w, "文件不存在或已被处理", http.StatusNotFound)
+		return
+	}
+
+	fileData, err := os.Open(file.FilePath)
+	if err != nil {
+		logger().Error("打开待下载文件失败", "path", file.FilePath, "err", err)
+		http.Error(w, "文件不存在或已被处理", http.StatusNotFound)
+		return
+	}
+	defer fileData.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(file.Filename))
+	w.Header().Set("ETag", `"`+file.Hash+`"`)
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.ServeContent(&countingWriter{ResponseWriter: w}, r, file.Filename, file.SendTime, fileData)
+}
+
+// countingWriter 统计通过HTTPS下发的文件字节数
+type countingWriter struct {
+	http.ResponseWriter
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	bytesSent.WithLabelValues("https").Add(uint64(n))
+	return n, err
+}
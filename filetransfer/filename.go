@@ -0,0 +1,75 @@
+package filetransfer
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxFilenameBytes 文件名允许的最大字节数(不含服务器添加的前缀)
+const maxFilenameBytes = 200
+
+// sanitizeFilename 规范化客户端提供的文件名
+// 只保留最后一级路径，去掉控制字符和各平台的保留字符，拒绝空名、"."和".."
+func sanitizeFilename(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", errors.New("文件名不是有效的UTF-8")
+	}
+
+	// 同时按 / 和 \ 切分，只保留最后一级，丢弃客户端携带的目录
+	name = strings.ReplaceAll(name, "\\", "/")
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case unicode.IsControl(r), r == utf8.RuneError:
+			continue
+		case strings.ContainsRune(`<>:"|?*`, r):
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	// 去掉首尾的空白和点，避免隐藏文件和Windows下的尾部点号
+	cleaned := strings.Trim(b.String(), " .\t")
+	if cleaned == "" {
+		return "", fmt.Errorf("无效的文件名: %q", name)
+	}
+
+	// 按字符边界截断过长的文件名，尽量保留扩展名
+	if len(cleaned) > maxFilenameBytes {
+		ext := filepath.Ext(cleaned)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		base := cleaned[:len(cleaned)-len(ext)]
+		limit := maxFilenameBytes - len(ext)
+		for limit > 0 && !utf8.RuneStart(base[limit]) {
+			limit--
+		}
+		cleaned = base[:limit] + ext
+	}
+
+	return cleaned, nil
+}
+
+// storagePath 返回文件在存储目录中的绝对路径，并确认没有逃逸出存储目录
+func (m *Manager) storagePath(fileKey string) (string, error) {
+	if fileKey == "" || strings.ContainsAny(fileKey, "/\\") || fileKey == "." || fileKey == ".." {
+		return "", fmt.Errorf("非法的文件索引: %q", fileKey)
+	}
+
+	base := m.storageDir
+	full := filepath.Join(base, fileKey)
+	if filepath.Dir(full) != base {
+		return "", fmt.Errorf("文件路径超出存储目录: %q", fileKey)
+	}
+	return full, nil
+}
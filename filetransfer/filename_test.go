@@ -0,0 +1,101 @@
+package filetransfer
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"普通文件名", "report.pdf", "report.pdf"},
+		{"中文文件名", "聊天测试.txt", "聊天测试.txt"},
+		{"相对路径穿越", "../../etc/passwd", "passwd"},
+		{"Windows路径穿越", `..\..\Windows\system32\cmd.exe`, "cmd.exe"},
+		{"混合分隔符", `a/b\..\c.txt`, "c.txt"},
+		{"绝对路径", "/etc/shadow", "shadow"},
+		{"Windows盘符", `C:\Users\x\secret.txt`, "secret.txt"},
+		{"隐藏文件", ".bashrc", "bashrc"},
+		{"尾部点号", "name.txt. . ", "name.txt"},
+		{"保留字符", `a<b>c:d"e|f?g*h.txt`, "a_b_c_d_e_f_g_h.txt"},
+		{"控制字符", "a\x00b\nc\r.txt", "abc.txt"},
+		{"穿越后跟空格", "../ evil", "evil"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := sanitizeFilename(c.in)
+			if err != nil {
+				t.Fatalf("sanitizeFilename(%q) 返回错误: %v", c.in, err)
+			}
+			if got != c.want {
+				t.Fatalf("sanitizeFilename(%q) = %q, 期望 %q", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilenameRejects(t *testing.T) {
+	for _, in := range []string{"", ".", "..", "../", `..\`, "a/..", "/", "   ", "\x00", "dir/", "\xff\xfe"} {
+		if got, err := sanitizeFilename(in); err == nil {
+			t.Errorf("sanitizeFilename(%q) = %q, 期望返回错误", in, got)
+		}
+	}
+}
+
+func TestSanitizeFilenameTruncates(t *testing.T) {
+	long := strings.Repeat("文", 150) + ".txt"
+	got, err := sanitizeFilename(long)
+	if err != nil {
+		t.Fatalf("返回错误: %v", err)
+	}
+	if len(got) > maxFilenameBytes {
+		t.Fatalf("截断后长度 %d 超过上限 %d", len(got), maxFilenameBytes)
+	}
+	if !strings.HasSuffix(got, ".txt") {
+		t.Fatalf("截断后丢失扩展名: %q", got)
+	}
+	if !strings.HasPrefix(got, "文") || strings.ContainsRune(got, '\uFFFD') {
+		t.Fatalf("截断破坏了UTF-8字符: %q", got)
+	}
+}
+
+func TestStoragePath(t *testing.T) {
+	m := &Manager{storageDir: t.TempDir()}
+
+	got, err := m.storagePath("1_2_123_report.pdf")
+	if err != nil {
+		t.Fatalf("合法索引返回错误: %v", err)
+	}
+	if filepath.Dir(got) != m.storageDir {
+		t.Fatalf("路径 %q 不在存储目录 %q 下", got, m.storageDir)
+	}
+
+	for _, key := range []string{"", ".", "..", "../x", `..\x`, "a/b", "1_2_3_../../etc/passwd"} {
+		if got, err := m.storagePath(key); err == nil {
+			t.Errorf("storagePath(%q) = %q, 期望返回错误", key, got)
+		}
+	}
+}
+
+func TestFileKeyStaysInStorage(t *testing.T) {
+	m := &Manager{storageDir: t.TempDir()}
+
+	for _, raw := range []string{"../../../../tmp/pwn", `..\..\pwn.exe`, "/abs/olute", "ok.txt"} {
+		name, err := sanitizeFilename(raw)
+		if err != nil {
+			t.Fatalf("sanitizeFilename(%q) 返回错误: %v", raw, err)
+		}
+		path, err := m.storagePath("1_2_1_" + name)
+		if err != nil {
+			t.Fatalf("storagePath 拒绝了规范化后的文件名 %q: %v", name, err)
+		}
+		if filepath.Dir(path) != m.storageDir {
+			t.Fatalf("文件 %q 逃逸出存储目录: %q", raw, path)
+		}
+	}
+}
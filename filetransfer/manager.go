@@ -0,0 +1,721 @@
+// Package filetransfer 实现聊天服务器的文件传输。
+//
+// 发送方先发送文件头(type=3)，再按传输ID分块发送文件数据(type=2)；
+// 服务器把文件完整保存到存储目录并登记到数据库后向接收者发出邀约(file_offer)，
+// 接收者确认(file_accept)后通过聊天连接下发，或凭签名链接从HTTPS服务器下载。
+// 接收者每次登录都会重新收到尚未处理的邀约，超过保存期限仍未收取的文件会被删除(file_expired)。
+// 传输过程中双方都会收到进度(file_progress)，任一方都可以取消(file_cancel)。
+package filetransfer
+
+import (
+	"connection_server_linux/databasetool"
+	"connection_server_linux/events"
+	"connection_server_linux/frame"
+	"connection_server_linux/metrics"
+	"connection_server_linux/user"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxTransferIDLen 传输ID的最大长度
+const maxTransferIDLen = 64
+
+// 文件传输的字节数指标，发送按下发方式(tcp/https)分组
+var (
+	bytesReceived = metrics.NewCounter("chat_file_bytes_received_total", "从发送方接收并保存的文件字节数")
+	bytesSent     = metrics.NewCounterVec("chat_file_bytes_sent_total", "下发给接收方的文件字节数", "via")
+)
+
+func init() {
+	bytesSent.WithLabelValues("tcp")
+	bytesSent.WithLabelValues("https")
+}
+
+// logger 文件传输使用的日志记录器，每次调用时取全局默认记录器以跟随启动时的日志配置
+func logger() *slog.Logger {
+	return slog.Default().With("component", "file")
+}
+
+// Header 文件头信息
+type Header struct {
+	Type       string   `json:"type"`       // 固定为"file_transfer"
+	TransferID string   `json:"transferid"` // 传输ID，由发送方生成，同一连接内唯一
+	Filename   string   `json:"filename"`   // 文件名
+	Size       FileSize `json:"size"`       // 文件大小(兼容字符串/数字)
+	SendID     string   `json:"sendid"`     // 发送者ID，服务器以登录身份为准
+	ReceiveID  string   `json:"receiveid"`  // 接收者ID
+	SHA256     string   `json:"sha256"`     // 可选，发送方声明的文件SHA-256(十六进制)
+}
+
+// FileSize 兼容字符串和数字的文件大小字段
+type FileSize string
+
+// UnmarshalJSON 兼容客户端发送的数字或字符串大小
+func (s *FileSize) UnmarshalJSON(data []byte) error {
+	if len(data) == 0 {
+		*s = ""
+		return nil
+	}
+
+	if data[0] == '"' {
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		*s = FileSize(value)
+		return nil
+	}
+
+	var value json.Number
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*s = FileSize(value.String())
+	return nil
+}
+
+// String 返回字符串形式的文件大小
+func (s FileSize) String() string {
+	return string(s)
+}
+
+// PendingFile 已保存在服务器、等待接收者收取的文件
+type PendingFile struct {
+	FileKey          string // 存储目录中的文件名
+	TransferID       string // 面向接收者的传输ID
+	SenderTransferID string // 发送方原始的传输ID
+	Filename         string
+	FilePath         string
+	FileSize         int64
+	Hash             string // 文件SHA-256(十六进制)
+	SendTime         time.Time
+	SenderID         string
+	ReceiverID       string
+}
+
+// upload 正在接收中的上传
+// mu 保护文件写入和接收进度，不与m.mu同时持有，除非先持有mu再短暂获取m.mu
+type upload struct {
+	mu           sync.Mutex
+	closed       bool   // 文件已关闭：接收完成或已中止
+	transferID   string // 发送方给出的传输ID
+	relayID      string // 面向接收者的传输ID
+	fileKey      string
+	filePath     string
+	file         *os.File
+	hasher       hash.Hash // 边接收边计算SHA-256
+	expectedHash string    // 发送方声明的SHA-256，为空则不校验
+	fileSize     int64
+	received     int64
+	senderID     string
+	receiverID   string
+	filename     string
+	progress     progress
+	conn         net.Conn // 发起上传的连接，只在该连接断开时中止
+}
+
+// delivery 正在通过聊天连接下发给接收者的文件
+type delivery struct {
+	conn   net.Conn // 下发使用的接收者连接
+	cancel chan struct{}
+	once   sync.Once
+}
+
+func (d *delivery) stop() {
+	d.once.Do(func() { close(d.cancel) })
+}
+
+// ConnLookup 按用户ID查找在线客户端的连接
+type ConnLookup func(userID string) (net.Conn, bool)
+
+// Manager 管理上传会话、待接收文件和下发任务
+// m.mu 只保护以下几个索引，磁盘、网络和数据库操作都在释放m.mu之后进行
+type Manager struct {
+	db         *gorm.DB
+	storageDir string
+	httpsPort  int
+	ttl        time.Duration // 待接收文件的保存期限，为0时永久保存
+	lookup     ConnLookup
+	events     *events.Hub // 发布文件传输事件
+	secret     []byte      // 下载链接签名密钥，每次启动随机生成
+
+	mu         sync.Mutex
+	uploads    map[string]*upload      // 键为 发送者ID/传输ID
+	pending    map[string]*PendingFile // 键为文件索引，与数据库中的 PendingFile 表一致
+	deliveries map[string]*delivery    // 键为面向接收者的传输ID
+	draining   bool                    // 服务器关闭中，不再接受新的上传
+}
+
+// NewManager 创建文件传输管理器，并从数据库恢复上次运行时尚未被收取的文件
+// storageDir 不存在时会被创建；httpsPort 用于拼接下载链接；ttl 为待接收文件的保存期限，为0时永久保存；
+// lookup 用于查找在线客户端；hub 为发布文件传输事件的事件中心，为nil时使用全局事件中心
+func NewManager(db *gorm.DB, storageDir string, httpsPort int, ttl time.Duration, lookup ConnLookup, hub *events.Hub) (*Manager, error) {
+	if hub == nil {
+		hub = events.Default
+	}
+	absDir, err := filepath.Abs(storageDir)
+	if err != nil {
+		return nil, fmt.Errorf("解析文件存储目录失败 %s: %v", storageDir, err)
+	}
+	if err := os.MkdirAll(absDir, 0755); err != nil {
+		return nil, fmt.Errorf("创建文件存储目录失败 %s: %v", absDir, err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("生成下载链接密钥失败: %v", err)
+	}
+
+	m := &Manager{
+		db:         db,
+		storageDir: absDir,
+		httpsPort:  httpsPort,
+		ttl:        ttl,
+		lookup:     lookup,
+		events:     hub,
+		secret:     secret,
+		uploads:    make(map[string]*upload),
+		pending:    make(map[string]*PendingFile),
+		deliveries: make(map[string]*delivery),
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// load 从数据库恢复待接收文件，磁盘上已不存在的文件删除其记录
+func (m *Manager) load() error {
+	records, err := databasetool.ListPendingFiles(m.db)
+	if err != nil {
+		return fmt.Errorf("查询待接收文件失败: %v", err)
+	}
+	for _, record := range records {
+		filePath, err := m.storagePath(record.FileKey)
+		if err == nil {
+			_, err = os.Stat(filePath)
+		}
+		if err != nil {
+			logger().Warn("待接收文件已不存在，删除记录", "file", record.FileKey, "err", err)
+			if err := databasetool.DeletePendingFile(m.db, record.FileKey); err != nil {
+				logger().Error("删除待接收文件记录失败", "file", record.FileKey, "err", err)
+			}
+			continue
+		}
+		m.pending[record.FileKey] = &PendingFile{
+			FileKey:          record.FileKey,
+			TransferID:       record.TransferID,
+			SenderTransferID: record.SenderTransferID,
+			Filename:         record.Filename,
+			FilePath:         filePath,
+			FileSize:         record.FileSize,
+			Hash:             record.Hash,
+			SendTime:         record.SendTime,
+			SenderID:         record.SenderID,
+			ReceiverID:       record.ReceiverID,
+		}
+	}
+	if len(m.pending) > 0 {
+		logger().Info("已恢复待接收文件", "count", len(m.pending))
+	}
+	return nil
+}
+
+// record 转换为数据库记录
+func (file *PendingFile) record() *databasetool.PendingFile {
+	return &databasetool.PendingFile{
+		FileKey:          file.FileKey,
+		TransferID:       file.TransferID,
+		SenderTransferID: file.SenderTransferID,
+		Filename:         file.Filename,
+		FileSize:         file.FileSize,
+		Hash:             file.Hash,
+		SendTime:         file.SendTime,
+		SenderID:         file.SenderID,
+		ReceiverID:       file.ReceiverID,
+	}
+}
+
+// CheckStorage 检查存储目录是否可写：创建并删除一个临时文件
+func (m *Manager) CheckStorage() error {
+	f, err := os.CreateTemp(m.storageDir, ".healthcheck-*")
+	if err != nil {
+		return fmt.Errorf("存储目录不可写: %v", err)
+	}
+	name := f.Name()
+	f.Close()
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("删除检查文件失败: %v", err)
+	}
+	return nil
+}
+
+// EncodeChunk 组装文件数据包(type=2)的消息体：2字节传输ID长度 + 传输ID + 文件数据
+func EncodeChunk(transferID string, data []byte) []byte {
+	payload := make([]byte, 2+len(transferID)+len(data))
+	binary.BigEndian.PutUint16(payload[:2], uint16(len(transferID)))
+	copy(payload[2:], transferID)
+	copy(payload[2+len(transferID):], data)
+	return payload
+}
+
+// DecodeChunk 解析文件数据包(type=2)的消息体，返回传输ID和文件数据
+func DecodeChunk(payload []byte) (string, []byte, error) {
+	if len(payload) < 2 {
+		return "", nil, errors.New("文件数据包过短")
+	}
+	idLen := int(binary.BigEndian.Uint16(payload[:2]))
+	if idLen == 0 || idLen > maxTransferIDLen {
+		return "", nil, fmt.Errorf("无效的传输ID长度: %d", idLen)
+	}
+	if len(payload) < 2+idLen {
+		return "", nil, errors.New("文件数据包不完整")
+	}
+	return string(payload[2 : 2+idLen]), payload[2+idLen:], nil
+}
+
+// uploadKey 生成上传会话的索引键，同一客户端可同时存在多个传输
+func uploadKey(senderID, transferID string) string {
+	return senderID + "/" + transferID
+}
+
+// notify 向在线用户发送JSON消息，用户不在线时忽略
+func (m *Manager) notify(userID string, msg map[string]interface{}) {
+	conn, online := m.lookup(userID)
+	if !online {
+		return
+	}
+	if err := frame.WriteJSON(conn, msg); err != nil {
+		logger().Warn("发送文件消息失败", "type", msg["type"], "user", userID, "err", err)
+	}
+}
+
+// sendError 通知客户端文件传输失败
+func sendError(conn net.Conn, transferID string, message string) {
+	_ = frame.WriteJSON(conn, map[string]interface{}{
+		"type":       "file_error",
+		"transferid": transferID,
+		"message":    message,
+	})
+}
+
+// sendStatus 向文件发送方推送文件状态通知(file_uploaded/file_accepted/file_declined)
+func (m *Manager) sendStatus(msgType string, file *PendingFile) {
+	m.notify(file.SenderID, map[string]interface{}{
+		"type":       msgType,
+		"transferid": file.SenderTransferID,
+		"filename":   file.Filename,
+		"receiveid":  file.ReceiverID,
+	})
+}
+
+// 文件传输事件的阶段，发布到管理后台的事件流
+const (
+	stageUploadStarted = "upload_started"
+	stageUploadFailed  = "upload_failed"
+	stageUploaded      = "uploaded"
+	stageDelivered     = "delivered"
+	stageDeclined      = "declined"
+	stageCancelled     = "cancelled"
+	stageExpired       = "expired"
+)
+
+// publishEvent 发布文件传输事件，transferID 统一使用发送方的传输ID
+func (m *Manager) publishEvent(stage, transferID, senderID, receiverID, filename string, size int64) {
+	m.events.Publish(events.FileTransfer, map[string]interface{}{
+		"stage":      stage,
+		"transferid": transferID,
+		"sendid":     senderID,
+		"receiveid":  receiverID,
+		"filename":   filename,
+		"size":       size,
+	})
+}
+
+// publishFileEvent 发布已上传完成文件的传输事件
+func (m *Manager) publishFileEvent(stage string, file *PendingFile) {
+	m.publishEvent(stage, file.SenderTransferID, file.SenderID, file.ReceiverID, file.Filename, file.FileSize)
+}
+
+// HandleHeader 处理发送方的文件头包(type=3)，创建上传会话
+func (m *Manager) HandleHeader(senderID string, conn net.Conn, data []byte) error {
+	var header Header
+	if err := json.Unmarshal(data, &header); err != nil {
+		return fmt.Errorf("解析文件头失败: %v", err)
+	}
+
+	if header.TransferID == "" || len(header.TransferID) > maxTransferIDLen {
+		sendError(conn, header.TransferID, "无效的传输ID")
+		return fmt.Errorf("无效的传输ID: %q", header.TransferID)
+	}
+
+	fileSize, err := strconv.ParseInt(string(header.Size), 10, 64)
+	if err != nil || fileSize < 0 {
+		sendError(conn, header.TransferID, "无效的文件大小")
+		return fmt.Errorf("无效的文件大小: %s", header.Size)
+	}
+
+	if err := m.normalizeHeader(senderID, &header); err != nil {
+		sendError(conn, header.TransferID, "无效的文件名或接收者")
+		return err
+	}
+
+	key := uploadKey(senderID, header.TransferID)
+
+	// 先检查一次，避免为注定被拒绝的上传创建文件；登记时在锁内再检查
+	if err := m.checkNewUpload(key); err != nil {
+		sendError(conn, header.TransferID, err.Error())
+		return fmt.Errorf("拒绝上传 %s: %v", header.TransferID, err)
+	}
+
+	now := time.Now().UnixNano()
+	fileKey := fmt.Sprintf("%s_%s_%d_%s", header.SendID, header.ReceiveID, now, header.Filename)
+	filePath, err := m.storagePath(fileKey)
+	if err != nil {
+		sendError(conn, header.TransferID, "无效的文件名")
+		return err
+	}
+
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		sendError(conn, header.TransferID, "服务器无法保存文件")
+		return fmt.Errorf("无法创建文件: %v", err)
+	}
+
+	up := &upload{
+		conn:         conn,
+		transferID:   header.TransferID,
+		relayID:      fmt.Sprintf("%s-%d", header.SendID, now),
+		fileKey:      fileKey,
+		filePath:     filePath,
+		file:         file,
+		hasher:       sha256.New(),
+		expectedHash: strings.ToLower(header.SHA256),
+		fileSize:     fileSize,
+		senderID:     header.SendID,
+		receiverID:   header.ReceiveID,
+		filename:     header.Filename,
+		progress:     progress{size: fileSize},
+	}
+
+	m.mu.Lock()
+	err = m.checkNewUploadLocked(key)
+	if err == nil {
+		m.uploads[key] = up
+	}
+	m.mu.Unlock()
+	if err != nil {
+		file.Close()
+		os.Remove(filePath)
+		sendError(conn, header.TransferID, err.Error())
+		return fmt.Errorf("拒绝上传 %s: %v", header.TransferID, err)
+	}
+	m.publishEvent(stageUploadStarted, header.TransferID, header.SendID, header.ReceiveID, header.Filename, fileSize)
+
+	if fileSize == 0 && m.removeUpload(key, up) {
+		up.mu.Lock()
+		up.finishLocked()
+		up.mu.Unlock()
+		return m.completeUpload(up)
+	}
+	return nil
+}
+
+// checkNewUpload 检查是否可以创建新的上传会话
+func (m *Manager) checkNewUpload(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.checkNewUploadLocked(key)
+}
+
+// checkNewUploadLocked 服务器关闭中或传输ID已被占用时拒绝上传，调用方需持有m.mu
+func (m *Manager) checkNewUploadLocked(key string) error {
+	if m.draining {
+		return errors.New("服务器正在关闭，请稍后重试")
+	}
+	if _, ok := m.uploads[key]; ok {
+		return errors.New("传输ID已被占用")
+	}
+	return nil
+}
+
+// normalizeHeader 用服务器认定的身份覆盖发送者ID，并校验接收者和文件名
+func (m *Manager) normalizeHeader(senderID string, header *Header) error {
+	header.SendID = senderID
+
+	receiverID, err := user.ValidateID(header.ReceiveID)
+	if err != nil {
+		return err
+	}
+	num, _ := strconv.Atoi(receiverID)
+	if _, err := databasetool.FindUserById(m.db, num); err != nil {
+		return fmt.Errorf("接收者 %s 不存在: %v", receiverID, err)
+	}
+	header.ReceiveID = receiverID
+
+	filename, err := sanitizeFilename(header.Filename)
+	if err != nil {
+		return err
+	}
+	header.Filename = filename
+	return nil
+}
+
+// HandleChunk 处理发送方的文件数据包(type=2)
+// 文件先完整保存到服务器，接收完成后再向接收者发出邀约。
+// 写入磁盘只持有该上传自己的锁，不影响其他传输
+func (m *Manager) HandleChunk(senderID string, conn net.Conn, payload []byte) error {
+	transferID, data, err := DecodeChunk(payload)
+	if err != nil {
+		return err
+	}
+
+	key := uploadKey(senderID, transferID)
+
+	m.mu.Lock()
+	up, ok := m.uploads[key]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("未找到文件上传会话: %s", key)
+	}
+
+	up.mu.Lock()
+	if up.closed {
+		up.mu.Unlock()
+		return fmt.Errorf("上传 %s 已中止", key)
+	}
+
+	if up.received+int64(len(data)) > up.fileSize {
+		m.discardUploadLocked(key, up, stageUploadFailed)
+		up.mu.Unlock()
+		sendError(conn, transferID, "文件数据超出声明的大小")
+		return fmt.Errorf("文件 %s 数据超出声明的大小 %d", up.filename, up.fileSize)
+	}
+
+	if _, err := up.file.Write(data); err != nil {
+		m.discardUploadLocked(key, up, stageUploadFailed)
+		up.mu.Unlock()
+		sendError(conn, transferID, "服务器写入文件失败")
+		return fmt.Errorf("写入临时文件失败: %v", err)
+	}
+	up.hasher.Write(data)
+	up.received += int64(len(data))
+	bytesReceived.Add(uint64(len(data)))
+
+	var progressMsg map[string]interface{}
+	if up.progress.due(up.received) {
+		progressMsg = progressMessage(up.transferID, "upload", up.received, up.fileSize)
+	}
+	// 接收完成时由移除上传会话的一方负责收尾，与并发的取消或断开互斥
+	complete := up.received == up.fileSize && m.removeUpload(key, up)
+	if complete {
+		up.finishLocked()
+	}
+	up.mu.Unlock()
+
+	if progressMsg != nil {
+		_ = frame.WriteJSON(conn, progressMsg)
+	}
+	if !complete {
+		return nil
+	}
+	return m.completeUpload(up)
+}
+
+// removeUpload 从上传会话表中移除上传，返回是否由本次调用移除
+// 移除上传的一方负责结束它(完成或中止)，避免与并发的取消、断开重复处理
+func (m *Manager) removeUpload(key string, up *upload) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.uploads[key] != up {
+		return false
+	}
+	delete(m.uploads, key)
+	return true
+}
+
+// finishLocked 接收完成，关闭文件并保留内容，调用方需持有up.mu
+func (up *upload) finishLocked() {
+	up.closed = true
+	if up.file != nil {
+		if err := up.file.Close(); err != nil {
+			logger().Error("关闭临时文件失败", "path", up.filePath, "err", err)
+		}
+		up.file = nil
+	}
+}
+
+// abortLocked 中止上传，关闭并删除已写入的文件，调用方需持有up.mu
+func (up *upload) abortLocked() {
+	if up.closed {
+		return
+	}
+	up.finishLocked()
+	if err := os.Remove(up.filePath); err != nil {
+		logger().Error("删除临时文件失败", "path", up.filePath, "err", err)
+	}
+}
+
+// discardUploadLocked 丢弃上传会话并删除已写入的文件，调用方需持有up.mu(而不是m.mu)
+// stage 为发布到事件流的阶段(upload_failed 或 cancelled)；返回是否由本次调用丢弃
+func (m *Manager) discardUploadLocked(key string, up *upload, stage string) bool {
+	if !m.removeUpload(key, up) {
+		return false
+	}
+	up.abortLocked()
+	m.publishEvent(stage, up.transferID, up.senderID, up.receiverID, up.filename, up.fileSize)
+	return true
+}
+
+// abortUploads 中止从上传会话表中取出的上传，调用方不能持有m.mu
+func (m *Manager) abortUploads(uploads []*upload, stage string) {
+	for _, up := range uploads {
+		up.mu.Lock()
+		up.abortLocked()
+		up.mu.Unlock()
+		m.publishEvent(stage, up.transferID, up.senderID, up.receiverID, up.filename, up.fileSize)
+	}
+}
+
+// completeUpload 文件接收完成：校验哈希，登记到数据库并向接收者发出邀约
+// 上传会话已被移除、文件已关闭，调用方不能持有任何锁
+func (m *Manager) completeUpload(up *upload) error {
+	fileHash := hex.EncodeToString(up.hasher.Sum(nil))
+	if up.expectedHash != "" && up.expectedHash != fileHash {
+		m.failUpload(up, "文件校验失败")
+		return fmt.Errorf("文件 %s 校验失败，期望 %s 实际 %s", up.filename, up.expectedHash, fileHash)
+	}
+
+	file := &PendingFile{
+		FileKey:          up.fileKey,
+		TransferID:       up.relayID,
+		SenderTransferID: up.transferID,
+		Filename:         up.filename,
+		FilePath:         up.filePath,
+		FileSize:         up.fileSize,
+		Hash:             fileHash,
+		SendTime:         time.Now(),
+		SenderID:         up.senderID,
+		ReceiverID:       up.receiverID,
+	}
+	// 先登记到数据库再通知双方，服务器重启后文件仍可收取
+	if err := databasetool.CreatePendingFile(m.db, file.record()); err != nil {
+		m.failUpload(up, "服务器保存文件失败，请重新发送")
+		return fmt.Errorf("登记待接收文件失败: %v", err)
+	}
+
+	m.mu.Lock()
+	m.pending[file.FileKey] = file
+	m.mu.Unlock()
+
+	m.sendStatus("file_uploaded", file)
+	m.publishFileEvent(stageUploaded, file)
+
+	if conn, online := m.lookup(file.ReceiverID); online {
+		if err := m.sendOffer(conn, file); err != nil {
+			return fmt.Errorf("发送文件邀约失败 %s -> %s: %v", file.SenderID, file.ReceiverID, err)
+		}
+		logger().Info("文件已保存，等待接收者确认", "file", file.Filename, "receiver", file.ReceiverID)
+		return nil
+	}
+	logger().Info("文件已暂存，等待接收者上线", "file", file.Filename, "receiver", file.ReceiverID)
+	return nil
+}
+
+// failUpload 已接收完成的文件无法登记：删除文件并通知发送方
+func (m *Manager) failUpload(up *upload, message string) {
+	if err := os.Remove(up.filePath); err != nil {
+		logger().Error("删除临时文件失败", "path", up.filePath, "err", err)
+	}
+	m.publishEvent(stageUploadFailed, up.transferID, up.senderID, up.receiverID, up.filename, up.fileSize)
+	m.notify(up.senderID, map[string]interface{}{
+		"type":       "file_error",
+		"transferid": up.transferID,
+		"message":    message,
+	})
+}
+
+// Drain 服务器关闭时调用：不再接受新的上传，等待进行中的上传接收完成。
+// 接收完成的文件已登记到数据库，服务器重启后接收者仍可收取；
+// ctx 结束时仍未完成的上传会被中止、删除临时文件并通知发送方重新发送，返回被中止的数量
+func (m *Manager) Drain(ctx context.Context) int {
+	m.mu.Lock()
+	m.draining = true
+	m.mu.Unlock()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		m.mu.Lock()
+		remaining := len(m.uploads)
+		m.mu.Unlock()
+		if remaining == 0 {
+			return 0
+		}
+
+		select {
+		case <-ctx.Done():
+			m.mu.Lock()
+			aborted := make([]*upload, 0, len(m.uploads))
+			for key, up := range m.uploads {
+				delete(m.uploads, key)
+				aborted = append(aborted, up)
+			}
+			m.mu.Unlock()
+
+			m.abortUploads(aborted, stageUploadFailed)
+			for _, up := range aborted {
+				m.notify(up.senderID, map[string]interface{}{
+					"type":       "file_error",
+					"transferid": up.transferID,
+					"message":    "服务器正在关闭，上传已中止，请稍后重新发送",
+				})
+				logger().Warn("服务器关闭，中止未完成的上传", "user", up.senderID, "transfer", up.transferID, "received", up.received, "size", up.fileSize)
+			}
+			return len(aborted)
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReleaseClient 客户端断开时中止该连接上未完成的上传，并停止正在通过该连接下发的文件
+// 同一用户已重新登录时，新连接上的传输不受影响
+func (m *Manager) ReleaseClient(userID string, conn net.Conn) {
+	m.mu.Lock()
+	var aborted []*upload
+	for key, up := range m.uploads {
+		if up.conn == conn {
+			delete(m.uploads, key)
+			aborted = append(aborted, up)
+		}
+	}
+
+	for _, d := range m.deliveries {
+		if d.conn == conn {
+			d.stop()
+		}
+	}
+	m.mu.Unlock()
+
+	m.abortUploads(aborted, stageUploadFailed)
+	for _, up := range aborted {
+		logger().Info("客户端断开，中止未完成的文件传输", "user", userID, "transfer", up.transferID)
+	}
+}
@@ -0,0 +1,785 @@
+package filetransfer
+
+import (
+	"bytes"
+	"connection_server_linux/databasetool"
+	"connection_server_linux/events"
+	"connection_server_linux/frame"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// packet 测试客户端收到的一个数据包
+type packet struct {
+	typ     uint32
+	payload []byte
+	msg     map[string]interface{} // 仅JSON包和文件头包
+}
+
+// testClient 通过net.Pipe模拟一个已登录的客户端
+type testClient struct {
+	t       *testing.T
+	id      string
+	server  net.Conn // 服务器持有的一端
+	conn    net.Conn // 客户端持有的一端
+	packets chan packet
+}
+
+// harness 文件传输测试环境：临时数据库、临时存储目录和可上下线的客户端
+type harness struct {
+	t       *testing.T
+	m       *Manager
+	db      *gorm.DB
+	dir     string
+	mu      sync.Mutex
+	clients map[string]*testClient
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	db, err := databasetool.OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("打开测试数据库失败: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if _, err := databasetool.RegisterUser(db, name, "pwd", "127.0.0.1"); err != nil {
+			t.Fatalf("注册测试用户失败: %v", err)
+		}
+	}
+
+	h := &harness{t: t, db: db, dir: t.TempDir(), clients: make(map[string]*testClient)}
+	h.restart()
+	return h
+}
+
+// restart 在同一个数据库和存储目录上重新创建管理器，模拟服务器重启
+func (h *harness) restart() {
+	h.t.Helper()
+	m, err := NewManager(h.db, h.dir, 8443, 24*time.Hour, h.lookup, events.NewHub())
+	if err != nil {
+		h.t.Fatalf("创建文件传输管理器失败: %v", err)
+	}
+	h.m = m
+}
+
+func (h *harness) lookup(userID string) (net.Conn, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.clients[userID]
+	if !ok {
+		return nil, false
+	}
+	return c.server, true
+}
+
+// connect 让用户上线
+func (h *harness) connect(id string) *testClient {
+	server, conn := net.Pipe()
+	c := &testClient{t: h.t, id: id, server: server, conn: conn, packets: make(chan packet, 256)}
+	go func() {
+		defer close(c.packets)
+		for {
+			typ, payload, err := frame.Read(conn)
+			if err != nil {
+				return
+			}
+			p := packet{typ: typ, payload: payload}
+			if typ != frame.TypeFileChunk {
+				_ = json.Unmarshal(payload, &p.msg)
+			}
+			c.packets <- p
+		}
+	}()
+
+	h.mu.Lock()
+	h.clients[id] = c
+	h.mu.Unlock()
+	h.t.Cleanup(func() { h.disconnect(c) })
+	return c
+}
+
+// disconnect 让用户下线
+func (h *harness) disconnect(c *testClient) {
+	h.mu.Lock()
+	if h.clients[c.id] == c {
+		delete(h.clients, c.id)
+	}
+	h.mu.Unlock()
+	h.m.ReleaseClient(c.id, c.server)
+	c.server.Close()
+	c.conn.Close()
+}
+
+// header 发送文件头
+func (c *testClient) header(h *harness, transferID, receiverID, name string, content []byte) error {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":       "file_transfer",
+		"transferid": transferID,
+		"filename":   name,
+		"size":       len(content),
+		"receiveid":  receiverID,
+	})
+	return h.m.HandleHeader(c.id, c.server, data)
+}
+
+// chunk 发送一段文件数据
+func (c *testClient) chunk(h *harness, transferID string, data []byte) error {
+	return h.m.HandleChunk(c.id, c.server, EncodeChunk(transferID, data))
+}
+
+// send 发送文件相关的JSON消息
+func (c *testClient) send(h *harness, msgType, transferID string) error {
+	data, _ := json.Marshal(map[string]string{"type": msgType, "transferid": transferID})
+	return h.m.HandleMessage(c.id, c.server, msgType, data)
+}
+
+// expect 等待指定类型的消息，跳过进度通知等其他消息
+func (c *testClient) expect(msgType string) map[string]interface{} {
+	c.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case p, ok := <-c.packets:
+			if !ok {
+				c.t.Fatalf("用户 %s 等待 %s 时连接已关闭", c.id, msgType)
+			}
+			if p.msg != nil && p.msg["type"] == msgType {
+				return p.msg
+			}
+		case <-timeout:
+			c.t.Fatalf("用户 %s 等待 %s 超时", c.id, msgType)
+		}
+	}
+}
+
+// receiveFile 等待文件通知并收齐文件数据，同时收集下发进度
+func (c *testClient) receiveFile(transferID string) ([]byte, []map[string]interface{}) {
+	c.t.Helper()
+	notify := c.expect("file_notify")
+	if notify["transferid"] != transferID {
+		c.t.Fatalf("文件通知的传输ID = %v, 期望 %s", notify["transferid"], transferID)
+	}
+
+	var size int
+	if err := json.Unmarshal([]byte(notify["size"].(string)), &size); err != nil {
+		c.t.Fatalf("文件大小格式错误: %v", notify["size"])
+	}
+
+	var buf bytes.Buffer
+	var events []map[string]interface{}
+	timeout := time.After(5 * time.Second)
+	for buf.Len() < size || len(events) == 0 || events[len(events)-1]["received"].(float64) < float64(size) {
+		select {
+		case p := <-c.packets:
+			switch {
+			case p.typ == frame.TypeFileChunk:
+				id, data, err := DecodeChunk(p.payload)
+				if err != nil || id != transferID {
+					c.t.Fatalf("无效的文件数据包: id=%q err=%v", id, err)
+				}
+				buf.Write(data)
+			case p.msg["type"] == "file_progress":
+				events = append(events, p.msg)
+			}
+		case <-timeout:
+			c.t.Fatalf("接收文件超时，已收到 %d/%d 字节", buf.Len(), size)
+		}
+	}
+	return buf.Bytes(), events
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func storedFiles(t *testing.T, m *Manager) []string {
+	t.Helper()
+	entries, err := os.ReadDir(m.storageDir)
+	if err != nil {
+		t.Fatalf("读取存储目录失败: %v", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestOnlineReceiverConcurrentUploadsAndAccept(t *testing.T) {
+	h := newHarness(t)
+	alice := h.connect("1")
+	bob := h.connect("2")
+
+	fileA := bytes.Repeat([]byte("A"), 300*1024)
+	fileB := []byte("hello bob")
+
+	if err := alice.header(h, "a", "2", "a.bin", fileA); err != nil {
+		t.Fatalf("发送文件头a失败: %v", err)
+	}
+	if err := alice.header(h, "b", "2", "../../b.txt", fileB); err != nil {
+		t.Fatalf("发送文件头b失败: %v", err)
+	}
+	if err := alice.header(h, "a", "2", "dup.bin", fileB); err == nil {
+		t.Fatal("重复的传输ID应当被拒绝")
+	}
+	alice.expect("file_error")
+
+	// 两个传输的数据包交错发送
+	for off := 0; off < len(fileA); off += 100 * 1024 {
+		if err := alice.chunk(h, "a", fileA[off:off+100*1024]); err != nil {
+			t.Fatalf("发送文件a数据失败: %v", err)
+		}
+		if off == 0 {
+			if err := alice.chunk(h, "b", fileB); err != nil {
+				t.Fatalf("发送文件b数据失败: %v", err)
+			}
+		}
+	}
+
+	offers := map[string]map[string]interface{}{}
+	for i := 0; i < 2; i++ {
+		offer := bob.expect("file_offer")
+		offers[offer["filename"].(string)] = offer
+	}
+	offerA, offerB := offers["a.bin"], offers["b.txt"]
+	if offerA == nil || offerB == nil {
+		t.Fatalf("邀约文件名不符: %v", offers)
+	}
+	if offerA["sha256"] != sha256Hex(fileA) || offerA["sendid"] != "1" {
+		t.Fatalf("邀约内容错误: %v", offerA)
+	}
+	if !strings.HasPrefix(offerA["url"].(string), "https://") {
+		t.Fatalf("邀约缺少下载链接: %v", offerA)
+	}
+	if offerA["transferid"] == offerB["transferid"] {
+		t.Fatal("不同传输的接收方传输ID不应相同")
+	}
+
+	if err := bob.send(h, "file_accept", offerA["transferid"].(string)); err != nil {
+		t.Fatalf("确认接收失败: %v", err)
+	}
+	got, events := bob.receiveFile(offerA["transferid"].(string))
+	if !bytes.Equal(got, fileA) {
+		t.Fatalf("收到的文件内容不一致，长度 %d", len(got))
+	}
+	if last := events[len(events)-1]; last["stage"] != "download" {
+		t.Fatalf("接收方进度阶段错误: %v", last)
+	}
+
+	accepted := alice.expect("file_accepted")
+	if accepted["transferid"] != "a" {
+		t.Fatalf("发送方收到的确认传输ID错误: %v", accepted)
+	}
+
+	// 文件a已下发并删除，文件b仍等待确认
+	if names := storedFiles(t, h.m); len(names) != 1 || !strings.HasSuffix(names[0], "_b.txt") {
+		t.Fatalf("存储目录内容不符: %v", names)
+	}
+}
+
+func TestUploadProgressReachesSender(t *testing.T) {
+	h := newHarness(t)
+	alice := h.connect("1")
+	h.connect("2")
+
+	content := bytes.Repeat([]byte("x"), 256*1024)
+	if err := alice.header(h, "p", "2", "p.bin", content); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+	for off := 0; off < len(content); off += 64 * 1024 {
+		if err := alice.chunk(h, "p", content[off:off+64*1024]); err != nil {
+			t.Fatalf("发送数据失败: %v", err)
+		}
+	}
+
+	var last map[string]interface{}
+	for last == nil || last["received"].(float64) < float64(len(content)) {
+		last = alice.expect("file_progress")
+		if last["stage"] != "upload" || last["transferid"] != "p" {
+			t.Fatalf("上传进度内容错误: %v", last)
+		}
+	}
+	alice.expect("file_uploaded")
+}
+
+func TestOfflineReceiverGetsOfferOnLogin(t *testing.T) {
+	h := newHarness(t)
+	alice := h.connect("1")
+
+	content := []byte("offline payload")
+	if err := alice.header(h, "o", "2", "offline.txt", content); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+	if err := alice.chunk(h, "o", content); err != nil {
+		t.Fatalf("发送数据失败: %v", err)
+	}
+	alice.expect("file_uploaded")
+
+	records, err := databasetool.ListPendingFiles(h.db)
+	if err != nil || len(records) != 1 || records[0].ReceiverID != "2" {
+		t.Fatalf("待接收文件未登记到数据库: %v %v", records, err)
+	}
+
+	// 未处理的邀约每次登录都会重新发出
+	var offer map[string]interface{}
+	for i := 0; i < 2; i++ {
+		bob := h.connect("2")
+		if n, err := h.m.OfferPending("2", bob.server); err != nil || n != 1 {
+			t.Fatalf("补发邀约失败: n=%d err=%v", n, err)
+		}
+		offer = bob.expect("file_offer")
+		if offer["filename"] != "offline.txt" {
+			t.Fatalf("邀约内容错误: %v", offer)
+		}
+		h.disconnect(bob)
+	}
+	bob := h.connect("2")
+
+	// 其他用户不能替接收者拒收
+	if err := alice.send(h, "file_decline", offer["transferid"].(string)); err == nil {
+		t.Fatal("非接收者拒收应当失败")
+	}
+
+	if err := bob.send(h, "file_decline", offer["transferid"].(string)); err != nil {
+		t.Fatalf("拒收失败: %v", err)
+	}
+	if declined := alice.expect("file_declined"); declined["transferid"] != "o" {
+		t.Fatalf("拒收通知错误: %v", declined)
+	}
+	if names := storedFiles(t, h.m); len(names) != 0 {
+		t.Fatalf("拒收后文件未删除: %v", names)
+	}
+	if records, _ := databasetool.ListPendingFiles(h.db); len(records) != 0 {
+		t.Fatalf("拒收后记录未删除: %v", records)
+	}
+	if n, _ := h.m.OfferPending("2", bob.server); n != 0 {
+		t.Fatalf("已拒收的文件不应再发出邀约: %d", n)
+	}
+}
+
+func TestPendingFileSurvivesRestart(t *testing.T) {
+	h := newHarness(t)
+	alice := h.connect("1")
+
+	content := []byte("kept across restart")
+	if err := alice.header(h, "r", "2", "restart.txt", content); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+	if err := alice.chunk(h, "r", content); err != nil {
+		t.Fatalf("发送数据失败: %v", err)
+	}
+	alice.expect("file_uploaded")
+
+	// 另一个已登记的文件在重启前被删除，重启时应清除其记录
+	if err := databasetool.CreatePendingFile(h.db, &databasetool.PendingFile{
+		FileKey: "1_2_0_missing.txt", TransferID: "1-0", Filename: "missing.txt", SendTime: time.Now(), SenderID: "1", ReceiverID: "2",
+	}); err != nil {
+		t.Fatalf("登记测试记录失败: %v", err)
+	}
+
+	h.restart()
+	if records, _ := databasetool.ListPendingFiles(h.db); len(records) != 1 {
+		t.Fatalf("重启后应只保留存在的文件: %v", records)
+	}
+
+	bob := h.connect("2")
+	if n, err := h.m.OfferPending("2", bob.server); err != nil || n != 1 {
+		t.Fatalf("重启后补发邀约失败: n=%d err=%v", n, err)
+	}
+	offer := bob.expect("file_offer")
+	if err := bob.send(h, "file_accept", offer["transferid"].(string)); err != nil {
+		t.Fatalf("确认接收失败: %v", err)
+	}
+	if got, _ := bob.receiveFile(offer["transferid"].(string)); !bytes.Equal(got, content) {
+		t.Fatalf("重启后收到的文件内容不一致: %q", got)
+	}
+	if accepted := alice.expect("file_accepted"); accepted["transferid"] != "r" {
+		t.Fatalf("发送方收到的确认传输ID错误: %v", accepted)
+	}
+}
+
+func TestCleanupExpiresFilesAndOrphans(t *testing.T) {
+	h := newHarness(t)
+	alice := h.connect("1")
+	bob := h.connect("2")
+
+	content := []byte("expires soon")
+	if err := alice.header(h, "e", "2", "expire.txt", content); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+	if err := alice.chunk(h, "e", content); err != nil {
+		t.Fatalf("发送数据失败: %v", err)
+	}
+	offer := bob.expect("file_offer")
+
+	// 一个旧的遗留文件、一个新的遗留文件和一个健康检查临时文件
+	old := filepath.Join(h.dir, "1_2_1_old.txt")
+	fresh := filepath.Join(h.dir, "1_2_2_fresh.txt")
+	hidden := filepath.Join(h.dir, ".healthcheck-1")
+	for _, path := range []string{old, fresh, hidden} {
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("创建测试文件失败: %v", err)
+		}
+	}
+	longAgo := time.Now().Add(-48 * time.Hour)
+	for _, path := range []string{old, hidden} {
+		if err := os.Chtimes(path, longAgo, longAgo); err != nil {
+			t.Fatalf("修改文件时间失败: %v", err)
+		}
+	}
+
+	if expired, orphaned := h.m.Cleanup(time.Now()); expired != 0 || orphaned != 1 {
+		t.Fatalf("未过期时应只删除旧的遗留文件: expired=%d orphaned=%d", expired, orphaned)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Fatalf("未超过保存期限的遗留文件不应删除: %v", err)
+	}
+
+	// 一天后待接收文件和新的遗留文件都已超过保存期限
+	if expired, orphaned := h.m.Cleanup(time.Now().Add(25 * time.Hour)); expired != 1 || orphaned != 1 {
+		t.Fatalf("超过保存期限的文件应被删除: expired=%d orphaned=%d", expired, orphaned)
+	}
+	if msg := alice.expect("file_expired"); msg["transferid"] != "e" {
+		t.Fatalf("发送方的过期通知错误: %v", msg)
+	}
+	if msg := bob.expect("file_expired"); msg["transferid"] != offer["transferid"] {
+		t.Fatalf("接收者的过期通知错误: %v", msg)
+	}
+
+	if names := storedFiles(t, h.m); len(names) != 1 || names[0] != ".healthcheck-1" {
+		t.Fatalf("清理后存储目录内容不符: %v", names)
+	}
+	if records, _ := databasetool.ListPendingFiles(h.db); len(records) != 0 {
+		t.Fatalf("过期文件的记录未删除: %v", records)
+	}
+}
+
+func TestSlowReceiverDoesNotBlockOtherUploads(t *testing.T) {
+	h := newHarness(t)
+	alice := h.connect("1")
+
+	// bob 的连接没有人读取，向他发送邀约会一直阻塞
+	server, conn := net.Pipe()
+	defer conn.Close()
+	h.mu.Lock()
+	h.clients["2"] = &testClient{t: t, id: "2", server: server, conn: conn}
+	h.mu.Unlock()
+
+	blocked := make(chan error, 1)
+	go func() {
+		if err := alice.header(h, "slow", "2", "slow.txt", []byte("x")); err != nil {
+			blocked <- err
+			return
+		}
+		blocked <- alice.chunk(h, "slow", []byte("x"))
+	}()
+	alice.expect("file_uploaded")
+
+	// 向bob的邀约阻塞期间，carol 发给 alice 的上传仍能完成
+	carol := h.connect("3")
+	content := []byte("not blocked")
+	if err := carol.header(h, "fast", "1", "fast.txt", content); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+	if err := carol.chunk(h, "fast", content); err != nil {
+		t.Fatalf("发送数据失败: %v", err)
+	}
+	carol.expect("file_uploaded")
+	if offer := alice.expect("file_offer"); offer["filename"] != "fast.txt" {
+		t.Fatalf("邀约内容错误: %v", offer)
+	}
+
+	// 关闭bob的连接，阻塞的邀约随之失败
+	server.Close()
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("关闭连接后阻塞的邀约仍未返回")
+	}
+}
+
+func TestCancelInFlightUpload(t *testing.T) {
+	h := newHarness(t)
+	alice := h.connect("1")
+	bob := h.connect("2")
+
+	content := bytes.Repeat([]byte("c"), 1024)
+	if err := alice.header(h, "c", "2", "c.bin", content); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+	if err := alice.chunk(h, "c", content[:512]); err != nil {
+		t.Fatalf("发送数据失败: %v", err)
+	}
+	if err := alice.send(h, "file_cancel", "c"); err != nil {
+		t.Fatalf("取消上传失败: %v", err)
+	}
+	if msg := alice.expect("file_cancelled"); msg["by"] != "sender" {
+		t.Fatalf("取消通知错误: %v", msg)
+	}
+	if err := alice.chunk(h, "c", content[512:]); err == nil {
+		t.Fatal("取消后的数据包应当被拒绝")
+	}
+	if names := storedFiles(t, h.m); len(names) != 0 {
+		t.Fatalf("取消后文件未删除: %v", names)
+	}
+
+	select {
+	case p := <-bob.packets:
+		t.Fatalf("接收者不应收到未完成的上传: %v", p.msg)
+	default:
+	}
+}
+
+func TestSenderWithdrawsPendingFile(t *testing.T) {
+	h := newHarness(t)
+	alice := h.connect("1")
+	bob := h.connect("2")
+
+	content := []byte("withdraw me")
+	if err := alice.header(h, "w", "2", "w.txt", content); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+	if err := alice.chunk(h, "w", content); err != nil {
+		t.Fatalf("发送数据失败: %v", err)
+	}
+	offer := bob.expect("file_offer")
+
+	if err := alice.send(h, "file_cancel", "w"); err != nil {
+		t.Fatalf("撤回失败: %v", err)
+	}
+	if msg := bob.expect("file_cancelled"); msg["transferid"] != offer["transferid"] || msg["by"] != "sender" {
+		t.Fatalf("接收者收到的撤回通知错误: %v", msg)
+	}
+	if err := bob.send(h, "file_accept", offer["transferid"].(string)); err == nil {
+		t.Fatal("撤回后确认接收应当失败")
+	}
+}
+
+func TestDisconnectAbortsUploads(t *testing.T) {
+	h := newHarness(t)
+	alice := h.connect("1")
+
+	if err := alice.header(h, "d", "2", "d.bin", make([]byte, 10)); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+	h.disconnect(alice)
+
+	if names := storedFiles(t, h.m); len(names) != 0 {
+		t.Fatalf("断开后未完成的上传未清理: %v", names)
+	}
+}
+
+// 旧连接在用户重新登录后才断开，只中止旧连接上的上传
+func TestReconnectKeepsNewUploads(t *testing.T) {
+	h := newHarness(t)
+	oldConn := h.connect("1")
+	bob := h.connect("2")
+
+	content := []byte("reconnect")
+	if err := oldConn.header(h, "old", "2", "old.txt", content); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+	newConn := h.connect("1")
+	if err := newConn.header(h, "new", "2", "new.txt", content); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+	if err := newConn.chunk(h, "new", content[:4]); err != nil {
+		t.Fatalf("发送数据失败: %v", err)
+	}
+
+	h.disconnect(oldConn)
+	if err := oldConn.chunk(h, "old", content); err == nil {
+		t.Fatal("旧连接断开后其上传应当被中止")
+	}
+	if err := newConn.chunk(h, "new", content[4:]); err != nil {
+		t.Fatalf("旧连接断开后新连接的上传被中止: %v", err)
+	}
+	if offer := bob.expect("file_offer"); offer["filename"] != "new.txt" {
+		t.Fatalf("接收者收到的邀约 = %v, 期望 new.txt", offer)
+	}
+}
+
+func TestHashMismatchRejected(t *testing.T) {
+	h := newHarness(t)
+	alice := h.connect("1")
+	bob := h.connect("2")
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"transferid": "h",
+		"filename":   "h.txt",
+		"size":       "4",
+		"receiveid":  "2",
+		"sha256":     sha256Hex([]byte("good")),
+	})
+	if err := h.m.HandleHeader("1", alice.server, data); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+	if err := alice.chunk(h, "h", []byte("evil")); err == nil {
+		t.Fatal("哈希不一致时应当返回错误")
+	}
+	alice.expect("file_error")
+	if names := storedFiles(t, h.m); len(names) != 0 {
+		t.Fatalf("校验失败的文件未删除: %v", names)
+	}
+	select {
+	case p := <-bob.packets:
+		t.Fatalf("校验失败的文件不应发出邀约: %v", p.msg)
+	default:
+	}
+}
+
+func TestHeaderUsesAuthenticatedSender(t *testing.T) {
+	h := newHarness(t)
+	alice := h.connect("1")
+	bob := h.connect("2")
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"transferid": "s",
+		"filename":   "spoof.txt",
+		"size":       2,
+		"sendid":     "2",
+		"receiveid":  "2",
+	})
+	if err := h.m.HandleHeader("1", alice.server, data); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+	if err := alice.chunk(h, "s", []byte("hi")); err != nil {
+		t.Fatalf("发送数据失败: %v", err)
+	}
+	if offer := bob.expect("file_offer"); offer["sendid"] != "1" {
+		t.Fatalf("发送者身份被伪造: %v", offer)
+	}
+
+	if err := alice.header(h, "x", "999", "x.txt", []byte("x")); err == nil {
+		t.Fatal("不存在的接收者应当被拒绝")
+	}
+}
+
+func TestSignedDownloadLink(t *testing.T) {
+	h := newHarness(t)
+	alice := h.connect("1")
+	bob := h.connect("2")
+
+	content := []byte("0123456789abcdef")
+	if err := alice.header(h, "l", "2", "链接.txt", content); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+	if err := alice.chunk(h, "l", content); err != nil {
+		t.Fatalf("发送数据失败: %v", err)
+	}
+	offer := bob.expect("file_offer")
+	link, err := url.Parse(offer["url"].(string))
+	if err != nil {
+		t.Fatalf("下载链接格式错误: %v", err)
+	}
+	transferID := strings.TrimPrefix(link.Path, "/files/")
+
+	get := func(rawQuery string, rangeHeader string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/files/"+transferID+"?"+rawQuery, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		h.m.ServeDownload(rec, req, transferID)
+		return rec.Result()
+	}
+
+	resp := get(link.RawQuery, "bytes=4-7")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "4567" {
+		t.Fatalf("Range下载结果错误: %d %q", resp.StatusCode, body)
+	}
+
+	tampered := link.Query()
+	tampered.Set("expires", "9999999999")
+	if resp := get(tampered.Encode(), ""); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("篡改过期时间的链接应被拒绝，实际 %d", resp.StatusCode)
+	}
+
+	if err := bob.send(h, "file_received", transferID); err != nil {
+		t.Fatalf("确认下载完成失败: %v", err)
+	}
+	alice.expect("file_accepted")
+	if resp := get(link.RawQuery, ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("已收取的文件不应再可下载，实际 %d", resp.StatusCode)
+	}
+}
+
+func TestDrainKeepsCompletedUploadsAndAbortsTheRest(t *testing.T) {
+	h := newHarness(t)
+	alice := h.connect("1")
+
+	done := bytes.Repeat([]byte("d"), 128*1024)
+	if err := alice.header(h, "done", "2", "done.bin", done); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+	if err := alice.chunk(h, "done", done[:64*1024]); err != nil {
+		t.Fatalf("发送数据失败: %v", err)
+	}
+	if err := alice.header(h, "partial", "2", "partial.bin", done); err != nil {
+		t.Fatalf("发送文件头失败: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	aborted := make(chan int, 1)
+	go func() { aborted <- h.m.Drain(ctx) }()
+
+	// 关闭期间不再接受新的上传，进行中的上传可以继续完成
+	time.Sleep(50 * time.Millisecond)
+	if err := alice.header(h, "late", "2", "late.bin", done); err == nil {
+		t.Fatal("关闭期间的新上传应当被拒绝")
+	}
+	if err := alice.chunk(h, "done", done[64*1024:]); err != nil {
+		t.Fatalf("发送剩余数据失败: %v", err)
+	}
+	alice.expect("file_uploaded")
+
+	if n := <-aborted; n != 1 {
+		t.Fatalf("应中止1个未完成的上传，实际 %d", n)
+	}
+	for {
+		msg := alice.expect("file_error")
+		if msg["transferid"] == "partial" {
+			if !strings.Contains(msg["message"].(string), "重新发送") {
+				t.Fatalf("中止通知应提示发送方重新发送: %v", msg)
+			}
+			break
+		}
+	}
+
+	// 重启后接收者仍能收到关闭前上传完成的文件
+	h.restart()
+	bob := h.connect("2")
+	if n, err := h.m.OfferPending("2", bob.server); err != nil || n != 1 {
+		t.Fatalf("重启后补发邀约失败: n=%d err=%v", n, err)
+	}
+	if offer := bob.expect("file_offer"); offer["filename"] != "done.bin" {
+		t.Fatalf("邀约内容错误: %v", offer)
+	}
+	if names := storedFiles(t, h.m); len(names) != 1 {
+		t.Fatalf("被中止的上传文件未删除: %v", names)
+	}
+}
@@ -0,0 +1,375 @@
+package filetransfer
+
+import (
+	"connection_server_linux/databasetool"
+	"connection_server_linux/frame"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// chunkSize 下发文件时每个数据包携带的最大字节数
+const chunkSize = 1024 * 1024
+
+// progress 控制进度通知的频率：每完成约5%(至少64KB)或传输结束时通知一次
+type progress struct {
+	size int64
+	last int64
+}
+
+func (p *progress) due(n int64) bool {
+	step := p.size / 20
+	if step < 64*1024 {
+		step = 64 * 1024
+	}
+	if n >= p.size || n-p.last >= step {
+		p.last = n
+		return true
+	}
+	return false
+}
+
+// progressMessage 组装进度通知
+// stage 为 upload(发送方上传到服务器) 或 download(接收者从服务器收取)
+func progressMessage(transferID, stage string, received, size int64) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "file_progress",
+		"transferid": transferID,
+		"stage":      stage,
+		"received":   received,
+		"size":       size,
+	}
+}
+
+// sendOffer 向接收者发送文件邀约，接收者确认后才会下发文件内容
+// 邀约中附带限时HTTPS下载链接，接收者也可以不走聊天连接直接下载
+func (m *Manager) sendOffer(conn net.Conn, file *PendingFile) error {
+	link, expires := m.downloadURL(conn, file)
+	return frame.WriteJSON(conn, map[string]interface{}{
+		"type":       "file_offer",
+		"transferid": file.TransferID,
+		"filename":   file.Filename,
+		"size":       strconv.FormatInt(file.FileSize, 10),
+		"sha256":     file.Hash,
+		"sendid":     file.SenderID,
+		"url":        link,
+		"expires":    expires,
+	})
+}
+
+// OfferPending 接收者登录时重新发出所有尚未处理的文件邀约，按上传完成的先后顺序
+// 文件在接收者确认、拒收或超过保存期限之前，每次登录都会收到邀约
+// 返回发出的邀约数量
+func (m *Manager) OfferPending(userID string, conn net.Conn) (int, error) {
+	m.mu.Lock()
+	var files []*PendingFile
+	for _, file := range m.pending {
+		if file.ReceiverID != userID {
+			continue
+		}
+		if _, delivering := m.deliveries[file.TransferID]; delivering {
+			continue
+		}
+		files = append(files, file)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(files, func(i, j int) bool { return files[i].SendTime.Before(files[j].SendTime) })
+	for i, file := range files {
+		if err := m.sendOffer(conn, file); err != nil {
+			return i, fmt.Errorf("发送文件邀约失败: %v", err)
+		}
+	}
+	return len(files), nil
+}
+
+// IsFileMessage 判断JSON消息类型是否由文件传输模块处理
+func IsFileMessage(msgType string) bool {
+	switch msgType {
+	case "file_accept", "file_decline", "file_received", "file_link", "file_cancel":
+		return true
+	}
+	return false
+}
+
+// HandleMessage 处理文件相关的JSON消息
+//   - file_accept   接收者确认邀约，服务器通过聊天连接下发文件
+//   - file_decline  接收者拒收，文件被删除
+//   - file_received 接收者已通过下载链接收取，文件被删除
+//   - file_link     接收者请求重新签发下载链接
+//   - file_cancel   发送方撤回传输，或接收者中止正在进行的下发
+func (m *Manager) HandleMessage(userID string, conn net.Conn, msgType string, data []byte) error {
+	var req struct {
+		TransferID string `json:"transferid"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return fmt.Errorf("解析文件消息失败: %v", err)
+	}
+
+	switch msgType {
+	case "file_accept":
+		return m.accept(userID, conn, req.TransferID)
+	case "file_decline", "file_received":
+		return m.finish(userID, conn, msgType, req.TransferID)
+	case "file_link":
+		return m.link(userID, conn, req.TransferID)
+	case "file_cancel":
+		return m.cancel(userID, conn, req.TransferID)
+	default:
+		return fmt.Errorf("未知文件消息类型: %s", msgType)
+	}
+}
+
+// findForReceiverLocked 查找接收者名下指定传输ID的待接收文件，调用方需持有m.mu
+func (m *Manager) findForReceiverLocked(receiverID, transferID string) (*PendingFile, bool) {
+	for _, file := range m.pending {
+		if file.ReceiverID == receiverID && file.TransferID == transferID {
+			return file, true
+		}
+	}
+	return nil, false
+}
+
+// findForSenderLocked 查找发送方已上传完成、尚未被收取的文件，调用方需持有m.mu
+func (m *Manager) findForSenderLocked(senderID, transferID string) (*PendingFile, bool) {
+	for _, file := range m.pending {
+		if file.SenderID == senderID && file.SenderTransferID == transferID {
+			return file, true
+		}
+	}
+	return nil, false
+}
+
+// unlistLocked 从待接收文件中移除并停止正在进行的下发，调用方需持有m.mu
+// 释放m.mu后需调用 dropFile 删除数据库记录和磁盘上的文件
+func (m *Manager) unlistLocked(file *PendingFile) {
+	delete(m.pending, file.FileKey)
+	if d, ok := m.deliveries[file.TransferID]; ok {
+		d.stop()
+		delete(m.deliveries, file.TransferID)
+	}
+}
+
+// dropFile 删除待接收文件的数据库记录和磁盘上的文件，调用方不能持有m.mu
+func (m *Manager) dropFile(file *PendingFile) {
+	if err := databasetool.DeletePendingFile(m.db, file.FileKey); err != nil {
+		logger().Error("删除待接收文件记录失败", "file", file.FileKey, "err", err)
+	}
+	if err := os.Remove(file.FilePath); err != nil && !os.IsNotExist(err) {
+		logger().Error("删除文件失败", "path", file.FilePath, "err", err)
+	}
+}
+
+// accept 接收者确认邀约，在后台协程中下发文件，期间接收者仍可收发消息
+func (m *Manager) accept(userID string, conn net.Conn, transferID string) error {
+	m.mu.Lock()
+	file, ok := m.findForReceiverLocked(userID, transferID)
+	if !ok {
+		m.mu.Unlock()
+		sendError(conn, transferID, "文件不存在或已处理")
+		return fmt.Errorf("未找到待接收文件: %s", transferID)
+	}
+	if _, busy := m.deliveries[transferID]; busy {
+		m.mu.Unlock()
+		sendError(conn, transferID, "文件正在下发")
+		return fmt.Errorf("文件 %s 正在下发", transferID)
+	}
+	d := &delivery{conn: conn, cancel: make(chan struct{})}
+	m.deliveries[transferID] = d
+	m.mu.Unlock()
+
+	go m.deliver(conn, file, d)
+	return nil
+}
+
+// deliver 将文件按数据包下发给接收者，完成后删除服务器上的文件并通知发送方
+func (m *Manager) deliver(conn net.Conn, file *PendingFile, d *delivery) {
+	err := m.stream(conn, file, d)
+
+	m.mu.Lock()
+	if m.deliveries[file.TransferID] == d {
+		delete(m.deliveries, file.TransferID)
+	}
+	if err != nil {
+		// 下发失败或被取消时保留文件，接收者可以重新确认
+		m.mu.Unlock()
+		logger().Warn("下发文件未完成", "file", file.Filename, "receiver", file.ReceiverID, "err", err)
+		return
+	}
+	if m.pending[file.FileKey] != file {
+		// 下发期间文件已被发送方撤回
+		m.mu.Unlock()
+		return
+	}
+	m.unlistLocked(file)
+	m.mu.Unlock()
+	m.dropFile(file)
+
+	m.sendStatus("file_accepted", file)
+	m.publishFileEvent(stageDelivered, file)
+	logger().Info("文件已发送", "file", file.Filename, "receiver", file.ReceiverID)
+}
+
+// stream 依次发送文件通知和文件数据包，并向双方推送下发进度
+func (m *Manager) stream(conn net.Conn, file *PendingFile, d *delivery) error {
+	err := frame.Write(conn, frame.TypeFileHeader, mustJSON(map[string]interface{}{
+		"type":       "file_notify",
+		"transferid": file.TransferID,
+		"filename":   file.Filename,
+		"size":       strconv.FormatInt(file.FileSize, 10),
+		"sha256":     file.Hash,
+		"sendid":     file.SenderID,
+	}))
+	if err != nil {
+		return fmt.Errorf("发送文件通知失败: %v", err)
+	}
+
+	fileData, err := os.Open(file.FilePath)
+	if err != nil {
+		return fmt.Errorf("无法打开存储的文件: %v", err)
+	}
+	defer fileData.Close()
+
+	var sent int64
+	tracker := progress{size: file.FileSize}
+	buf := make([]byte, chunkSize)
+	for {
+		select {
+		case <-d.cancel:
+			return fmt.Errorf("传输 %s 已取消", file.TransferID)
+		default:
+		}
+
+		n, err := fileData.Read(buf)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("读取存储文件失败: %v", err)
+		}
+		if n == 0 {
+			return nil
+		}
+
+		if err := frame.Write(conn, frame.TypeFileChunk, EncodeChunk(file.TransferID, buf[:n])); err != nil {
+			return fmt.Errorf("发送文件数据失败: %v", err)
+		}
+
+		sent += int64(n)
+		bytesSent.WithLabelValues("tcp").Add(uint64(n))
+		if tracker.due(sent) {
+			_ = frame.WriteJSON(conn, progressMessage(file.TransferID, "download", sent, file.FileSize))
+			progressToSender := progressMessage(file.SenderTransferID, "download", sent, file.FileSize)
+			progressToSender["receiveid"] = file.ReceiverID
+			m.notify(file.SenderID, progressToSender)
+		}
+	}
+}
+
+// finish 接收者拒收(file_decline)或已通过下载链接收取(file_received)，删除文件并通知发送方
+func (m *Manager) finish(userID string, conn net.Conn, msgType string, transferID string) error {
+	m.mu.Lock()
+	file, ok := m.findForReceiverLocked(userID, transferID)
+	if !ok {
+		m.mu.Unlock()
+		sendError(conn, transferID, "文件不存在或已处理")
+		return fmt.Errorf("未找到待接收文件: %s", transferID)
+	}
+	m.unlistLocked(file)
+	m.mu.Unlock()
+	m.dropFile(file)
+
+	if msgType == "file_decline" {
+		m.sendStatus("file_declined", file)
+		m.publishFileEvent(stageDeclined, file)
+		logger().Info("用户拒收文件", "user", userID, "file", file.Filename)
+		return nil
+	}
+	m.sendStatus("file_accepted", file)
+	m.publishFileEvent(stageDelivered, file)
+	logger().Info("用户已通过下载链接收取文件", "user", userID, "file", file.Filename)
+	return nil
+}
+
+// link 为接收者重新签发待接收文件的下载链接
+func (m *Manager) link(userID string, conn net.Conn, transferID string) error {
+	m.mu.Lock()
+	file, ok := m.findForReceiverLocked(userID, transferID)
+	m.mu.Unlock()
+	if !ok {
+		sendError(conn, transferID, "文件不存在或已处理")
+		return fmt.Errorf("未找到待接收文件: %s", transferID)
+	}
+
+	url, expires := m.downloadURL(conn, file)
+	return frame.WriteJSON(conn, map[string]interface{}{
+		"type":       "file_link_response",
+		"transferid": file.TransferID,
+		"url":        url,
+		"expires":    expires,
+	})
+}
+
+// cancel 处理取消请求
+// 发送方可以中止上传中的文件或撤回尚未被收取的文件；接收者可以中止正在进行的下发，文件保留待重新确认
+// 被取消的一方和发起方都会收到 file_cancelled
+func (m *Manager) cancel(userID string, conn net.Conn, transferID string) error {
+	m.mu.Lock()
+
+	if up, ok := m.uploads[uploadKey(userID, transferID)]; ok {
+		delete(m.uploads, uploadKey(userID, transferID))
+		m.mu.Unlock()
+		m.abortUploads([]*upload{up}, stageCancelled)
+		_ = frame.WriteJSON(conn, cancelledMessage(transferID, "sender"))
+		logger().Info("用户取消上传", "user", userID, "file", up.filename)
+		return nil
+	}
+
+	if file, ok := m.findForSenderLocked(userID, transferID); ok {
+		m.unlistLocked(file)
+		m.mu.Unlock()
+		m.dropFile(file)
+		_ = frame.WriteJSON(conn, cancelledMessage(transferID, "sender"))
+		m.notify(file.ReceiverID, cancelledMessage(file.TransferID, "sender"))
+		m.publishFileEvent(stageCancelled, file)
+		logger().Info("用户撤回文件", "user", userID, "file", file.Filename)
+		return nil
+	}
+
+	if file, ok := m.findForReceiverLocked(userID, transferID); ok {
+		d, delivering := m.deliveries[transferID]
+		if !delivering {
+			m.mu.Unlock()
+			sendError(conn, transferID, "文件未在下发，可使用 file_decline 拒收")
+			return fmt.Errorf("文件 %s 未在下发", transferID)
+		}
+		d.stop()
+		delete(m.deliveries, transferID)
+		m.mu.Unlock()
+		_ = frame.WriteJSON(conn, cancelledMessage(transferID, "receiver"))
+		m.notify(file.SenderID, cancelledMessage(file.SenderTransferID, "receiver"))
+		m.publishFileEvent(stageCancelled, file)
+		logger().Info("用户中止接收文件", "user", userID, "file", file.Filename)
+		return nil
+	}
+
+	m.mu.Unlock()
+	sendError(conn, transferID, "传输不存在或已结束")
+	return fmt.Errorf("未找到可取消的传输: %s", transferID)
+}
+
+// cancelledMessage 组装取消通知，by 表示发起取消的一方(sender/receiver)
+func cancelledMessage(transferID, by string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "file_cancelled",
+		"transferid": transferID,
+		"by":         by,
+	}
+}
+
+// mustJSON 序列化只包含基本类型的消息
+func mustJSON(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
@@ -0,0 +1,108 @@
+// Package frame 实现TCP连接上的分帧协议：4字节类型 + 4字节长度 + 消息体，均为大端序
+package frame
+
+import (
+	"connection_server_linux/metrics"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// 包类型
+const (
+	TypeJSON       uint32 = 1 // JSON消息
+	TypeFileChunk  uint32 = 2 // 文件数据
+	TypeFileHeader uint32 = 3 // 文件头/文件通知
+)
+
+// HeaderSize 包头长度
+const HeaderSize = 8
+
+// 协议错误的类型，作为 chat_frame_errors_total 的 type 标签
+const (
+	ErrorHeader      = "header"       // 包头不完整
+	ErrorTimeout     = "timeout"      // 读取超时
+	ErrorEmpty       = "empty"        // 消息体长度为0
+	ErrorBody        = "body"         // 消息体不完整
+	ErrorUnknownType = "unknown_type" // 未知的包类型
+	ErrorInvalidJSON = "invalid_json" // JSON包无法解析或缺少type字段
+)
+
+var frameErrors = metrics.NewCounterVec("chat_frame_errors_total", "分帧协议错误次数，按错误类型分组", "type")
+
+func init() {
+	// 预先创建所有类型，便于告警规则计算增长率
+	for _, kind := range []string{ErrorHeader, ErrorTimeout, ErrorEmpty, ErrorBody, ErrorUnknownType, ErrorInvalidJSON} {
+		frameErrors.WithLabelValues(kind)
+	}
+}
+
+// CountError 记录一次协议错误，kind 为 Error* 常量之一
+func CountError(kind string) {
+	frameErrors.WithLabelValues(kind).Inc()
+}
+
+// disconnected 判断读取错误是否只是连接正常断开
+func disconnected(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// Read 读取一个完整的数据包
+func Read(r io.Reader) (uint32, []byte, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			CountError(ErrorTimeout)
+		} else if !disconnected(err) {
+			CountError(ErrorHeader)
+		}
+		return 0, nil, fmt.Errorf("读取包头失败: %v", err)
+	}
+
+	packetType := binary.BigEndian.Uint32(header[:4])
+	payloadLen := binary.BigEndian.Uint32(header[4:])
+	if payloadLen == 0 {
+		CountError(ErrorEmpty)
+		return packetType, nil, errors.New("空消息")
+	}
+
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			CountError(ErrorTimeout)
+		} else {
+			CountError(ErrorBody)
+		}
+		return packetType, nil, fmt.Errorf("读取消息体失败: %v", err)
+	}
+
+	return packetType, payload, nil
+}
+
+// Write 写入一个数据包
+// 包头和消息体合并为一次Write，多个协程向同一连接写入时不会交错
+func Write(w io.Writer, packetType uint32, payload []byte) error {
+	packet := make([]byte, HeaderSize+len(payload))
+	binary.BigEndian.PutUint32(packet[:4], packetType)
+	binary.BigEndian.PutUint32(packet[4:HeaderSize], uint32(len(payload)))
+	copy(packet[HeaderSize:], payload)
+
+	if _, err := w.Write(packet); err != nil {
+		return fmt.Errorf("写入数据包失败: %v", err)
+	}
+	return nil
+}
+
+// WriteJSON 序列化v并以JSON包(type=1)写入
+func WriteJSON(w io.Writer, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("序列化消息失败: %v", err)
+	}
+	return Write(w, TypeJSON, payload)
+}
@@ -1,6 +1,6 @@
 module connection_server_linux
 
-go 1.20
+go 1.21
 
 require (
 	github.com/gorilla/mux v1.8.0
@@ -0,0 +1,128 @@
+// Package logging 基于 log/slog 的结构化日志
+// 支持配置日志级别和输出格式(text/json)，按字段名脱敏密码、消息内容等敏感信息，
+// 并为每个TCP连接和HTTP请求分配ID，通过 context 或显式传递的 *slog.Logger 关联同一连接的所有日志。
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// 输出格式
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Redacted 被脱敏字段的替换值
+const Redacted = "[REDACTED]"
+
+// redactedKeys 需要脱敏的字段名(小写)，任何分组下的同名字段都会被替换
+var redactedKeys = map[string]bool{
+	"password":   true,
+	"pwd":        true,
+	"content":    true,
+	"relation":   true,
+	"token":      true,
+	"csrf_token": true,
+	"session_id": true,
+	"cookie":     true,
+}
+
+// ParseLevel 解析日志级别：debug、info、warn、error
+func ParseLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return 0, fmt.Errorf("无效的日志级别 %q: %v", value, err)
+	}
+	return level, nil
+}
+
+// redact 替换敏感字段的值
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if redactedKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, Redacted)
+	}
+	return a
+}
+
+// New 创建写入 w 的日志记录器
+// level 为 debug/info/warn/error，format 为 text 或 json
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: redact}
+
+	switch format {
+	case FormatText, "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("无效的日志格式 %q，可选 text 或 json", format)
+	}
+}
+
+// Setup 创建日志记录器并设置为全局默认，标准库 log 包的输出也会转到该记录器
+func Setup(w io.Writer, level, format string) (*slog.Logger, error) {
+	logger, err := New(w, level, format)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+// NewID 生成连接或请求ID
+func NewID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+type contextKey struct{}
+
+// WithLogger 将日志记录器保存到 context
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// Lookup 取出 context 中的日志记录器
+func Lookup(ctx context.Context) (*slog.Logger, bool) {
+	logger, ok := ctx.Value(contextKey{}).(*slog.Logger)
+	return logger, ok
+}
+
+// FromContext 取出 context 中的日志记录器，没有时返回全局默认记录器
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := Lookup(ctx); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Middleware 为每个HTTP请求分配ID，并把带有请求信息的日志记录器放入 context
+// 请求ID同时通过 X-Request-ID 响应头返回，便于按ID查找日志
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := NewID()
+		w.Header().Set("X-Request-ID", requestID)
+		logger := slog.Default().With(
+			"req", requestID,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote", r.RemoteAddr,
+		)
+		next.ServeHTTP(w, r.WithContext(WithLogger(r.Context(), logger)))
+	})
+}
@@ -0,0 +1,101 @@
+package logincheck
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	sm := GlobalSessionManager
+	GlobalSessionManager = NewSessionManager(NewMemoryStore(), 0, 0)
+	t.Cleanup(func() { GlobalSessionManager = sm })
+
+	GlobalSessionManager.CreateSession("root", "sid", RoleAdmin, "127.0.0.1", "test")
+	token, ok := GlobalSessionManager.EnsureCSRFToken("sid")
+	if !ok || token == "" {
+		t.Fatal("新会话没有CSRF令牌")
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	// httptest.NewRequest 的 Host 为 example.com
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		session string            // 为空时不带会话cookie
+		header  map[string]string // 额外的请求头
+		want    int
+	}{
+		{"未登录的GET", "GET", "/api/clients", "", nil, http.StatusNoContent},
+		{"未登录的同源登录", "POST", "/api/login", "", map[string]string{"Origin": "https://example.com"}, http.StatusNoContent},
+		{"未登录且无来源的登录", "POST", "/api/login", "", nil, http.StatusNoContent},
+		{"未登录的跨站登录", "POST", "/api/login", "", map[string]string{"Origin": "https://evil.test"}, http.StatusForbidden},
+		{"会话无效时交给登录检查", "POST", "/api/users", "expired", nil, http.StatusNoContent},
+
+		{"GET不需要令牌", "GET", "/api/clients", "sid", nil, http.StatusNoContent},
+		{"HEAD不需要令牌", "HEAD", "/api/clients", "sid", nil, http.StatusNoContent},
+		{"OPTIONS不需要令牌", "OPTIONS", "/api/clients", "sid", nil, http.StatusNoContent},
+		{"跨站GET不检查来源", "GET", "/api/clients", "sid", map[string]string{"Origin": "https://evil.test"}, http.StatusNoContent},
+		{"非API路径不需要令牌", "POST", "/login.html", "sid", nil, http.StatusNoContent},
+
+		{"缺少令牌", "POST", "/api/kick/1", "sid", nil, http.StatusForbidden},
+		{"令牌错误", "POST", "/api/kick/1", "sid", map[string]string{CSRFHeader: token + "x"}, http.StatusForbidden},
+		{"其他会话的令牌", "DELETE", "/api/admins/a", "sid", map[string]string{CSRFHeader: newCSRFToken()}, http.StatusForbidden},
+		{"令牌正确", "POST", "/api/kick/1", "sid", map[string]string{CSRFHeader: token}, http.StatusNoContent},
+		{"令牌正确且同源", "POST", "/api/kick/1", "sid", map[string]string{CSRFHeader: token, "Origin": "https://EXAMPLE.com"}, http.StatusNoContent},
+		{"Referer同源", "POST", "/api/kick/1", "sid", map[string]string{CSRFHeader: token, "Referer": "https://example.com/home.html"}, http.StatusNoContent},
+		{"Origin不一致", "POST", "/api/kick/1", "sid", map[string]string{CSRFHeader: token, "Origin": "https://evil.test"}, http.StatusForbidden},
+		{"Origin端口不一致", "POST", "/api/kick/1", "sid", map[string]string{CSRFHeader: token, "Origin": "https://example.com:8443"}, http.StatusForbidden},
+		{"Referer不一致", "POST", "/api/kick/1", "sid", map[string]string{CSRFHeader: token, "Referer": "https://evil.test/page"}, http.StatusForbidden},
+		{"Origin优先于Referer", "POST", "/api/kick/1", "sid", map[string]string{CSRFHeader: token, "Origin": "https://evil.test", "Referer": "https://example.com/"}, http.StatusForbidden},
+		{"Origin无法解析", "POST", "/api/kick/1", "sid", map[string]string{CSRFHeader: token, "Origin": "://bad"}, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(c.method, c.path, nil)
+			if c.session != "" {
+				r.AddCookie(&http.Cookie{Name: "sessionID", Value: c.session})
+			}
+			for k, v := range c.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			CSRFMiddleware(next).ServeHTTP(w, r)
+			if w.Code != c.want {
+				t.Fatalf("状态码 = %d, 期望 %d, 响应: %s", w.Code, c.want, w.Body.String())
+			}
+		})
+	}
+}
+
+// 缺少令牌cookie时补发，已有正确的cookie时不重复下发
+func TestCSRFMiddlewareSetsCookie(t *testing.T) {
+	sm := GlobalSessionManager
+	GlobalSessionManager = NewSessionManager(NewMemoryStore(), 0, 0)
+	t.Cleanup(func() { GlobalSessionManager = sm })
+
+	GlobalSessionManager.CreateSession("root", "sid", RoleAdmin, "127.0.0.1", "test")
+	token, _ := GlobalSessionManager.EnsureCSRFToken("sid")
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	r := httptest.NewRequest("GET", "/home.html", nil)
+	r.AddCookie(&http.Cookie{Name: "sessionID", Value: "sid"})
+	w := httptest.NewRecorder()
+	CSRFMiddleware(next).ServeHTTP(w, r)
+	if set := w.Header().Get("Set-Cookie"); !strings.Contains(set, csrfCookieName+"="+token) {
+		t.Fatalf("未补发令牌cookie, Set-Cookie: %q", set)
+	}
+
+	r = httptest.NewRequest("GET", "/home.html", nil)
+	r.AddCookie(&http.Cookie{Name: "sessionID", Value: "sid"})
+	r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+	w = httptest.NewRecorder()
+	CSRFMiddleware(next).ServeHTTP(w, r)
+	if set := w.Header().Get("Set-Cookie"); set != "" {
+		t.Fatalf("已有令牌cookie时不应再下发, Set-Cookie: %q", set)
+	}
+}
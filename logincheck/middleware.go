@@ -2,18 +2,35 @@ package logincheck
 //中间件
 import (
 	"net/http"
-	"time"
 	"strings"
-	"log"
+	"connection_server_linux/logging"
 )
 
 // AuthMiddleware 中间件：检查用户是否已登录
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// 排除登录页面和登录API
-		if r.URL.Path == "/login.html" || strings.HasPrefix(r.URL.Path, "/api/login") {
+		if r.URL.Path == "/login.html" || r.URL.Path == "/api/login" || r.URL.Path == "/api/logout" {
+			next.ServeHTTP(w, r)
+			logging.FromContext(r.Context()).Debug("登录界面无需验证")
+			return
+		}
+
+		// 健康检查供systemd和负载均衡器探测，不需要登录
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 监控指标供Prometheus抓取，由指标处理函数校验令牌
+		if r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 文件下载链接自带签名，由下载处理函数校验
+		if strings.HasPrefix(r.URL.Path, "/files/") {
 			next.ServeHTTP(w, r)
-			log.Println("登录界面无需验证")
 			return
 		}
 
@@ -23,13 +40,7 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		if err != nil || sessionCookie.Value == "" || !GlobalSessionManager.ValidateSession(sessionCookie.Value) {
 			
 			// 清除无效的cookie
-			http.SetCookie(w, &http.Cookie{
-				Name:     "sessionID",
-				Value:    "",
-				Path:     "/",
-				HttpOnly: true,
-				Expires:  time.Unix(0, 0),
-			})
+			ClearSessionCookie(w)
 
 			// 区分API请求和页面请求的响应
 			if strings.HasPrefix(r.URL.Path, "/api/") {
@@ -46,6 +57,6 @@ func AuthMiddleware(next http.Handler) http.Handler {
 
 		// 验证通过，继续处理请求
 		next.ServeHTTP(w, r)
-		log.Println("验证通过")
+		logging.FromContext(r.Context()).Debug("验证通过")
 	})
 }
\ No newline at end of file
@@ -0,0 +1,74 @@
+package logincheck
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// PBKDF2-HMAC-SHA256 的公开测试向量，前一条来自 RFC 7914 第11节
+func TestPBKDF2KnownAnswers(t *testing.T) {
+	cases := []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"},
+		{"pass\x00word", "sa\x00lt", 4096, "89b69d0516f829893c696226650a8687"},
+	}
+	for _, c := range cases {
+		want, err := hex.DecodeString(c.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := pbkdf2([]byte(c.password), []byte(c.salt), c.iterations, len(want))
+		if hex.EncodeToString(got) != c.want {
+			t.Errorf("pbkdf2(%q, %q, %d, %d) = %x, 期望 %s", c.password, c.salt, c.iterations, len(want), got, c.want)
+		}
+	}
+}
+
+func TestHashAndVerifyPassword(t *testing.T) {
+	encoded, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "pbkdf2-sha256$100000$") {
+		t.Fatalf("哈希格式不正确: %s", encoded)
+	}
+	if other, _ := HashPassword("correct horse"); other == encoded {
+		t.Fatal("相同密码两次哈希结果相同，盐未随机生成")
+	}
+
+	// 迭代次数取自哈希本身，保存的旧哈希在调整默认值后仍可校验
+	const rfc = "pbkdf2-sha256$1$c2FsdA$Eg+2z/z4syxD5yJSVsT4N6hlSMkszDVICAWYfLcL4Xs"
+	cases := []struct {
+		name     string
+		encoded  string
+		password string
+		want     bool
+	}{
+		{"正确密码", encoded, "correct horse", true},
+		{"错误密码", encoded, "correct horse!", false},
+		{"空密码", encoded, "", false},
+		{"已知哈希", rfc, "password", true},
+		{"已知哈希错误密码", rfc, "Password", false},
+		{"未知算法", strings.Replace(rfc, "pbkdf2-sha256", "md5", 1), "password", false},
+		{"缺少字段", "pbkdf2-sha256$1$c2FsdA", "password", false},
+		{"迭代次数为0", "pbkdf2-sha256$0$c2FsdA$Eg+2z/z4syxD5yJSVsT4N6hlSMkszDVICAWYfLcL4Xs", "password", false},
+		{"盐不是base64", "pbkdf2-sha256$1$!!$Eg+2z/z4syxD5yJSVsT4N6hlSMkszDVICAWYfLcL4Xs", "password", false},
+		{"哈希为空", "pbkdf2-sha256$1$c2FsdA$", "password", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := VerifyPassword(c.encoded, c.password); got != c.want {
+				t.Fatalf("VerifyPassword(%q, %q) = %v, 期望 %v", c.encoded, c.password, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package logincheck
+
+//登录限流：TCP登录和HTTP管理后台登录共用，按IP和用户名分别计数
+//用户名按登录入口分开计数，避免在一个入口猜密码锁住另一个入口的同名账号
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 限流对象的类型
+// IP的记录各入口共用：只有从该IP发起的尝试才会锁定它
+const (
+	LimitByIP        = "ip"
+	LimitByTCPUser   = "tcp-user"   // TCP聊天登录的用户名
+	LimitByAdminUser = "admin-user" // 管理后台登录的用户名
+)
+
+// Surface 登录入口，决定用户名记录的类型
+type Surface string
+
+const (
+	SurfaceTCP   Surface = LimitByTCPUser
+	SurfaceAdmin Surface = LimitByAdminUser
+)
+
+// ValidLimitKind 判断是否为已知的限流对象类型
+func ValidLimitKind(kind string) bool {
+	return kind == LimitByIP || kind == LimitByTCPUser || kind == LimitByAdminUser
+}
+
+// 默认限流参数
+const (
+	DefaultFreeAttempts = 5                // 连续失败多少次后开始锁定
+	DefaultBaseLockout  = 30 * time.Second // 首次锁定时长，之后每次失败翻倍
+	DefaultMaxLockout   = time.Hour        // 单次锁定的最长时长
+	DefaultResetAfter   = 30 * time.Minute // 最后一次失败后多久清零计数
+)
+
+// attempt 单个IP或用户名的失败记录
+type attempt struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// LockoutInfo 供管理后台查看的锁定状态
+type LockoutInfo struct {
+	Kind        string    `json:"kind"` // ip、tcp-user 或 admin-user
+	Value       string    `json:"value"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+	Locked      bool      `json:"locked"`
+}
+
+// LoginLimiter 登录失败限流器
+// 连续失败超过 FreeAttempts 次后锁定，锁定时长从 BaseLockout 开始按失败次数指数增长，最长 MaxLockout
+type LoginLimiter struct {
+	mu           sync.Mutex
+	entries      map[string]*attempt // key 为 "<kind>:<value>"
+	FreeAttempts int
+	BaseLockout  time.Duration
+	MaxLockout   time.Duration
+	ResetAfter   time.Duration
+	now          func() time.Time // 测试时替换
+}
+
+// GlobalLoginLimiter 全局登录限流器实例，过期记录由使用它的服务器定期清理
+var GlobalLoginLimiter = NewLoginLimiter()
+
+// NewLoginLimiter 创建使用默认参数的登录限流器
+func NewLoginLimiter() *LoginLimiter {
+	return &LoginLimiter{
+		entries:      make(map[string]*attempt),
+		FreeAttempts: DefaultFreeAttempts,
+		BaseLockout:  DefaultBaseLockout,
+		MaxLockout:   DefaultMaxLockout,
+		ResetAfter:   DefaultResetAfter,
+		now:          time.Now,
+	}
+}
+
+// limitKeys 返回一次登录涉及的限流key，用户名为空时只按IP限流
+func limitKeys(surface Surface, ip, username string) []string {
+	keys := make([]string, 0, 2)
+	if ip != "" {
+		keys = append(keys, LimitByIP+":"+ip)
+	}
+	if username != "" {
+		keys = append(keys, string(surface)+":"+username)
+	}
+	return keys
+}
+
+// stale 判断失败记录是否已过期可以清除
+func (l *LoginLimiter) stale(a *attempt, now time.Time) bool {
+	return now.After(a.lockedUntil) && now.Sub(a.lastFailure) > l.ResetAfter
+}
+
+// Check 检查IP或该入口的用户名是否处于锁定中
+// 返回值: 剩余锁定时长及是否允许尝试登录
+func (l *LoginLimiter) Check(surface Surface, ip, username string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var wait time.Duration
+	for _, key := range limitKeys(surface, ip, username) {
+		a, ok := l.entries[key]
+		if !ok {
+			continue
+		}
+		if remaining := a.lockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, wait <= 0
+}
+
+// Fail 记录一次登录失败，达到阈值后锁定对应的IP和用户名
+// 返回值: 本次失败后IP和用户名中最长的锁定时长，未锁定时为0
+func (l *LoginLimiter) Fail(surface Surface, ip, username string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var longest time.Duration
+	for _, key := range limitKeys(surface, ip, username) {
+		a, ok := l.entries[key]
+		if !ok || l.stale(a, now) {
+			a = &attempt{}
+			l.entries[key] = a
+		}
+		a.failures++
+		a.lastFailure = now
+
+		if over := a.failures - l.FreeAttempts; over > 0 {
+			lockout := l.BaseLockout
+			for i := 1; i < over && lockout < l.MaxLockout; i++ {
+				lockout *= 2
+			}
+			if lockout > l.MaxLockout {
+				lockout = l.MaxLockout
+			}
+			a.lockedUntil = now.Add(lockout)
+			if lockout > longest {
+				longest = lockout
+			}
+		}
+	}
+	return longest
+}
+
+// Succeed 登录成功后清除该入口用户名的失败记录
+// IP的记录保留到自然过期，避免扫描者用一个已知账号反复清零
+func (l *LoginLimiter) Succeed(surface Surface, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, string(surface)+":"+username)
+}
+
+// Lockouts 列出所有未过期的失败记录，锁定中的排在前面
+func (l *LoginLimiter) Lockouts() []LockoutInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	list := make([]LockoutInfo, 0, len(l.entries))
+	for key, a := range l.entries {
+		if l.stale(a, now) {
+			continue
+		}
+		kind, value := splitLimitKey(key)
+		list = append(list, LockoutInfo{
+			Kind:        kind,
+			Value:       value,
+			Failures:    a.failures,
+			LastFailure: a.lastFailure,
+			LockedUntil: a.lockedUntil,
+			Locked:      now.Before(a.lockedUntil),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Locked != list[j].Locked {
+			return list[i].Locked
+		}
+		return list[i].LastFailure.After(list[j].LastFailure)
+	})
+	return list
+}
+
+// Unlock 手动解除指定IP或用户名的锁定并清零失败次数
+// 返回值: 是否存在对应的记录
+func (l *LoginLimiter) Unlock(kind, value string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := kind + ":" + value
+	if _, ok := l.entries[key]; !ok {
+		return false
+	}
+	delete(l.entries, key)
+	return true
+}
+
+// splitLimitKey 将 "<kind>:<value>" 拆分，IPv6地址本身含冒号，只按第一个冒号拆分
+func splitLimitKey(key string) (string, string) {
+	parts := strings.SplitN(key, ":", 2)
+	if len(parts) < 2 {
+		return key, ""
+	}
+	return parts[0], parts[1]
+}
+
+// Prune 清除已过期的失败记录，返回清除的条数
+func (l *LoginLimiter) Prune() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	count := 0
+	for key, a := range l.entries {
+		if l.stale(a, now) {
+			delete(l.entries, key)
+			count++
+		}
+	}
+	return count
+}
+
+// RunCleanup 每隔 interval 调用一次 Prune，直到 ctx 结束
+func (l *LoginLimiter) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.Prune()
+		}
+	}
+}
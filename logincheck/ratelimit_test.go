@@ -0,0 +1,191 @@
+package logincheck
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock 可手动拨动的时钟
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time      { return c.t }
+func (c *fakeClock) add(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter() (*LoginLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	l := NewLoginLimiter()
+	l.FreeAttempts = 3
+	l.BaseLockout = 10 * time.Second
+	l.MaxLockout = time.Minute
+	l.ResetAfter = 5 * time.Minute
+	l.now = clock.now
+	return l, clock
+}
+
+func TestLoginLimiterBackoff(t *testing.T) {
+	l, _ := newTestLimiter()
+
+	// 前 FreeAttempts 次失败不锁定，之后每次翻倍，封顶 MaxLockout
+	want := []time.Duration{0, 0, 0, 10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	for i, w := range want {
+		if got := l.Fail(SurfaceTCP, "", "alice"); got != w {
+			t.Fatalf("第%d次失败锁定 %v, 期望 %v", i+1, got, w)
+		}
+	}
+	wait, ok := l.Check(SurfaceTCP, "", "alice")
+	if ok || wait != time.Minute {
+		t.Fatalf("Check = %v, %v, 期望锁定 1m", wait, ok)
+	}
+}
+
+func TestLoginLimiterLockoutExpires(t *testing.T) {
+	l, clock := newTestLimiter()
+	for i := 0; i < 4; i++ {
+		l.Fail(SurfaceTCP, "10.0.0.1", "alice")
+	}
+	if _, ok := l.Check(SurfaceTCP, "10.0.0.1", "alice"); ok {
+		t.Fatal("连续失败后应被锁定")
+	}
+
+	clock.add(10 * time.Second)
+	if wait, ok := l.Check(SurfaceTCP, "10.0.0.1", "alice"); !ok {
+		t.Fatalf("锁定到期后仍被拒绝，剩余 %v", wait)
+	}
+
+	// 计数未清零，到期后再失败一次继续翻倍
+	if got := l.Fail(SurfaceTCP, "10.0.0.1", "alice"); got != 20*time.Second {
+		t.Fatalf("到期后再次失败锁定 %v, 期望 20s", got)
+	}
+
+	// 超过 ResetAfter 后计数清零，重新获得免锁定次数
+	clock.add(l.ResetAfter + 20*time.Second)
+	if got := l.Fail(SurfaceTCP, "10.0.0.1", "alice"); got != 0 {
+		t.Fatalf("计数清零后失败锁定 %v, 期望 0", got)
+	}
+	if list := l.Lockouts(); len(list) != 2 || list[0].Failures != 1 {
+		t.Fatalf("Lockouts = %+v, 期望IP和用户名各一条且失败1次", list)
+	}
+}
+
+func TestLoginLimiterUnlock(t *testing.T) {
+	l, _ := newTestLimiter()
+	for i := 0; i < 4; i++ {
+		l.Fail(SurfaceAdmin, "10.0.0.1", "root")
+	}
+
+	list := l.Lockouts()
+	if len(list) != 2 {
+		t.Fatalf("Lockouts 返回 %d 条, 期望 2", len(list))
+	}
+	kinds := map[string]string{}
+	for _, info := range list {
+		if !info.Locked {
+			t.Fatalf("%s:%s 应处于锁定中", info.Kind, info.Value)
+		}
+		kinds[info.Kind] = info.Value
+	}
+	if kinds[LimitByIP] != "10.0.0.1" || kinds[LimitByAdminUser] != "root" {
+		t.Fatalf("Lockouts 类型不正确: %+v", kinds)
+	}
+
+	if !l.Unlock(LimitByAdminUser, "root") {
+		t.Fatal("Unlock 未找到用户名记录")
+	}
+	if l.Unlock(LimitByAdminUser, "root") {
+		t.Fatal("重复 Unlock 应返回 false")
+	}
+	// IP仍被锁定
+	if _, ok := l.Check(SurfaceAdmin, "10.0.0.1", "root"); ok {
+		t.Fatal("只解除用户名后IP仍应被锁定")
+	}
+	if _, ok := l.Check(SurfaceAdmin, "10.0.0.2", "root"); !ok {
+		t.Fatal("解除后从其他IP登录不应被拒绝")
+	}
+	if !l.Unlock(LimitByIP, "10.0.0.1") {
+		t.Fatal("Unlock 未找到IP记录")
+	}
+	if _, ok := l.Check(SurfaceAdmin, "10.0.0.1", "root"); !ok {
+		t.Fatal("全部解除后仍被锁定")
+	}
+}
+
+func TestLoginLimiterSurfacesAreSeparate(t *testing.T) {
+	l, _ := newTestLimiter()
+
+	// 从多个IP猜测 alice 的聊天密码，不应影响同名的后台账号
+	for i := 0; i < 10; i++ {
+		l.Fail(SurfaceTCP, "", "alice")
+	}
+	if _, ok := l.Check(SurfaceTCP, "10.0.0.9", "alice"); ok {
+		t.Fatal("聊天入口的 alice 应被锁定")
+	}
+	if wait, ok := l.Check(SurfaceAdmin, "10.0.0.9", "alice"); !ok {
+		t.Fatalf("后台入口的 alice 被聊天入口的失败锁定 %v", wait)
+	}
+
+	// 反过来也一样，且登录成功只清除本入口的记录
+	for i := 0; i < 10; i++ {
+		l.Fail(SurfaceAdmin, "", "alice")
+	}
+	l.Succeed(SurfaceTCP, "alice")
+	if _, ok := l.Check(SurfaceTCP, "", "alice"); !ok {
+		t.Fatal("聊天入口登录成功后仍被锁定")
+	}
+	if _, ok := l.Check(SurfaceAdmin, "", "alice"); ok {
+		t.Fatal("聊天入口登录成功不应清除后台入口的锁定")
+	}
+}
+
+func TestLoginLimiterPrune(t *testing.T) {
+	l, clock := newTestLimiter()
+	l.Fail(SurfaceTCP, "10.0.0.1", "alice")
+	for i := 0; i < 10; i++ {
+		l.Fail(SurfaceAdmin, "", "root") // 锁定 MaxLockout
+	}
+
+	clock.add(l.ResetAfter + time.Second)
+	l.Fail(SurfaceTCP, "", "bob")
+	// alice、IP和root的锁定均已结束且超过 ResetAfter，只保留刚失败的bob
+	if n := l.Prune(); n != 3 {
+		t.Fatalf("Prune 清除 %d 条, 期望 3", n)
+	}
+	if list := l.Lockouts(); len(list) != 1 || list[0].Value != "bob" {
+		t.Fatalf("Prune 后剩余 %+v, 期望只剩 bob", list)
+	}
+
+	// 服务器注入的限流器由 RunCleanup 定期清理
+	clock.add(l.ResetAfter + time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		l.RunCleanup(ctx, time.Millisecond)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for len(l.Lockouts()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("RunCleanup 未清除过期记录: %+v", l.Lockouts())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+func TestSplitLimitKey(t *testing.T) {
+	cases := []struct {
+		key, kind, value string
+	}{
+		{"ip:10.0.0.1", LimitByIP, "10.0.0.1"},
+		{"ip:::1", LimitByIP, "::1"},
+		{"tcp-user:a:b", LimitByTCPUser, "a:b"},
+		{"admin-user:root", LimitByAdminUser, "root"},
+	}
+	for _, c := range cases {
+		kind, value := splitLimitKey(c.key)
+		if kind != c.kind || value != c.value {
+			t.Errorf("splitLimitKey(%q) = %q, %q, 期望 %q, %q", c.key, kind, value, c.kind, c.value)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package logincheck
+
+//管理后台角色与接口权限
+import (
+	"connection_server_linux/logging"
+	"fmt"
+	"net/http"
+)
+
+// Role 管理后台账号的角色
+type Role string
+
+// 角色从低到高：只读、运维、管理员，高级角色拥有低级角色的全部权限
+const (
+	RoleViewer   Role = "viewer"   // 只能查看客户端、用户和事件
+	RoleOperator Role = "operator" // 还可以踢出客户端、发送消息和公告
+	RoleAdmin    Role = "admin"    // 全部权限，包括账号、封禁、会话和审计管理
+)
+
+var roleLevels = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// ParseRole 校验并返回角色
+func ParseRole(value string) (Role, error) {
+	role := Role(value)
+	if _, ok := roleLevels[role]; !ok {
+		return "", fmt.Errorf("未知的角色: %q", value)
+	}
+	return role, nil
+}
+
+// Allows 判断该角色是否满足所需的角色
+// 旧版本创建的会话没有角色，按只读处理；无法识别的角色没有任何权限
+func (r Role) Allows(required Role) bool {
+	if r == "" {
+		r = RoleViewer
+	}
+	level, ok := roleLevels[r]
+	return ok && level >= roleLevels[required]
+}
+
+// RequireRole 包装处理函数，只允许角色不低于 required 的会话访问
+// 需配合 AuthMiddleware 使用，会话无效时返回401，权限不足时返回403
+func RequireRole(required Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, session, ok := SessionFromRequest(r)
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"请先登录"}`))
+			return
+		}
+
+		if !session.Role.Allows(required) {
+			logging.FromContext(r.Context()).Warn("管理员无权访问", "admin", session.UserID, "role", session.Role, "required", required)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"权限不足"}`))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package logincheck
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoleAllows(t *testing.T) {
+	cases := []struct {
+		role, required Role
+		want           bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleOperator, false},
+		{RoleViewer, RoleAdmin, false},
+		{RoleOperator, RoleViewer, true},
+		{RoleOperator, RoleOperator, true},
+		{RoleOperator, RoleAdmin, false},
+		{RoleAdmin, RoleViewer, true},
+		{RoleAdmin, RoleOperator, true},
+		{RoleAdmin, RoleAdmin, true},
+		{"", RoleViewer, true}, // 旧会话按只读处理
+		{"", RoleOperator, false},
+		{"root", RoleViewer, false}, // 无法识别的角色没有任何权限
+	}
+	for _, c := range cases {
+		if got := c.role.Allows(c.required); got != c.want {
+			t.Errorf("Role(%q).Allows(%q) = %v, 期望 %v", c.role, c.required, got, c.want)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	sm := GlobalSessionManager
+	GlobalSessionManager = NewSessionManager(NewMemoryStore(), 0, 0)
+	t.Cleanup(func() { GlobalSessionManager = sm })
+
+	sessions := map[Role]string{
+		RoleViewer:   "viewer-session",
+		RoleOperator: "operator-session",
+		RoleAdmin:    "admin-session",
+		"":           "legacy-session",
+		"root":       "unknown-role-session",
+	}
+	for role, id := range sessions {
+		GlobalSessionManager.CreateSession("user-"+string(role), id, role, "127.0.0.1", "test")
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	cases := []struct {
+		name     string
+		session  string // 为空时不带cookie
+		required Role
+		want     int
+	}{
+		{"未登录", "", RoleViewer, http.StatusUnauthorized},
+		{"会话不存在", "missing", RoleViewer, http.StatusUnauthorized},
+		{"只读访问只读接口", "viewer-session", RoleViewer, http.StatusNoContent},
+		{"只读访问运维接口", "viewer-session", RoleOperator, http.StatusForbidden},
+		{"运维访问运维接口", "operator-session", RoleOperator, http.StatusNoContent},
+		{"运维访问管理接口", "operator-session", RoleAdmin, http.StatusForbidden},
+		{"管理员访问管理接口", "admin-session", RoleAdmin, http.StatusNoContent},
+		{"旧会话访问只读接口", "legacy-session", RoleViewer, http.StatusNoContent},
+		{"旧会话访问运维接口", "legacy-session", RoleOperator, http.StatusForbidden},
+		{"未知角色", "unknown-role-session", RoleViewer, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/test", nil)
+			if c.session != "" {
+				r.AddCookie(&http.Cookie{Name: "sessionID", Value: c.session})
+			}
+			w := httptest.NewRecorder()
+			RequireRole(c.required, ok).ServeHTTP(w, r)
+			if w.Code != c.want {
+				t.Fatalf("状态码 = %d, 期望 %d, 响应: %s", w.Code, c.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -1,103 +1,351 @@
 // Package main 实现了一个会话管理系统，用于处理用户会话的创建、验证和清理。
 // 该系统支持会话的自动过期和定期清理功能，确保系统资源的有效利用。
+// 会话数据保存在 SessionStore 中，可以选择内存存储或SQLite存储。
 package logincheck
 
 import (
-	"sync"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
 	"time"
-	"log"
+)
+
+const (
+	DefaultIdleTimeout = 24 * time.Hour     // 默认空闲超时，期间有访问则顺延
+	DefaultMaxLifetime = 7 * 24 * time.Hour // 默认绝对有效期，到期必须重新登录
+	slideInterval      = time.Minute        // 顺延过期时间的最小间隔，避免每个请求都写存储
 )
 
 // Session 表示一个用户会话，包含用户标识和会话的时间信息
 type Session struct {
-	UserID    string    // 用户的唯一标识符
-	CreatedAt time.Time // 会话创建时间
-	ExpiresAt time.Time // 会话过期时间
+	UserID            string    // 用户的唯一标识符
+	CreatedAt         time.Time // 会话创建时间
+	ExpiresAt         time.Time // 空闲过期时间，每次访问顺延
+	AbsoluteExpiresAt time.Time // 绝对过期时间，不会顺延
+	IP                string    // 登录时的客户端IP
+	UserAgent         string    // 登录时的浏览器标识
+	CSRFToken         string    // 与会话绑定的CSRF令牌
+	Role              Role      // 登录账号的角色
+}
+
+// expired 判断会话在指定时间是否已过期
+func (s Session) expired(now time.Time) bool {
+	return now.After(s.ExpiresAt) || now.After(s.AbsoluteExpiresAt)
+}
+
+// SessionInfo 会话的对外展示信息，不包含可直接使用的会话ID
+type SessionInfo struct {
+	ID        string    `json:"id"` // 会话ID的摘要，用于吊销
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Role      Role      `json:"role"`
+	Current   bool      `json:"current"` // 是否为发起请求的会话
+}
+
+// sessionKey 计算会话ID的SHA-256摘要，存储中只保存摘要，存储泄露也无法冒用cookie
+func sessionKey(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:])
+}
+
+// publicID 由存储键得到管理界面展示和传递的短ID
+func publicID(key string) string {
+	return key[:16]
+}
+
+// PublicSessionID 计算会话ID的摘要，管理界面只展示和传递摘要
+func PublicSessionID(sessionID string) string {
+	return publicID(sessionKey(sessionID))
 }
 
 // SessionManager 管理所有用户会话，提供会话的创建、验证和删除功能
 type SessionManager struct {
-	sessions map[string]*Session // 存储会话ID到会话对象的映射
-	mutex    sync.RWMutex       // 用于保护会话map的读写锁
+	store       SessionStore     // 会话存储
+	IdleTimeout time.Duration    // 空闲超时
+	MaxLifetime time.Duration    // 绝对有效期
+	Logger      *slog.Logger     // 日志记录器，为空时使用全局默认记录器
+	now         func() time.Time // 测试时替换
+}
+
+// logger 返回会话管理器使用的日志记录器
+func (sm *SessionManager) logger() *slog.Logger {
+	if sm.Logger != nil {
+		return sm.Logger
+	}
+	return slog.Default().With("component", "session")
 }
 
 // GlobalSessionManager 是全局的会话管理器实例
 var GlobalSessionManager *SessionManager
 
 // init 初始化全局会话管理器并启动过期会话清理协程
+// 默认使用内存存储，main 可通过 InitSessionManager 替换为持久化存储
 func init() {
-	GlobalSessionManager = NewSessionManager()
-	go GlobalSessionManager.cleanupExpiredSessions()
+	GlobalSessionManager = NewSessionManager(NewMemoryStore(), DefaultIdleTimeout, DefaultMaxLifetime)
+	go cleanupExpiredSessions()
 }
 
 // NewSessionManager 创建并返回一个新的会话管理器实例
-func NewSessionManager() *SessionManager {
+// idleTimeout、maxLifetime 为0时使用默认值
+func NewSessionManager(store SessionStore, idleTimeout, maxLifetime time.Duration) *SessionManager {
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	if maxLifetime <= 0 {
+		maxLifetime = DefaultMaxLifetime
+	}
 	return &SessionManager{
-		sessions: make(map[string]*Session),
+		store:       store,
+		IdleTimeout: idleTimeout,
+		MaxLifetime: maxLifetime,
+		now:         time.Now,
 	}
 }
 
+// InitSessionManager 替换全局会话管理器，需在HTTPS服务器启动前调用
+func InitSessionManager(store SessionStore, idleTimeout, maxLifetime time.Duration, logger *slog.Logger) {
+	GlobalSessionManager = NewSessionManager(store, idleTimeout, maxLifetime)
+	GlobalSessionManager.Logger = logger
+}
+
 // CreateSession 为指定用户创建一个新的会话
 // userID: 用户的唯一标识符
+// role: 登录账号的角色，决定可访问的接口
+// ip, userAgent: 登录请求的来源信息，供管理界面展示
 // 返回值: 新创建的会话ID
-func (sm *SessionManager) CreateSession(userID string,sessionID string) string {
-	//sessionID := GenerateSessionID()
-	session := &Session{
-		UserID:    userID,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(24 * time.Hour), // 会话有效期为24小时
+func (sm *SessionManager) CreateSession(userID string, sessionID string, role Role, ip string, userAgent string) string {
+	now := sm.now()
+	session := Session{
+		UserID:            userID,
+		CreatedAt:         now,
+		ExpiresAt:         now.Add(sm.IdleTimeout),
+		AbsoluteExpiresAt: now.Add(sm.MaxLifetime),
+		IP:                ip,
+		UserAgent:         userAgent,
+		CSRFToken:         newCSRFToken(),
+		Role:              role,
+	}
+	if session.ExpiresAt.After(session.AbsoluteExpiresAt) {
+		session.ExpiresAt = session.AbsoluteExpiresAt
 	}
 
-	log.Println("创建新的Session")
-	sm.mutex.Lock()
-	sm.sessions[sessionID] = session
-	sm.mutex.Unlock()
+	key := sessionKey(sessionID)
+	sm.logger().Info("创建新的Session", "session", publicID(key), "user", userID, "role", role, "ip", ip)
+	if err := sm.store.Save(key, session); err != nil {
+		sm.logger().Error("保存Session失败", "session", publicID(key), "err", err)
+	}
 
 	return sessionID
 }
 
-// ValidateSession 验证会话是否有效
+// ValidateSession 验证会话是否有效，有效时顺延空闲过期时间
 // sessionID: 要验证的会话ID
 // 返回值: 如果会话有效返回true，否则返回false
 func (sm *SessionManager) ValidateSession(sessionID string) bool {
-	sm.mutex.RLock()
-	defer sm.mutex.RUnlock()
+	_, ok := sm.touch(sessionID)
+	return ok
+}
 
-	session, exists := sm.sessions[sessionID]
+// touch 读取会话并顺延空闲过期时间，过期的会话会被删除
+func (sm *SessionManager) touch(sessionID string) (Session, bool) {
+	key := sessionKey(sessionID)
+	session, exists, err := sm.store.Get(key)
+	if err != nil {
+		sm.logger().Error("读取Session失败", "session", publicID(key), "err", err)
+		return Session{}, false
+	}
 	if !exists {
-		log.Println("Session不存在")
-		return false
+		sm.logger().Debug("Session不存在", "session", publicID(key))
+		return Session{}, false
 	}
 
 	// 检查会话是否过期
-	if time.Now().After(session.ExpiresAt) {
-		delete(sm.sessions, sessionID)
-		log.Println("Session已过期")
-		return false
+	now := sm.now()
+	if session.expired(now) {
+		if err := sm.store.Delete(key); err != nil {
+			sm.logger().Error("删除过期Session失败", "session", publicID(key), "err", err)
+		}
+		sm.logger().Debug("Session已过期", "session", publicID(key), "user", session.UserID)
+		return Session{}, false
 	}
-	log.Println("Session有效") 
-	return true
+
+	// 顺延空闲过期时间，但不超过绝对过期时间
+	next := now.Add(sm.IdleTimeout)
+	if next.After(session.AbsoluteExpiresAt) {
+		next = session.AbsoluteExpiresAt
+	}
+	if next.Sub(session.ExpiresAt) >= slideInterval {
+		session.ExpiresAt = next
+		if err := sm.store.Save(key, session); err != nil {
+			sm.logger().Error("顺延Session失败", "session", publicID(key), "err", err)
+		}
+	}
+	return session, true
+}
+
+// EnsureCSRFToken 返回会话的CSRF令牌，旧会话没有令牌时补发一个
+// 返回值: 令牌及会话是否有效
+func (sm *SessionManager) EnsureCSRFToken(sessionID string) (string, bool) {
+	key := sessionKey(sessionID)
+	session, exists, err := sm.store.Get(key)
+	if err != nil || !exists || session.expired(sm.now()) {
+		return "", false
+	}
+	if session.CSRFToken == "" {
+		session.CSRFToken = newCSRFToken()
+		if err := sm.store.Save(key, session); err != nil {
+			sm.logger().Error("保存CSRF令牌失败", "session", publicID(key), "err", err)
+			return "", false
+		}
+	}
+	return session.CSRFToken, true
 }
 
 // RemoveSession 从会话管理器中移除指定的会话
 // sessionID: 要移除的会话ID
 func (sm *SessionManager) RemoveSession(sessionID string) {
-	sm.mutex.Lock()
-	delete(sm.sessions, sessionID)
-	sm.mutex.Unlock()
+	key := sessionKey(sessionID)
+	if err := sm.store.Delete(key); err != nil {
+		sm.logger().Error("删除Session失败", "session", publicID(key), "err", err)
+	}
+}
+
+// GetSession 返回有效会话的副本
+// sessionID: 会话ID
+// 返回值: 会话副本及是否存在且未过期
+func (sm *SessionManager) GetSession(sessionID string) (Session, bool) {
+	session, exists, err := sm.store.Get(sessionKey(sessionID))
+	if err != nil || !exists || session.expired(sm.now()) {
+		return Session{}, false
+	}
+	return session, true
+}
+
+// ListSessions 列出所有未过期的会话，按创建时间倒序
+// currentID: 发起请求的会话ID，用于标记当前会话
+func (sm *SessionManager) ListSessions(currentID string) []SessionInfo {
+	sessions, err := sm.store.List()
+	if err != nil {
+		sm.logger().Error("查询Session列表失败", "err", err)
+		return []SessionInfo{}
+	}
+
+	now := sm.now()
+	currentKey := sessionKey(currentID)
+	infos := make([]SessionInfo, 0, len(sessions))
+	for key, session := range sessions {
+		if session.expired(now) {
+			continue
+		}
+		infos = append(infos, SessionInfo{
+			ID:        publicID(key),
+			UserID:    session.UserID,
+			CreatedAt: session.CreatedAt,
+			ExpiresAt: session.ExpiresAt,
+			IP:        session.IP,
+			UserAgent: session.UserAgent,
+			Role:      session.Role,
+			Current:   key == currentKey,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].CreatedAt.After(infos[j].CreatedAt) })
+	return infos
+}
+
+// RevokeSession 按会话ID摘要吊销会话
+// id: PublicSessionID 计算出的摘要
+// 返回值: 是否找到并吊销
+func (sm *SessionManager) RevokeSession(id string) bool {
+	sessions, err := sm.store.List()
+	if err != nil {
+		sm.logger().Error("查询Session列表失败", "err", err)
+		return false
+	}
+
+	for key := range sessions {
+		if strings.HasPrefix(key, id) && publicID(key) == id {
+			if err := sm.store.Delete(key); err != nil {
+				sm.logger().Error("吊销Session失败", "session", id, "err", err)
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveUserSessions 移除指定用户的所有会话
+// 返回值: 被移除的会话数量
+func (sm *SessionManager) RemoveUserSessions(userID string) int {
+	sessions, err := sm.store.List()
+	if err != nil {
+		sm.logger().Error("查询Session列表失败", "err", err)
+		return 0
+	}
+
+	count := 0
+	for key, session := range sessions {
+		if session.UserID != userID {
+			continue
+		}
+		if err := sm.store.Delete(key); err != nil {
+			sm.logger().Error("删除Session失败", "session", publicID(key), "user", userID, "err", err)
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// SessionFromRequest 读取请求携带的会话
+// 返回值: 会话ID、会话副本及会话是否有效
+func SessionFromRequest(r *http.Request) (string, Session, bool) {
+	cookie, err := r.Cookie("sessionID")
+	if err != nil || cookie.Value == "" {
+		return "", Session{}, false
+	}
+	session, ok := GlobalSessionManager.GetSession(cookie.Value)
+	return cookie.Value, session, ok
+}
+
+// ClearSessionCookie 清除浏览器中的会话cookie
+func ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "sessionID",
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		Expires:  time.Unix(0, 0),
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:    csrfCookieName,
+		Value:   "",
+		Path:    "/",
+		Secure:  true,
+		Expires: time.Unix(0, 0),
+	})
 }
 
 // cleanupExpiredSessions 定期清理过期的会话
 // 该方法在后台协程中运行，每小时检查一次过期会话
-func (sm *SessionManager) cleanupExpiredSessions() {
+func cleanupExpiredSessions() {
 	for {
 		time.Sleep(1 * time.Hour) // 每小时执行一次清理
-		sm.mutex.Lock()
-		for id, session := range sm.sessions {
-			if time.Now().After(session.ExpiresAt) {
-				delete(sm.sessions, id)
-			}
+		sm := GlobalSessionManager
+		count, err := sm.store.DeleteExpired(sm.now())
+		if err != nil {
+			sm.logger().Error("清理过期Session失败", "err", err)
+			continue
+		}
+		if count > 0 {
+			sm.logger().Info("已清理过期Session", "count", count)
 		}
-		sm.mutex.Unlock()
 	}
 }
\ No newline at end of file
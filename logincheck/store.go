@@ -0,0 +1,149 @@
+package logincheck
+
+import (
+	"connection_server_linux/databasetool"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SessionStore 会话存储接口，key 为会话ID的SHA-256摘要
+type SessionStore interface {
+	// Save 保存会话，已存在则覆盖
+	Save(key string, session Session) error
+	// Get 读取会话，不存在时返回 exists=false
+	Get(key string) (session Session, exists bool, err error)
+	// Delete 删除会话，不存在时不报错
+	Delete(key string) error
+	// List 返回所有会话(包括已过期但尚未清理的)
+	List() (map[string]Session, error)
+	// DeleteExpired 删除在 now 之前过期的会话，返回删除数量
+	DeleteExpired(now time.Time) (int, error)
+}
+
+// MemoryStore 内存会话存储，进程重启后会话全部失效
+type MemoryStore struct {
+	sessions map[string]Session // 存储会话摘要到会话对象的映射
+	mutex    sync.RWMutex       // 用于保护会话map的读写锁
+}
+
+// NewMemoryStore 创建内存会话存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]Session)}
+}
+
+func (s *MemoryStore) Save(key string, session Session) error {
+	s.mutex.Lock()
+	s.sessions[key] = session
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) Get(key string) (Session, bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	session, exists := s.sessions[key]
+	return session, exists, nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mutex.Lock()
+	delete(s.sessions, key)
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) List() (map[string]Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	sessions := make(map[string]Session, len(s.sessions))
+	for key, session := range s.sessions {
+		sessions[key] = session
+	}
+	return sessions, nil
+}
+
+func (s *MemoryStore) DeleteExpired(now time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	count := 0
+	for key, session := range s.sessions {
+		if session.expired(now) {
+			delete(s.sessions, key)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// SQLiteStore 基于 AdminSession 表的会话存储，重启后会话保留，多个实例可共享同一数据库
+type SQLiteStore struct {
+	db *gorm.DB
+}
+
+// NewSQLiteStore 创建SQLite会话存储，db 需已完成 AdminSession 表迁移
+func NewSQLiteStore(db *gorm.DB) *SQLiteStore {
+	return &SQLiteStore{db: db}
+}
+
+func (s *SQLiteStore) Save(key string, session Session) error {
+	return databasetool.SaveAdminSession(s.db, &databasetool.AdminSession{
+		Key:               key,
+		UserID:            session.UserID,
+		CreatedAt:         session.CreatedAt,
+		ExpiresAt:         session.ExpiresAt,
+		AbsoluteExpiresAt: session.AbsoluteExpiresAt,
+		Ip:                session.IP,
+		UserAgent:         session.UserAgent,
+		CsrfToken:         session.CSRFToken,
+		Role:              string(session.Role),
+	})
+}
+
+func (s *SQLiteStore) Get(key string) (Session, bool, error) {
+	record, err := databasetool.FindAdminSession(s.db, key)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Session{}, false, nil
+	}
+	if err != nil {
+		return Session{}, false, err
+	}
+	return sessionFromRecord(record), true, nil
+}
+
+func (s *SQLiteStore) Delete(key string) error {
+	return databasetool.DeleteAdminSession(s.db, key)
+}
+
+func (s *SQLiteStore) List() (map[string]Session, error) {
+	records, err := databasetool.ListAdminSessions(s.db)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make(map[string]Session, len(records))
+	for i := range records {
+		sessions[records[i].Key] = sessionFromRecord(&records[i])
+	}
+	return sessions, nil
+}
+
+func (s *SQLiteStore) DeleteExpired(now time.Time) (int, error) {
+	count, err := databasetool.DeleteExpiredAdminSessions(s.db, now)
+	return int(count), err
+}
+
+// sessionFromRecord 将数据库记录转换为会话
+func sessionFromRecord(record *databasetool.AdminSession) Session {
+	return Session{
+		UserID:            record.UserID,
+		CreatedAt:         record.CreatedAt,
+		ExpiresAt:         record.ExpiresAt,
+		AbsoluteExpiresAt: record.AbsoluteExpiresAt,
+		IP:                record.Ip,
+		UserAgent:         record.UserAgent,
+		CSRFToken:         record.CsrfToken,
+		Role:              Role(record.Role),
+	}
+}
@@ -0,0 +1,208 @@
+package logincheck
+
+import (
+	"connection_server_linux/databasetool"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// storeCase 一种会话存储，reopen 模拟进程重启后重新打开存储
+type storeCase struct {
+	name       string
+	persistent bool // 重启后会话是否保留
+	open       func(t *testing.T) (store SessionStore, reopen func() SessionStore)
+}
+
+var storeCases = []storeCase{
+	{"memory", false, func(t *testing.T) (SessionStore, func() SessionStore) {
+		return NewMemoryStore(), func() SessionStore { return NewMemoryStore() }
+	}},
+	{"sqlite", true, func(t *testing.T) (SessionStore, func() SessionStore) {
+		path := filepath.Join(t.TempDir(), "sessions.db")
+		open := func() SessionStore {
+			db, err := databasetool.OpenDB(path)
+			if err != nil {
+				t.Fatalf("打开测试数据库失败: %v", err)
+			}
+			t.Cleanup(func() {
+				if sqlDB, err := db.DB(); err == nil {
+					sqlDB.Close()
+				}
+			})
+			return NewSQLiteStore(db)
+		}
+		return open(), open
+	}},
+}
+
+// forEachStore 对每种存储运行测试，会话管理器使用手动拨动的时钟
+func forEachStore(t *testing.T, test func(t *testing.T, sc storeCase, sm *SessionManager, clock *fakeClock, reopen func() SessionStore)) {
+	for _, sc := range storeCases {
+		t.Run(sc.name, func(t *testing.T) {
+			store, reopen := sc.open(t)
+			clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+			sm := NewSessionManager(store, time.Hour, 3*time.Hour)
+			sm.now = clock.now
+			test(t, sc, sm, clock, reopen)
+		})
+	}
+}
+
+func TestSessionSlidingExpiry(t *testing.T) {
+	forEachStore(t, func(t *testing.T, sc storeCase, sm *SessionManager, clock *fakeClock, _ func() SessionStore) {
+		start := clock.t
+		sm.CreateSession("root", "sid", RoleAdmin, "127.0.0.1", "test")
+
+		// 访问间隔小于顺延间隔时不写存储
+		clock.add(30 * time.Second)
+		if !sm.ValidateSession("sid") {
+			t.Fatal("新会话无效")
+		}
+		if s, _ := sm.GetSession("sid"); !s.ExpiresAt.Equal(start.Add(time.Hour)) {
+			t.Fatalf("不足顺延间隔时过期时间 = %v, 期望不变", s.ExpiresAt)
+		}
+
+		// 每次访问顺延空闲过期时间，超过原来的空闲超时仍然有效
+		clock.add(30 * time.Minute)
+		if !sm.ValidateSession("sid") {
+			t.Fatal("空闲超时内访问无效")
+		}
+		if s, _ := sm.GetSession("sid"); !s.ExpiresAt.Equal(clock.t.Add(time.Hour)) {
+			t.Fatalf("顺延后过期时间 = %v, 期望 %v", s.ExpiresAt, clock.t.Add(time.Hour))
+		}
+		clock.add(50 * time.Minute)
+		if !sm.ValidateSession("sid") {
+			t.Fatal("顺延后的空闲超时内访问无效")
+		}
+
+		// 空闲超过超时后失效并从存储中删除
+		clock.add(time.Hour + time.Second)
+		if sm.ValidateSession("sid") {
+			t.Fatal("空闲超时后会话仍有效")
+		}
+		if _, exists, _ := sm.store.Get(sessionKey("sid")); exists {
+			t.Fatal("过期会话未从存储中删除")
+		}
+	})
+}
+
+func TestSessionMaxLifetime(t *testing.T) {
+	forEachStore(t, func(t *testing.T, sc storeCase, sm *SessionManager, clock *fakeClock, _ func() SessionStore) {
+		start := clock.t
+		sm.CreateSession("root", "sid", RoleAdmin, "127.0.0.1", "test")
+
+		// 持续访问也不能超过绝对有效期，顺延到绝对过期时间为止
+		for clock.t.Before(start.Add(3 * time.Hour)) {
+			if !sm.ValidateSession("sid") {
+				t.Fatalf("%v 时会话无效", clock.t.Sub(start))
+			}
+			s, _ := sm.GetSession("sid")
+			if s.ExpiresAt.After(start.Add(3 * time.Hour)) {
+				t.Fatalf("空闲过期时间 %v 超过绝对过期时间", s.ExpiresAt)
+			}
+			clock.add(20 * time.Minute)
+		}
+		clock.t = start.Add(3*time.Hour + time.Second)
+		if sm.ValidateSession("sid") {
+			t.Fatal("超过绝对有效期后会话仍有效")
+		}
+
+		// 清理任务按同样的规则删除
+		sm.CreateSession("root", "idle", RoleAdmin, "127.0.0.1", "test")
+		sm.CreateSession("root", "fresh", RoleAdmin, "127.0.0.1", "test")
+		clock.add(59 * time.Minute)
+		sm.ValidateSession("fresh")
+		clock.add(2 * time.Minute)
+		if count, err := sm.store.DeleteExpired(clock.t); err != nil || count != 1 {
+			t.Fatalf("DeleteExpired = %d, %v, 期望删除1个", count, err)
+		}
+		if !sm.ValidateSession("fresh") {
+			t.Fatal("清理后顺延过的会话无效")
+		}
+	})
+}
+
+func TestSessionRevocation(t *testing.T) {
+	forEachStore(t, func(t *testing.T, sc storeCase, sm *SessionManager, clock *fakeClock, _ func() SessionStore) {
+		sm.CreateSession("root", "root-1", RoleAdmin, "10.0.0.1", "a")
+		sm.CreateSession("root", "root-2", RoleAdmin, "10.0.0.2", "b")
+		sm.CreateSession("ops", "ops-1", RoleOperator, "10.0.0.3", "c")
+		sm.CreateSession("ops", "ops-2", RoleOperator, "10.0.0.4", "d")
+
+		list := sm.ListSessions("root-1")
+		if len(list) != 4 {
+			t.Fatalf("ListSessions 返回 %d 个, 期望 4", len(list))
+		}
+		for _, info := range list {
+			if info.Current != (info.ID == PublicSessionID("root-1")) {
+				t.Fatalf("当前会话标记错误: %+v", info)
+			}
+			if len(info.ID) != 16 {
+				t.Fatalf("会话ID应为16位摘要: %q", info.ID)
+			}
+		}
+
+		if !sm.RevokeSession(PublicSessionID("ops-1")) {
+			t.Fatal("RevokeSession 未找到会话")
+		}
+		if sm.RevokeSession(PublicSessionID("ops-1")) {
+			t.Fatal("重复吊销应返回 false")
+		}
+		if sm.ValidateSession("ops-1") || !sm.ValidateSession("ops-2") {
+			t.Fatal("吊销影响了错误的会话")
+		}
+
+		sm.RemoveSession("ops-2")
+		if sm.ValidateSession("ops-2") {
+			t.Fatal("退出登录后会话仍有效")
+		}
+
+		if n := sm.RemoveUserSessions("root"); n != 2 {
+			t.Fatalf("RemoveUserSessions 移除 %d 个, 期望 2", n)
+		}
+		if list := sm.ListSessions(""); len(list) != 0 {
+			t.Fatalf("全部移除后仍有会话: %+v", list)
+		}
+	})
+}
+
+func TestSessionSurvivesRestart(t *testing.T) {
+	forEachStore(t, func(t *testing.T, sc storeCase, sm *SessionManager, clock *fakeClock, reopen func() SessionStore) {
+		sm.CreateSession("root", "sid", RoleOperator, "10.0.0.1", "browser")
+		clock.add(10 * time.Minute)
+		if !sm.ValidateSession("sid") {
+			t.Fatal("新会话无效")
+		}
+		before, _ := sm.GetSession("sid")
+		token, _ := sm.EnsureCSRFToken("sid")
+
+		restarted := NewSessionManager(reopen(), time.Hour, 3*time.Hour)
+		restarted.now = clock.now
+		after, ok := restarted.GetSession("sid")
+		if !sc.persistent {
+			if ok {
+				t.Fatal("内存存储重启后会话不应保留")
+			}
+			return
+		}
+		if !ok {
+			t.Fatal("重启后会话丢失")
+		}
+		if after.UserID != "root" || after.Role != RoleOperator || after.IP != "10.0.0.1" || after.UserAgent != "browser" {
+			t.Fatalf("重启后会话内容 = %+v", after)
+		}
+		if !after.ExpiresAt.Equal(before.ExpiresAt) || !after.AbsoluteExpiresAt.Equal(before.AbsoluteExpiresAt) {
+			t.Fatalf("重启后过期时间 = %v/%v, 期望 %v/%v", after.ExpiresAt, after.AbsoluteExpiresAt, before.ExpiresAt, before.AbsoluteExpiresAt)
+		}
+		if got, _ := restarted.EnsureCSRFToken("sid"); got != token || token == "" {
+			t.Fatalf("重启后CSRF令牌 = %q, 期望 %q", got, token)
+		}
+
+		// 吊销后再次重启，会话仍然无效
+		restarted.RemoveSession("sid")
+		if NewSessionManager(reopen(), 0, 0).ValidateSession("sid") {
+			t.Fatal("吊销的会话重启后恢复了")
+		}
+	})
+}
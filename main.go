@@ -2,46 +2,128 @@ package main
 
 import (
 	//"fmt"
+	"context"
 	"crypto/tls"
+	"errors"
+	"flag"
 	"github.com/gorilla/mux"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 //	"connection_server_linux/inittool"
+	"connection_server_linux/config"
 	"connection_server_linux/tcpnetwork"
 	"connection_server_linux/router"
 	"connection_server_linux/databasetool"
+	"connection_server_linux/logging"
+	"connection_server_linux/logincheck"
+	"connection_server_linux/metrics"
+	"connection_server_linux/sdnotify"
 	"gorm.io/gorm"
 )
 
 // 全局数据库连接对象
 var DB *gorm.DB
 
+// fatal 记录错误并退出
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// runWatchdog 在systemd启用看门狗(WatchdogSec)时定期执行存活检查，
+// 只有检查通过才发送心跳，持续不健康时由systemd重启服务。
+// 数据库或存储暂时不可用只影响就绪，重启进程并不能恢复，不应停止心跳
+func runWatchdog(ctx context.Context, chat *tcpnetwork.Server) {
+	interval := sdnotify.WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report := chat.CheckHealth(ctx, false)
+			if !report.Healthy() {
+				slog.Warn("健康检查未通过，暂停看门狗心跳", "checks", report.Checks)
+				continue
+			}
+			if err := sdnotify.Notify(sdnotify.Watchdog); err != nil {
+				slog.Warn("发送看门狗心跳失败", "err", err)
+			}
+		}
+	}
+}
+
 func main() {
+	// 读取配置：默认值 < 配置文件 < 环境变量 < 命令行参数
+	cfg, err := config.Load(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("加载配置失败", err)
+	}
+
+	// 初始化日志
+	logger, err := logging.Setup(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		fatal("初始化日志失败", err)
+	}
+
 	// 初始化数据库连接
-	DB = databasetool.InitDB()
+	DB = databasetool.InitDB(cfg.Database, databasetool.PoolConfig{
+		MaxIdleConns:    cfg.DBMaxIdleConns,
+		MaxOpenConns:    cfg.DBMaxOpenConns,
+		ConnMaxLifetime: time.Duration(cfg.DBConnMaxLifetime),
+	})
+	// 刚启动时不会有在线用户，上次异常退出遗留的在线状态全部重置
+	if count, err := databasetool.ResetStaleOnline(DB, time.Now()); err != nil {
+		fatal("重置用户在线状态失败", err)
+	} else if count > 0 {
+		slog.Warn("上次未正常关闭，已重置遗留的在线状态", "count", count)
+	}
+	// 管理后台会话保存在数据库中，重启后无需重新登录
+	logincheck.InitSessionManager(logincheck.NewSQLiteStore(DB), time.Duration(cfg.SessionIdleTimeout), time.Duration(cfg.SessionMaxLifetime), logger.With("component", "session"))
 	
 	// 启动TCP服务器
 	//localIP := inittool.GetLocalIP()
-	var err error
 
-	tcpnetwork.TcpAddr, err = net.ResolveTCPAddr("tcp4", "0.0.0.0:12345")
+	chat, err := tcpnetwork.NewServer(tcpnetwork.Options{
+		DB:            DB,
+		StorageDir:    cfg.FileStorage,
+		HTTPSPort:     cfg.HTTPSPort(),
+		FileTTL:       time.Duration(cfg.FileTTL),
+		MaxGoroutines: cfg.HealthMaxGoroutines,
+	})
 	if err != nil {
-		log.Fatal(err)
+		fatal("初始化聊天服务器失败", err)
 	}
 
-	tcpListener, err := net.ListenTCP("tcp", tcpnetwork.TcpAddr)
+	tcpListener, err := net.Listen("tcp4", cfg.TCPAddr)
 	if err != nil {
-		log.Fatal(err)
+		fatal("TCP服务器启动失败", err)
 	}
-	defer tcpListener.Close()
 
-	log.Printf("TCP服务器已启动，监听地址：%s", tcpnetwork.TcpAddr)
+	slog.Info("TCP服务器已启动", "addr", tcpListener.Addr().String())
+
+	// /metrics 的抓取令牌，未设置时只允许本机抓取
+	metrics.Token = cfg.MetricsToken
+	if cfg.MetricsToken == "" {
+		slog.Warn("未设置 metrics_token，/metrics 只允许本机抓取", "https_addr", cfg.HTTPSAddr)
+	}
 
 	// 启动HTTPS服务器
 	route := mux.NewRouter()
 	// 设置路由
-	router.SetupRoutes(route)
+	router.SetupRoutes(route, chat)
 
 	// 配置TLS
 	tlsConfig := &tls.Config{
@@ -58,29 +140,59 @@ func main() {
 
 	// 创建HTTPS服务器
 	srv := &http.Server{
-		Addr:         ":8443",
+		Addr:         cfg.HTTPSAddr,
 		Handler:      route,
 		TLSConfig:    tlsConfig,
 		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0),
 	}
 
+	// 关闭时结束所有SSE长连接，否则 Shutdown 会一直等待它们
+	srv.RegisterOnShutdown(chat.Events().Close)
+
+	// 收到 SIGINT/SIGTERM 时开始优雅关闭
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 2)
 	go func() {
-		log.Printf("HTTPS服务器已启动，监听地址：:8443")
-		if err := srv.ListenAndServeTLS("certs/server.crt", "certs/server.key"); err != nil {
-			log.Fatal(err)
-		}
+		slog.Info("HTTPS服务器已启动", "addr", srv.Addr)
+		serveErr <- srv.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+	}()
+	// 处理TCP连接
+	go func() {
+		serveErr <- chat.Serve(tcpListener)
 	}()
 
-	tcpnetwork.InitDBConnection(DB)
+	if err := sdnotify.Notify(sdnotify.Ready); err != nil {
+		slog.Warn("通知systemd启动完成失败", "err", err)
+	}
+	go runWatchdog(ctx, chat)
 
-	// 处理TCP连接
-	for {
-		conn, err := tcpListener.Accept()
-		if err != nil {
-			log.Printf("接受连接失败: %v", err)
-			continue
-		}
-		go tcpnetwork.HandleConnection(conn)
-		
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		slog.Info("收到退出信号，开始关闭服务器")
+	case err := <-serveErr:
+		slog.Error("服务异常退出，开始关闭服务器", "err", err)
+		exitCode = 1
+	}
+	// 关闭过程中再次收到信号时按默认行为直接退出
+	stop()
+	sdnotify.Notify(sdnotify.Stopping)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+
+	if err := chat.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("TCP服务关闭未完成", "err", err)
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("HTTPS服务器关闭超时，强制关闭", "err", err)
+		srv.Close()
+	}
+	if err := chat.Close(); err != nil {
+		slog.Warn("关闭数据库失败", "err", err)
 	}
+	slog.Info("服务器已关闭")
+	os.Exit(exitCode)
 }
\ No newline at end of file
@@ -0,0 +1,379 @@
+// Package metrics 实现Prometheus文本格式的监控指标，供 /metrics 接口抓取
+// 只实现了服务器用到的计数器、按标签分组的计数器、抓取时计算的仪表盘和直方图
+package metrics
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefBuckets 默认的耗时直方图分桶，单位秒
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector 可以输出为Prometheus文本格式的指标
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry 指标注册表，按注册顺序输出
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+	names      map[string]bool
+}
+
+// Default 全局指标注册表，New* 系列函数创建的指标都注册在这里
+var Default = NewRegistry()
+
+// NewRegistry 创建指标注册表
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register 注册指标，重名属于编程错误，直接panic
+func (reg *Registry) register(c collector) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.names[c.name()] {
+		panic(fmt.Sprintf("指标 %s 重复注册", c.name()))
+	}
+	reg.names[c.name()] = true
+	reg.collectors = append(reg.collectors, c)
+}
+
+// WriteTo 以Prometheus文本格式输出所有指标
+func (reg *Registry) WriteTo(w *bufio.Writer) {
+	reg.mu.Lock()
+	collectors := make([]collector, len(reg.collectors))
+	copy(collectors, reg.collectors)
+	reg.mu.Unlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Token 抓取 /metrics 需要携带的令牌，为空时只允许本机抓取
+var Token string
+
+// Handler 输出全局注册表的HTTP处理函数
+// 设置了 Token 时要求请求携带 Authorization: Bearer <Token>；
+// 未设置时拒绝来自其他主机的请求，指标中的路由、在线人数等信息不应在公网上公开
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if Token == "" && !loopback(r) {
+		http.Error(w, "未设置 metrics_token 时只允许本机抓取", http.StatusForbidden)
+		return
+	}
+	if Token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+Token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	Default.WriteTo(bw)
+	bw.Flush()
+}
+
+// loopback 判断请求是否来自本机
+func loopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// writeHeader 输出指标的 HELP 和 TYPE 行
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatLabels 生成 {a="1",b="2"} 形式的标签，extra 为追加的 le 等标签
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	pairs := append(append([]string{}, interleave(names, values)...), extra...)
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// interleave 将标签名和值交错排列
+func interleave(names, values []string) []string {
+	pairs := make([]string, 0, 2*len(names))
+	for i := range names {
+		pairs = append(pairs, names[i], values[i])
+	}
+	return pairs
+}
+
+// formatFloat 按Prometheus的约定输出浮点数
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter 只增不减的计数器
+type Counter struct {
+	value uint64
+}
+
+// Inc 计数加一
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add 计数增加n
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Value 当前计数
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// vec 按标签值分组的子指标
+type vec[T any] struct {
+	labels   []string
+	mu       sync.RWMutex
+	children map[string]*T
+	values   map[string][]string
+	newChild func() *T
+}
+
+func newVec[T any](labels []string, newChild func() *T) vec[T] {
+	return vec[T]{
+		labels:   labels,
+		children: make(map[string]*T),
+		values:   make(map[string][]string),
+		newChild: newChild,
+	}
+}
+
+// with 查找或创建标签值对应的子指标，标签值数量不符属于编程错误
+func (v *vec[T]) with(values ...string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("标签数量不匹配: 需要 %d 个，传入 %d 个", len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	child, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return child
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if child, ok := v.children[key]; ok {
+		return child
+	}
+	child = v.newChild()
+	v.children[key] = child
+	v.values[key] = append([]string{}, values...)
+	return child
+}
+
+// each 按标签值排序遍历子指标，保证输出稳定
+func (v *vec[T]) each(fn func(values []string, child *T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.mu.RLock()
+		child, values := v.children[key], v.values[key]
+		v.mu.RUnlock()
+		fn(values, child)
+	}
+}
+
+// CounterVec 按标签分组的计数器
+type CounterVec struct {
+	metricName string
+	help       string
+	vec        vec[Counter]
+}
+
+// NewCounter 创建并注册一个不带标签的计数器
+func NewCounter(name, help string) *Counter {
+	return NewCounterVec(name, help).WithLabelValues()
+}
+
+// NewCounterVec 创建并注册按标签分组的计数器
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		metricName: name,
+		help:       help,
+		vec:        newVec(labels, func() *Counter { return &Counter{} }),
+	}
+	Default.register(c)
+	return c
+}
+
+// WithLabelValues 返回标签值对应的计数器，标签值应来自有限的集合
+func (c *CounterVec) WithLabelValues(values ...string) *Counter {
+	return c.vec.with(values...)
+}
+
+func (c *CounterVec) name() string {
+	return c.metricName
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, c.metricName, c.help, "counter")
+	c.vec.each(func(values []string, child *Counter) {
+		fmt.Fprintf(w, "%s%s %d\n", c.metricName, formatLabels(c.vec.labels, values), child.Value())
+	})
+}
+
+// GaugeFunc 抓取时调用函数取值的仪表盘，适合在线人数、积压消息数等当前状态
+type GaugeFunc struct {
+	metricName string
+	help       string
+	fn         func() float64
+}
+
+// NewGaugeFunc 创建并注册仪表盘
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, fn: fn}
+	Default.register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string {
+	return g.metricName
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.fn()))
+}
+
+// Histogram 直方图，用于统计耗时分布
+type Histogram struct {
+	upperBounds []float64
+	mu          sync.Mutex
+	counts      []uint64 // 每个分桶内(非累计)的观测次数，最后一个为 +Inf
+	count       uint64
+	sum         float64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		upperBounds: buckets,
+		counts:      make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe 记录一次观测值
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// ObserveSince 记录从 start 到现在经过的秒数
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// snapshot 返回累计的分桶计数、总次数和总和
+func (h *Histogram) snapshot() ([]uint64, uint64, float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cumulative := make([]uint64, len(h.counts))
+	var total uint64
+	for i, n := range h.counts {
+		total += n
+		cumulative[i] = total
+	}
+	return cumulative, h.count, h.sum
+}
+
+// HistogramVec 按标签分组的直方图
+type HistogramVec struct {
+	metricName string
+	help       string
+	buckets    []float64
+	vec        vec[Histogram]
+}
+
+// NewHistogramVec 创建并注册按标签分组的直方图，buckets 需按升序排列
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("直方图 %s 的分桶必须升序排列", name))
+	}
+	h := &HistogramVec{
+		metricName: name,
+		help:       help,
+		buckets:    buckets,
+	}
+	h.vec = newVec(labels, func() *Histogram { return newHistogram(buckets) })
+	Default.register(h)
+	return h
+}
+
+// WithLabelValues 返回标签值对应的直方图，标签值应来自有限的集合
+func (h *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return h.vec.with(values...)
+}
+
+func (h *HistogramVec) name() string {
+	return h.metricName
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	writeHeader(w, h.metricName, h.help, "histogram")
+	h.vec.each(func(values []string, child *Histogram) {
+		cumulative, count, sum := child.snapshot()
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(h.vec.labels, values, "le", formatFloat(bound)), cumulative[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(h.vec.labels, values, "le", "+Inf"), cumulative[len(h.buckets)])
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, formatLabels(h.vec.labels, values), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, formatLabels(h.vec.labels, values), count)
+	})
+}
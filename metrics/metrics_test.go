@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useRegistry 让 New* 注册到新的注册表，测试结束后恢复
+func useRegistry(t *testing.T) *Registry {
+	t.Helper()
+	saved := Default
+	Default = NewRegistry()
+	t.Cleanup(func() { Default = saved })
+	return Default
+}
+
+func render(reg *Registry) string {
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	reg.WriteTo(w)
+	w.Flush()
+	return b.String()
+}
+
+func TestTextExposition(t *testing.T) {
+	reg := useRegistry(t)
+
+	NewCounter("test_connections_total", "连接总数").Add(3)
+	logins := NewCounterVec("test_logins_total", "登录次数，按结果分组", "result")
+	logins.WithLabelValues("success").Inc()
+	logins.WithLabelValues("bad_password").Add(2)
+	logins.WithLabelValues("success").Inc()
+	NewCounterVec("test_unused_total", "没有任何子指标")
+	paths := NewCounterVec("test_paths_total", "多行\n帮助 \\ 文本", "path", "method")
+	paths.WithLabelValues(`/a"b\c`+"\n", "GET").Inc()
+	NewGaugeFunc("test_online", "在线人数", func() float64 { return 42 })
+	NewGaugeFunc("test_ratio", "比例", func() float64 { return 0.25 })
+	NewGaugeFunc("test_inf", "无穷", func() float64 { return math.Inf(1) })
+
+	const want = `# HELP test_connections_total 连接总数
+# TYPE test_connections_total counter
+test_connections_total 3
+# HELP test_logins_total 登录次数，按结果分组
+# TYPE test_logins_total counter
+test_logins_total{result="bad_password"} 2
+test_logins_total{result="success"} 2
+# HELP test_unused_total 没有任何子指标
+# TYPE test_unused_total counter
+# HELP test_paths_total 多行\n帮助 \\ 文本
+# TYPE test_paths_total counter
+test_paths_total{path="/a\"b\\c\n",method="GET"} 1
+# HELP test_online 在线人数
+# TYPE test_online gauge
+test_online 42
+# HELP test_ratio 比例
+# TYPE test_ratio gauge
+test_ratio 0.25
+# HELP test_inf 无穷
+# TYPE test_inf gauge
+test_inf +Inf
+`
+	if got := render(reg); got != want {
+		t.Fatalf("输出不一致\n--- 得到 ---\n%s--- 期望 ---\n%s", got, want)
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	reg := useRegistry(t)
+
+	h := NewHistogramVec("test_duration_seconds", "耗时", []float64{0.125, 1}, "handler")
+	// 分桶上限是包含的：等于上限的观测值计入该桶
+	for _, v := range []float64{0.0625, 0.125, 0.5, 1, 2} {
+		h.WithLabelValues("message").Observe(v)
+	}
+	h.WithLabelValues("file").Observe(0.01)
+	NewHistogramVec("test_empty_seconds", "没有观测值", DefBuckets)
+
+	const want = `# HELP test_duration_seconds 耗时
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{handler="file",le="0.125"} 1
+test_duration_seconds_bucket{handler="file",le="1"} 1
+test_duration_seconds_bucket{handler="file",le="+Inf"} 1
+test_duration_seconds_sum{handler="file"} 0.01
+test_duration_seconds_count{handler="file"} 1
+test_duration_seconds_bucket{handler="message",le="0.125"} 2
+test_duration_seconds_bucket{handler="message",le="1"} 4
+test_duration_seconds_bucket{handler="message",le="+Inf"} 5
+test_duration_seconds_sum{handler="message"} 3.6875
+test_duration_seconds_count{handler="message"} 5
+# HELP test_empty_seconds 没有观测值
+# TYPE test_empty_seconds histogram
+`
+	if got := render(reg); got != want {
+		t.Fatalf("输出不一致\n--- 得到 ---\n%s--- 期望 ---\n%s", got, want)
+	}
+}
+
+func TestRegistryPanics(t *testing.T) {
+	useRegistry(t)
+	mustPanic := func(name string, fn func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s 应当panic", name)
+			}
+		}()
+		fn()
+	}
+
+	NewCounter("test_total", "计数")
+	mustPanic("重复注册", func() { NewCounter("test_total", "计数") })
+	mustPanic("分桶未排序", func() { NewHistogramVec("test_seconds", "耗时", []float64{1, 0.5}) })
+	vec := NewCounterVec("test_labeled_total", "计数", "a", "b")
+	mustPanic("标签数量不符", func() { vec.WithLabelValues("x") })
+}
+
+func TestHandler(t *testing.T) {
+	useRegistry(t)
+	NewCounter("test_total", "计数").Inc()
+	saved := Token
+	t.Cleanup(func() { Token = saved })
+
+	cases := []struct {
+		name   string
+		token  string // 服务器配置的令牌
+		remote string
+		auth   string
+		want   int
+	}{
+		{"未设置令牌时本机IPv4", "", "127.0.0.1:50000", "", http.StatusOK},
+		{"未设置令牌时本机IPv6", "", "[::1]:50000", "", http.StatusOK},
+		{"未设置令牌时其他主机", "", "203.0.113.5:50000", "", http.StatusForbidden},
+		{"未设置令牌时局域网主机", "", "192.168.1.20:50000", "", http.StatusForbidden},
+		{"缺少令牌", "secret", "127.0.0.1:50000", "", http.StatusUnauthorized},
+		{"令牌错误", "secret", "203.0.113.5:50000", "Bearer wrong", http.StatusUnauthorized},
+		{"令牌缺少Bearer前缀", "secret", "203.0.113.5:50000", "secret", http.StatusUnauthorized},
+		{"令牌正确", "secret", "203.0.113.5:50000", "Bearer secret", http.StatusOK},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			Token = c.token
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			r.RemoteAddr = c.remote
+			if c.auth != "" {
+				r.Header.Set("Authorization", c.auth)
+			}
+			w := httptest.NewRecorder()
+			Handler(w, r)
+			if w.Code != c.want {
+				t.Fatalf("状态码 = %d, 期望 %d", w.Code, c.want)
+			}
+			if c.want == http.StatusOK && !strings.Contains(w.Body.String(), "test_total 1\n") {
+				t.Fatalf("响应缺少指标: %s", w.Body.String())
+			}
+			if c.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("401 响应缺少 WWW-Authenticate")
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package metrics
+
+//Go运行时指标
+import (
+	"runtime"
+	"time"
+)
+
+var startTime = time.Now()
+
+func init() {
+	NewGaugeFunc("go_goroutines", "当前的goroutine数量", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	NewGaugeFunc("go_memstats_heap_alloc_bytes", "堆上已分配且仍在使用的字节数", func() float64 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return float64(stats.HeapAlloc)
+	})
+	NewGaugeFunc("process_start_time_seconds", "进程启动时间，Unix时间戳", func() float64 {
+		return float64(startTime.UnixNano()) / 1e9
+	})
+}
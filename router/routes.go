@@ -3,24 +3,83 @@ package router
 import (
 	"github.com/gorilla/mux"
 	"net/http"
+	"connection_server_linux/logging"
 	"connection_server_linux/logincheck"
+	"connection_server_linux/metrics"
 	"connection_server_linux/tcpnetwork"
 )
 
-// setupRoutes 配置所有HTTP路由
-func SetupRoutes(router *mux.Router) {
+// apiRoute 管理后台接口及访问所需的最低角色
+type apiRoute struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+	role    logincheck.Role
+}
 
+// apiRoutes 所有管理后台接口的权限配置
+// viewer 只读；operator 可以踢出客户端和发送消息；admin 管理账号、封禁、会话和审计
+func apiRoutes(chat *tcpnetwork.Server) []apiRoute {
+	return []apiRoute{
+		{"GET", "/me", tcpnetwork.MeHandler, logincheck.RoleViewer},
+		{"GET", "/server-info", chat.GetServerInfoHandler, logincheck.RoleViewer},
+		{"GET", "/clients", chat.GetClientsHandler, logincheck.RoleViewer},
+		{"GET", "/events", chat.EventsHandler, logincheck.RoleViewer},
+		{"GET", "/users", chat.ListUsersHandler, logincheck.RoleViewer},
+		{"GET", "/users/{id}", chat.GetUserHandler, logincheck.RoleViewer},
+		{"GET", "/bans", chat.GetBansHandler, logincheck.RoleViewer},
+		{"POST", "/logout-all", chat.LogoutAllHandler, logincheck.RoleViewer},
+
+		{"POST", "/clients/{id}/kick", chat.KickClientHandler, logincheck.RoleOperator},
+		{"POST", "/clients/{id}/message", chat.SendMessageHandler, logincheck.RoleOperator},
+		{"POST", "/broadcast", chat.BroadcastHandler, logincheck.RoleOperator},
+
+		{"GET", "/sessions", tcpnetwork.GetSessionsHandler, logincheck.RoleAdmin},
+		{"POST", "/sessions/{id}/revoke", tcpnetwork.RevokeSessionHandler, logincheck.RoleAdmin},
+		{"GET", "/lockouts", chat.GetLockoutsHandler, logincheck.RoleAdmin},
+		{"POST", "/lockouts/unlock", chat.UnlockHandler, logincheck.RoleAdmin},
+		{"DELETE", "/users/{id}", chat.DeleteUserHandler, logincheck.RoleAdmin},
+		{"POST", "/users/{id}/password", chat.ResetPasswordHandler, logincheck.RoleAdmin},
+		{"POST", "/users/{id}/rename", chat.RenameUserHandler, logincheck.RoleAdmin},
+		{"POST", "/users/{id}/disable", chat.DisableUserHandler, logincheck.RoleAdmin},
+		{"POST", "/users/{id}/enable", chat.EnableUserHandler, logincheck.RoleAdmin},
+		{"POST", "/bans", chat.CreateBanHandler, logincheck.RoleAdmin},
+		{"DELETE", "/bans/{id}", chat.DeleteBanHandler, logincheck.RoleAdmin},
+		{"GET", "/audit", chat.AuditHandler, logincheck.RoleAdmin},
+		{"GET", "/admins", chat.ListAdminsHandler, logincheck.RoleAdmin},
+		{"POST", "/admins", chat.CreateAdminHandler, logincheck.RoleAdmin},
+		{"POST", "/admins/{name}/role", chat.UpdateAdminRoleHandler, logincheck.RoleAdmin},
+		{"POST", "/admins/{name}/password", chat.ResetAdminPasswordHandler, logincheck.RoleAdmin},
+		{"DELETE", "/admins/{name}", chat.DeleteAdminHandler, logincheck.RoleAdmin},
+	}
+}
+
+// setupRoutes 配置所有HTTP路由，chat 为管理后台操作的聊天服务器
+func SetupRoutes(router *mux.Router, chat *tcpnetwork.Server) {
+
+	// 为每个请求分配ID并放入日志记录器，后面的中间件和处理函数都能取到
+	router.Use(logging.Middleware)
+	// 统计所有请求的耗时，放在最外层以包含鉴权被拒绝的请求
+	router.Use(tcpnetwork.MetricsMiddleware)
 	router.Use(logincheck.AuthMiddleware)
+	// 所有 /api 下的非GET请求需携带CSRF令牌
+	router.Use(logincheck.CSRFMiddleware)
 	// 登录路由
-	router.HandleFunc("/api/login", tcpnetwork.LoginHandler).Methods("POST")
+	router.HandleFunc("/api/login", chat.LoginHandler).Methods("POST")
+	router.HandleFunc("/api/logout", chat.LogoutHandler).Methods("POST")
+	// 监控指标(Prometheus文本格式)
+	router.HandleFunc("/metrics", metrics.Handler).Methods("GET")
+	// 健康检查(无需登录)
+	router.HandleFunc("/healthz", chat.HealthzHandler).Methods("GET", "HEAD")
+	router.HandleFunc("/readyz", chat.ReadyzHandler).Methods("GET", "HEAD")
+	// 文件下载路由(由链接签名鉴权)
+	router.HandleFunc("/files/{transferid}", chat.DownloadFileHandler).Methods("GET", "HEAD")
 	
-	// API路由
+	// API路由，每条路由只允许不低于指定角色的管理员访问
 	apiRouter := router.PathPrefix("/api").Subrouter()
-	
-	apiRouter.HandleFunc("/server-info", tcpnetwork.GetServerInfoHandler).Methods("GET")
-	apiRouter.HandleFunc("/clients", tcpnetwork.GetClientsHandler).Methods("GET")
-	apiRouter.HandleFunc("/clients/{id}/kick", tcpnetwork.KickClientHandler).Methods("POST")
-	apiRouter.HandleFunc("/clients/{id}/message", tcpnetwork.SendMessageHandler).Methods("POST")
+	for _, route := range apiRoutes(chat) {
+		apiRouter.Handle(route.path, logincheck.RequireRole(route.role, route.handler)).Methods(route.method)
+	}
 	
 	// 静态文件服务
 	fileServer := http.FileServer(http.Dir("static"))
@@ -0,0 +1,50 @@
+// Package sdnotify 实现systemd的 sd_notify 协议，用于通知启动完成、正在关闭和看门狗心跳
+// 服务单元配置 Type=notify 和 WatchdogSec= 后，健康检查连续失败超过 WatchdogSec 时systemd会重启服务
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// 常用状态
+const (
+	Ready    = "READY=1"    // 启动完成
+	Stopping = "STOPPING=1" // 开始关闭
+	Watchdog = "WATCHDOG=1" // 看门狗心跳
+)
+
+// Notify 向systemd发送状态，未在systemd下运行(NOTIFY_SOCKET为空)时什么也不做
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// 以@开头的是Linux抽象命名空间的套接字
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// WatchdogInterval 返回systemd要求的看门狗超时(WatchdogSec)，未启用或不是发给本进程时返回0
+// 应以不超过一半的间隔发送 Watchdog
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
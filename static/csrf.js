@@ -0,0 +1,22 @@
+// 为同源的非GET请求自动附加CSRF令牌(令牌由服务器通过 csrfToken cookie 下发)
+(function () {
+    const safeMethods = ['GET', 'HEAD', 'OPTIONS'];
+    const originalFetch = window.fetch;
+
+    function csrfToken() {
+        const match = document.cookie.match(/(?:^|;\s*)csrfToken=([^;]*)/);
+        return match ? decodeURIComponent(match[1]) : '';
+    }
+
+    window.fetch = function (input, init) {
+        init = init || {};
+        const method = (init.method || (input instanceof Request ? input.method : 'GET')).toUpperCase();
+        const url = new URL(input instanceof Request ? input.url : input, window.location.href);
+        if (!safeMethods.includes(method) && url.origin === window.location.origin) {
+            const headers = new Headers(init.headers || (input instanceof Request ? input.headers : undefined));
+            headers.set('X-CSRF-Token', csrfToken());
+            init.headers = headers;
+        }
+        return originalFetch.call(this, input, init);
+    };
+})();
@@ -0,0 +1,23 @@
+// 根据当前管理员的角色隐藏无权使用的操作(服务器端同样会校验权限)
+// 需要运维权限的元素加 need-operator 类，需要管理员权限的加 need-admin 类
+(function () {
+    const style = document.createElement('style');
+    style.textContent = `
+        body.role-viewer .need-operator,
+        body.role-viewer .need-admin,
+        body.role-operator .need-admin { display: none !important; }
+    `;
+    document.head.appendChild(style);
+
+    const roleNames = { viewer: '只读', operator: '运维', admin: '管理员' };
+
+    window.currentAdmin = fetch('/api/me')
+        .then(response => response.ok ? response.json() : Promise.reject(response.status))
+        .then(me => {
+            document.body.classList.add('role-' + me.role);
+            document.querySelectorAll('.admin-name').forEach(el => {
+                el.textContent = `${me.name}(${roleNames[me.role] || me.role})`;
+            });
+            return me;
+        });
+})();
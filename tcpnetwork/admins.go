@@ -0,0 +1,297 @@
+package tcpnetwork
+
+//管理后台账号与角色管理
+import (
+	"connection_server_linux/databasetool"
+	"connection_server_linux/logging"
+	"connection_server_linux/logincheck"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// 内置管理员，仅在还没有创建任何管理后台账号时可以登录，用于初始化账号
+const (
+	builtinAdminName     = "notlike"
+	builtinAdminPassword = "serve678"
+)
+
+// 管理后台账号密码的最短长度
+const minAdminPasswordLength = logincheck.MinAdminPasswordLength
+
+// authenticateAdmin 校验管理后台的用户名和密码
+// 返回值: 账号角色及是否验证通过
+func (srv *Server) authenticateAdmin(username, password string) (logincheck.Role, bool, error) {
+	count, err := databasetool.CountAdminAccounts(srv.db, "")
+	if err != nil {
+		return "", false, fmt.Errorf("查询管理员账号失败: %v", err)
+	}
+	if count == 0 {
+		return logincheck.RoleAdmin, username == builtinAdminName && password == builtinAdminPassword, nil
+	}
+
+	account, err := databasetool.FindAdminAccount(srv.db, username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("查询管理员账号失败: %v", err)
+	}
+	if !logincheck.VerifyPassword(account.PasswordHash, password) {
+		return "", false, nil
+	}
+	role, err := logincheck.ParseRole(account.Role)
+	if err != nil {
+		return "", false, err
+	}
+	return role, true, nil
+}
+
+// lastAdmin 判断该账号是否为唯一的管理员，唯一的管理员不能被删除或降级
+func (srv *Server) lastAdmin(account *databasetool.AdminAccount) (bool, error) {
+	if account.Role != string(logincheck.RoleAdmin) {
+		return false, nil
+	}
+	count, err := databasetool.CountAdminAccounts(srv.db, string(logincheck.RoleAdmin))
+	if err != nil {
+		return false, err
+	}
+	return count <= 1, nil
+}
+
+// lookupAdmin 查询路径中的管理员账号，失败时直接写入响应
+func (srv *Server) lookupAdmin(w http.ResponseWriter, r *http.Request) (*databasetool.AdminAccount, bool) {
+	name := mux.Vars(r)["name"]
+	account, err := databasetool.FindAdminAccount(srv.db, name)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "未找到管理员 %s", name)
+			return nil, false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "查询管理员失败: %v", err)
+		return nil, false
+	}
+	return account, true
+}
+
+// 返回当前登录的管理员及其角色，供页面按权限显示操作
+func MeHandler(w http.ResponseWriter, r *http.Request) {
+	_, session, _ := logincheck.SessionFromRequest(r)
+	role := session.Role
+	if role == "" {
+		role = logincheck.RoleViewer
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"name": session.UserID,
+		"role": string(role),
+	})
+}
+
+// 列出所有管理后台账号
+func (srv *Server) ListAdminsHandler(w http.ResponseWriter, r *http.Request) {
+	accounts, err := databasetool.ListAdminAccounts(srv.db)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "查询管理员失败: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(accounts)
+}
+
+// 创建管理后台账号
+// 创建第一个账号后内置管理员随即停用，因此第一个账号必须是管理员角色
+func (srv *Server) CreateAdminHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "无效的请求格式")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxNameLength {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "用户名不能为空且不能超过%d个字符", maxNameLength)
+		return
+	}
+	if len(req.Password) < minAdminPasswordLength {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "密码不能少于%d个字符", minAdminPasswordLength)
+		return
+	}
+	role, err := logincheck.ParseRole(req.Role)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	count, err := databasetool.CountAdminAccounts(srv.db, "")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "查询管理员失败: %v", err)
+		return
+	}
+	if count == 0 && role != logincheck.RoleAdmin {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "第一个账号必须是管理员角色")
+		return
+	}
+	if _, err := databasetool.FindAdminAccount(srv.db, name); err == nil {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "管理员 %s 已存在", name)
+		return
+	}
+
+	hash, err := logincheck.HashPassword(req.Password)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	account, err := databasetool.CreateAdminAccount(srv.db, name, hash, string(role), adminName(r))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "创建管理员失败: %v", err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("创建管理后台账号", "admin", adminName(r), "name", name, "role", role)
+	srv.audit(databasetool.AuditCreateAdmin, adminActor(r), "admin:"+name, requestIP(r), true, "角色: "+string(role))
+
+	// 内置管理员的密码公开在代码中，有了正式账号后吊销其全部会话
+	if count == 0 && name != builtinAdminName {
+		revoked := logincheck.GlobalSessionManager.RemoveUserSessions(builtinAdminName)
+		logging.FromContext(r.Context()).Info("已创建第一个管理员账号，内置管理员停用", "revoked", revoked)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(account)
+}
+
+// 修改管理后台账号的角色，账号的现有会话会被吊销以便新权限立即生效
+func (srv *Server) UpdateAdminRoleHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := srv.lookupAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "无效的请求格式")
+		return
+	}
+	role, err := logincheck.ParseRole(req.Role)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	if role != logincheck.RoleAdmin {
+		if last, err := srv.lastAdmin(account); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "查询管理员失败: %v", err)
+			return
+		} else if last {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, "不能降级唯一的管理员")
+			return
+		}
+	}
+
+	if err := databasetool.UpdateAdminRole(srv.db, account.Name, string(role)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "修改角色失败: %v", err)
+		return
+	}
+	logincheck.GlobalSessionManager.RemoveUserSessions(account.Name)
+	logging.FromContext(r.Context()).Info("修改管理后台账号角色", "admin", adminName(r), "name", account.Name, "old_role", account.Role, "new_role", role)
+	srv.audit(databasetool.AuditChangeRole, adminActor(r), "admin:"+account.Name, requestIP(r), true, fmt.Sprintf("%s -> %s", account.Role, role))
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s 的角色已修改为 %s", account.Name, role)
+}
+
+// 重置管理后台账号的密码，账号的现有会话会被吊销
+func (srv *Server) ResetAdminPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := srv.lookupAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Password) < minAdminPasswordLength {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "密码不能少于%d个字符", minAdminPasswordLength)
+		return
+	}
+
+	hash, err := logincheck.HashPassword(req.Password)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	if err := databasetool.UpdateAdminPassword(srv.db, account.Name, hash); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "重置密码失败: %v", err)
+		return
+	}
+	logincheck.GlobalSessionManager.RemoveUserSessions(account.Name)
+	logging.FromContext(r.Context()).Info("重置管理后台账号密码", "admin", adminName(r), "name", account.Name)
+	srv.audit(databasetool.AuditChangePassword, adminActor(r), "admin:"+account.Name, requestIP(r), true, "重置管理员密码")
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s 的密码已重置", account.Name)
+}
+
+// 删除管理后台账号并吊销其会话
+func (srv *Server) DeleteAdminHandler(w http.ResponseWriter, r *http.Request) {
+	account, ok := srv.lookupAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	if last, err := srv.lastAdmin(account); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "查询管理员失败: %v", err)
+		return
+	} else if last {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, "不能删除唯一的管理员")
+		return
+	}
+
+	if err := databasetool.DeleteAdminAccount(srv.db, account.Name); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "删除管理员失败: %v", err)
+		return
+	}
+	revoked := logincheck.GlobalSessionManager.RemoveUserSessions(account.Name)
+	logging.FromContext(r.Context()).Info("删除管理后台账号", "admin", adminName(r), "name", account.Name, "revoked", revoked)
+	srv.audit(databasetool.AuditDeleteAdmin, adminActor(r), "admin:"+account.Name, requestIP(r), true, "角色: "+account.Role)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "管理员 %s 已删除", account.Name)
+}
@@ -0,0 +1,64 @@
+package tcpnetwork_test
+
+import (
+	"connection_server_linux/databasetool"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// 创建、改角色、删除管理后台账号时的校验，按顺序执行，每步依赖前面步骤的结果
+func TestAdminAccountGuards(t *testing.T) {
+	s := startServer(t)
+
+	steps := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string // 路径中的账号名
+		body    string
+		want    int
+	}{
+		{"角色无效", s.srv.CreateAdminHandler, "", `{"name":"root","password":"password1","role":"root"}`, http.StatusBadRequest},
+		{"密码过短", s.srv.CreateAdminHandler, "", `{"name":"root","password":"short","role":"admin"}`, http.StatusBadRequest},
+		{"用户名为空", s.srv.CreateAdminHandler, "", `{"name":"  ","password":"password1","role":"admin"}`, http.StatusBadRequest},
+		{"第一个账号不是管理员", s.srv.CreateAdminHandler, "", `{"name":"root","password":"password1","role":"viewer"}`, http.StatusBadRequest},
+		{"创建第一个管理员", s.srv.CreateAdminHandler, "", `{"name":"root","password":"password1","role":"admin"}`, http.StatusCreated},
+		{"用户名已存在", s.srv.CreateAdminHandler, "", `{"name":"root","password":"password2","role":"viewer"}`, http.StatusConflict},
+		{"创建只读账号", s.srv.CreateAdminHandler, "", `{"name":"watcher","password":"password1","role":"viewer"}`, http.StatusCreated},
+
+		{"降级唯一的管理员", s.srv.UpdateAdminRoleHandler, "root", `{"role":"operator"}`, http.StatusConflict},
+		{"唯一的管理员改为管理员", s.srv.UpdateAdminRoleHandler, "root", `{"role":"admin"}`, http.StatusOK},
+		{"改角色的账号不存在", s.srv.UpdateAdminRoleHandler, "nobody", `{"role":"viewer"}`, http.StatusNotFound},
+		{"改为未知角色", s.srv.UpdateAdminRoleHandler, "watcher", `{"role":"root"}`, http.StatusBadRequest},
+		{"删除唯一的管理员", s.srv.DeleteAdminHandler, "root", "", http.StatusConflict},
+
+		{"只读账号升为管理员", s.srv.UpdateAdminRoleHandler, "watcher", `{"role":"admin"}`, http.StatusOK},
+		{"有两个管理员时降级", s.srv.UpdateAdminRoleHandler, "root", `{"role":"operator"}`, http.StatusOK},
+		{"降级后剩下唯一的管理员", s.srv.UpdateAdminRoleHandler, "watcher", `{"role":"viewer"}`, http.StatusConflict},
+		{"删除非管理员", s.srv.DeleteAdminHandler, "root", "", http.StatusOK},
+		{"删除剩下的唯一管理员", s.srv.DeleteAdminHandler, "watcher", "", http.StatusConflict},
+		{"删除的账号不存在", s.srv.DeleteAdminHandler, "root", "", http.StatusNotFound},
+	}
+	for _, step := range steps {
+		r := httptest.NewRequest("POST", "/api/admins", strings.NewReader(step.body))
+		if step.target != "" {
+			r = mux.SetURLVars(r, map[string]string{"name": step.target})
+		}
+		w := httptest.NewRecorder()
+		step.handler(w, r)
+		if w.Code != step.want {
+			t.Fatalf("%s: 状态码 = %d, 期望 %d, 响应: %s", step.name, w.Code, step.want, w.Body.String())
+		}
+	}
+
+	accounts, err := databasetool.ListAdminAccounts(s.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].Name != "watcher" || accounts[0].Role != "admin" {
+		t.Fatalf("最终的管理员账号 = %+v, 期望只剩管理员 watcher", accounts)
+	}
+}
@@ -0,0 +1,175 @@
+package tcpnetwork
+
+//审计日志记录与查询
+import (
+	"connection_server_linux/databasetool"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSV导出的最大行数
+const maxAuditExport = 100000
+
+// audit 写入审计日志，写入失败只记录到运行日志，不影响业务
+func (srv *Server) audit(action, actor, target, ip string, success bool, detail string) {
+	entry := &databasetool.AuditLog{
+		Action:  action,
+		Actor:   actor,
+		Target:  target,
+		Ip:      ip,
+		Success: success,
+		Detail:  detail,
+	}
+	if err := databasetool.CreateAuditLog(srv.db, entry); err != nil {
+		slog.Error("写入审计日志失败", "action", action, "actor", actor, "target", target, "err", err)
+	}
+}
+
+// 审计日志中的操作者：聊天客户端为用户ID，管理后台为 admin:<账号>，命令行工具为 cli:<系统用户名>。
+// 登录时账号不存在或被锁定的尝试没有操作者，用户名记录在详情中。
+
+// userActor 聊天客户端在审计日志中的操作者和目标
+func userActor(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// adminActorName 管理后台账号在审计日志中的操作者
+func adminActorName(name string) string {
+	return "admin:" + name
+}
+
+// adminActor 管理后台操作在审计日志中的操作者
+func adminActor(r *http.Request) string {
+	return adminActorName(adminName(r))
+}
+
+// parseAuditTime 解析时间过滤参数，支持RFC3339和日期(按本地时区)
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	// SQLite按字符串比较时间，统一转换为本地时区，与写入时的格式一致
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Local(), nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// 查询审计日志
+// 过滤参数: action、actor、target、ip、success(true/false)、since、until
+// 分页参数: page、page_size；format=csv 时导出全部符合条件的记录
+func (srv *Server) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := databasetool.AuditFilter{
+		Action: q.Get("action"),
+		Actor:  q.Get("actor"),
+		Target: q.Get("target"),
+		Ip:     q.Get("ip"),
+	}
+	if value := q.Get("success"); value != "" {
+		success, err := strconv.ParseBool(value)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "success 参数必须是 true 或 false")
+			return
+		}
+		filter.Success = &success
+	}
+
+	var err error
+	if filter.Since, err = parseAuditTime(q.Get("since")); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "无效的开始时间")
+		return
+	}
+	if filter.Until, err = parseAuditTime(q.Get("until")); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "无效的结束时间")
+		return
+	}
+	// 只给出日期时包含结束当天
+	if len(q.Get("until")) == len("2006-01-02") {
+		filter.Until = filter.Until.AddDate(0, 0, 1)
+	}
+
+	if q.Get("format") == "csv" {
+		srv.exportAuditCSV(w, filter)
+		return
+	}
+
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(q.Get("page_size"))
+	if err != nil || pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	logs, total, err := databasetool.QueryAuditLogs(srv.db, filter, (page-1)*pageSize, pageSize)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "查询审计日志失败: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"logs":      logs,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// csvSafe 防止用户可控的内容在表格软件中被当作公式执行
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsAny(value[:1], "=+-@\t\r") {
+		return "'" + value
+	}
+	return value
+}
+
+// exportAuditCSV 以CSV格式导出审计日志
+func (srv *Server) exportAuditCSV(w http.ResponseWriter, filter databasetool.AuditFilter) {
+	logs, _, err := databasetool.QueryAuditLogs(srv.db, filter, 0, maxAuditExport)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "查询审计日志失败: %v", err)
+		return
+	}
+
+	filename := fmt.Sprintf("audit_%s.csv", time.Now().Format("20060102_150405"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	// 写入BOM，方便用Excel直接打开中文内容
+	w.Write([]byte("\xEF\xBB\xBF"))
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "time", "action", "actor", "target", "ip", "success", "detail"})
+	for _, entry := range logs {
+		writer.Write([]string{
+			strconv.FormatUint(uint64(entry.ID), 10),
+			entry.CreatedAt.Local().Format(time.RFC3339),
+			entry.Action,
+			csvSafe(entry.Actor),
+			csvSafe(entry.Target),
+			entry.Ip,
+			strconv.FormatBool(entry.Success),
+			csvSafe(entry.Detail),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		slog.Error("导出审计日志失败", "err", err)
+	}
+}
@@ -0,0 +1,176 @@
+package tcpnetwork
+
+//封禁管理http请求处理
+import (
+	"connection_server_linux/databasetool"
+	"connection_server_linux/logging"
+	"connection_server_linux/user"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// banMessage 组装返回给客户端的封禁提示，subject 为 "账号" 或 "该IP"
+func banMessage(subject string, ban *databasetool.Ban) string {
+	msg := subject + "已被永久封禁"
+	if !ban.Permanent() {
+		msg = fmt.Sprintf("%s已被封禁至 %s", subject, ban.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+	}
+	if ban.Reason != "" {
+		msg += "，原因：" + ban.Reason
+	}
+	return msg
+}
+
+// disconnectIP 断开来自指定IP的所有在线连接
+// 返回值: 被断开的连接数
+func (srv *Server) disconnectIP(ip string, reason string) int {
+	srv.clients.Mutex.RLock()
+	ids := make([]string, 0)
+	for id, client := range srv.clients.Clients {
+		if client.IP == ip {
+			ids = append(ids, id)
+		}
+	}
+	srv.clients.Mutex.RUnlock()
+
+	count := 0
+	for _, id := range ids {
+		if srv.disconnectClient(id, reason) {
+			count++
+		}
+	}
+	return count
+}
+
+// 获取所有生效中的封禁
+func (srv *Server) GetBansHandler(w http.ResponseWriter, r *http.Request) {
+	bans, err := databasetool.ListActiveBans(srv.db, time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "查询封禁记录失败: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bans)
+}
+
+// 封禁账号或IP，duration_minutes 为0表示永久封禁，对应的在线连接会被立即断开
+func (srv *Server) CreateBanHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Kind            string `json:"kind"`
+		Target          string `json:"target"`
+		Reason          string `json:"reason"`
+		DurationMinutes int    `json:"duration_minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DurationMinutes < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "无效的请求格式")
+		return
+	}
+
+	target := strings.TrimSpace(req.Target)
+	switch req.Kind {
+	case databasetool.BanKindUser:
+		id, err := user.ValidateID(target)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "无效的用户ID")
+			return
+		}
+		num, _ := strconv.Atoi(id)
+		if _, err := databasetool.FindUserById(srv.db, num); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprintf(w, "未找到用户 %s", id)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "查询用户失败: %v", err)
+			return
+		}
+		target = id
+	case databasetool.BanKindIP:
+		ip := net.ParseIP(target)
+		if ip == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "无效的IP地址")
+			return
+		}
+		target = ip.String()
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "封禁类型必须是 user 或 ip")
+		return
+	}
+
+	var expiresAt time.Time
+	if req.DurationMinutes > 0 {
+		expiresAt = time.Now().Add(time.Duration(req.DurationMinutes) * time.Minute)
+	}
+
+	ban, err := databasetool.CreateBan(srv.db, req.Kind, target, strings.TrimSpace(req.Reason), adminName(r), expiresAt)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "添加封禁失败: %v", err)
+		return
+	}
+
+	if ban.Kind == databasetool.BanKindUser {
+		srv.disconnectClient(target, banMessage("账号", ban))
+	} else {
+		srv.disconnectIP(target, banMessage("该IP", ban))
+	}
+	logging.FromContext(r.Context()).Info("添加封禁", "admin", ban.IssuedBy, "kind", ban.Kind, "target", ban.Target, "reason", ban.Reason)
+	srv.audit(databasetool.AuditBan, adminActor(r), ban.Kind+":"+ban.Target, requestIP(r), true, banMessage("", ban))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ban)
+}
+
+// 解除封禁
+func (srv *Server) DeleteBanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "无效的封禁ID")
+		return
+	}
+
+	ban, err := databasetool.FindBanById(srv.db, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "未找到封禁记录 %d", id)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "查询封禁记录失败: %v", err)
+		return
+	}
+
+	if err := databasetool.DeleteBan(srv.db, id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "未找到封禁记录 %d", id)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "解除封禁失败: %v", err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("解除封禁", "admin", adminName(r), "ban", id)
+	srv.audit(databasetool.AuditUnban, adminActor(r), ban.Kind+":"+ban.Target, requestIP(r), true, fmt.Sprintf("封禁ID %d", id))
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "封禁 %d 已解除", id)
+}
@@ -0,0 +1,145 @@
+package tcpnetwork
+
+//系统公告广播
+import (
+	"connection_server_linux/databasetool"
+	"connection_server_linux/logging"
+	"connection_server_linux/user"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	systemSenderID     = "system"        // 系统公告的发送者ID，不会与用户ID冲突
+	announcementPrefix = "announcement:" // 离线公告在暂存消息中的前缀
+)
+
+// sendAnnouncement 向客户端发送系统公告，格式与 ChatMessage 一致
+func sendAnnouncement(conn net.Conn, receiverID string, content string, sendTime time.Time) error {
+	msgBytes, err := json.Marshal(user.ChatMessage{
+		Type:      "system_announcement",
+		SendID:    systemSenderID,
+		ReceiveID: receiverID,
+		Content:   content,
+		SendTime:  sendTime.Round(0).String(), // 去掉单调时钟读数，与离线消息的时间格式一致
+	})
+	if err != nil {
+		return fmt.Errorf("序列化系统公告失败: %v", err)
+	}
+	return writeFramedBytes(conn, msgBytes)
+}
+
+// 向在线用户广播系统公告
+// user_ids 为空时发送给所有用户，persist 为 true 时离线用户会在下次登录时收到
+func (srv *Server) BroadcastHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Content string   `json:"content"`
+		UserIDs []string `json:"user_ids"`
+		Persist bool     `json:"persist"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "无效的请求格式")
+		return
+	}
+	content := strings.TrimSpace(req.Content)
+	if content == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "公告内容不能为空")
+		return
+	}
+
+	// 指定了接收者时只发给这些用户
+	var targets map[string]bool
+	if len(req.UserIDs) > 0 {
+		targets = make(map[string]bool, len(req.UserIDs))
+		for _, raw := range req.UserIDs {
+			id, err := user.ValidateID(strings.TrimSpace(raw))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, err.Error())
+				return
+			}
+			targets[id] = true
+		}
+	}
+
+	srv.clients.Mutex.RLock()
+	online := make(map[string]*user.Client, len(srv.clients.Clients))
+	for id, client := range srv.clients.Clients {
+		if targets == nil || targets[id] {
+			online[id] = client
+		}
+	}
+	srv.clients.Mutex.RUnlock()
+
+	now := time.Now()
+	delivered, failed := 0, 0
+	for id, client := range online {
+		if err := sendAnnouncement(client.Conn, id, content, now); err != nil {
+			logging.FromContext(r.Context()).Warn("发送系统公告失败", "user", id, "err", err)
+			failed++
+			continue
+		}
+		delivered++
+	}
+
+	queued := 0
+	if req.Persist {
+		offline, err := srv.offlineRecipients(targets, online)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "查询离线用户失败: %v", err)
+			return
+		}
+		if err := databasetool.CreateUnsendChats(srv.db, systemSenderID, offline, announcementPrefix+content); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "保存离线公告失败: %v", err)
+			return
+		}
+		queued = len(offline)
+	}
+
+	logging.FromContext(r.Context()).Info("发布系统公告", "admin", adminName(r), "delivered", delivered, "failed", failed, "queued", queued)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"delivered": delivered,
+		"failed":    failed,
+		"queued":    queued,
+	})
+}
+
+// offlineRecipients 计算需要暂存公告的离线用户
+// targets 为空时取所有注册用户，否则只取其中存在的用户
+func (srv *Server) offlineRecipients(targets map[string]bool, online map[string]*user.Client) ([]string, error) {
+	var ids []string
+	if targets == nil {
+		all, err := databasetool.ListUserIDs(srv.db)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range all {
+			ids = append(ids, strconv.FormatUint(uint64(id), 10))
+		}
+	} else {
+		for id := range targets {
+			num, _ := strconv.Atoi(id)
+			if _, err := databasetool.FindUserById(srv.db, num); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	offline := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := online[id]; !ok {
+			offline = append(offline, id)
+		}
+	}
+	return offline, nil
+}
@@ -2,14 +2,18 @@ package tcpnetwork
 
 //http请求处理
 import (
+	"connection_server_linux/databasetool"
+	"connection_server_linux/logging"
 	"connection_server_linux/logincheck"
 	"connection_server_linux/user"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -23,7 +27,7 @@ func GenerateSessionID() string {
 }
 
 // 登录处理函数
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
@@ -40,11 +44,27 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := requestIP(r)
+	if wait, ok := srv.limiter.Check(logincheck.SurfaceAdmin, ip, credentials.Username); !ok {
+		srv.audit(databasetool.AuditAdminLogin, adminActorName(credentials.Username), "", ip, false, "登录已被锁定")
+		writeLockout(w, wait)
+		return
+	}
+
 	// 验证用户名和密码
-	if credentials.Username == "notlike" && credentials.Password == "serve678" {
+	role, ok, err := srv.authenticateAdmin(credentials.Username, credentials.Password)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("管理后台登录验证失败", "username", credentials.Username, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "服务器内部错误"})
+		return
+	}
+	if ok {
+		srv.limiter.Succeed(logincheck.SurfaceAdmin, credentials.Username)
+		srv.audit(databasetool.AuditAdminLogin, adminActorName(credentials.Username), "", ip, true, "角色: "+string(role))
 		// 生成session ID并创建会话
 		sessionID := GenerateSessionID()
-		logincheck.GlobalSessionManager.CreateSession(credentials.Username, sessionID)
+		logincheck.GlobalSessionManager.CreateSession(credentials.Username, sessionID, role, ip, r.UserAgent())
 
 		// 设置session cookie
 		sessionCookie := http.Cookie{
@@ -53,52 +73,162 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 			Path:     "/",
 			HttpOnly: true,
 			Secure:   true,
-			Expires:  time.Now().Add(24 * time.Hour),
+			SameSite: http.SameSiteStrictMode,
+			Expires:  time.Now().Add(logincheck.GlobalSessionManager.MaxLifetime),
 		}
 		http.SetCookie(w, &sessionCookie)
-		log.Println("设置新cookie成功，cookie的value为：", sessionCookie.Value)
+		logging.FromContext(r.Context()).Info("管理后台登录成功", "admin", credentials.Username, "role", role)
+		// 下发与会话绑定的CSRF令牌
+		if token, ok := logincheck.GlobalSessionManager.EnsureCSRFToken(sessionID); ok {
+			logincheck.SetCSRFCookie(w, token)
+		}
 
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+		json.NewEncoder(w).Encode(map[string]string{"status": "success", "role": string(role)})
 	} else {
+		srv.audit(databasetool.AuditAdminLogin, adminActorName(credentials.Username), "", ip, false, "用户名或密码错误")
+		if wait := srv.limiter.Fail(logincheck.SurfaceAdmin, ip, credentials.Username); wait > 0 {
+			logging.FromContext(r.Context()).Warn("管理后台登录失败次数过多，已锁定", "ip", ip, "username", credentials.Username)
+			writeLockout(w, wait)
+			return
+		}
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(map[string]string{"error": "用户名或密码错误"})
 	}
 }
 
+// writeLockout 返回429，并通过Retry-After告知剩余锁定时间
+func writeLockout(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":       fmt.Sprintf("登录失败次数过多，请在%d秒后重试", seconds),
+		"retry_after": seconds,
+	})
+}
+
+// 获取登录失败及锁定记录
+func (srv *Server) GetLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(srv.limiter.Lockouts())
+}
+
+// 手动解除IP或某个登录入口用户名的登录锁定
+func (srv *Server) UnlockHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil ||
+		!logincheck.ValidLimitKind(req.Kind) || req.Value == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "无效的请求格式")
+		return
+	}
+
+	if srv.limiter.Unlock(req.Kind, req.Value) {
+		_, session, _ := logincheck.SessionFromRequest(r)
+		logging.FromContext(r.Context()).Info("解除登录锁定", "admin", session.UserID, "kind", req.Kind, "value", req.Value)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "%s 已解除锁定", req.Value)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "未找到 %s 的锁定记录", req.Value)
+	}
+}
+
+// requestIP 获取HTTP请求的来源IP
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// 退出登录：吊销当前会话并清除cookie
+func (srv *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if sessionID, session, ok := logincheck.SessionFromRequest(r); ok {
+		logincheck.GlobalSessionManager.RemoveSession(sessionID)
+		srv.audit(databasetool.AuditAdminLogout, adminActorName(session.UserID), "", requestIP(r), true, "")
+	}
+	logincheck.ClearSessionCookie(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// 退出当前管理员的所有会话
+func (srv *Server) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	_, session, ok := logincheck.SessionFromRequest(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "请先登录"})
+		return
+	}
+
+	count := logincheck.GlobalSessionManager.RemoveUserSessions(session.UserID)
+	logincheck.ClearSessionCookie(w)
+	logging.FromContext(r.Context()).Info("退出全部会话", "admin", session.UserID, "count", count)
+	srv.audit(databasetool.AuditAdminLogout, adminActorName(session.UserID), "", requestIP(r), true, fmt.Sprintf("退出全部 %d 个会话", count))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "revoked": count})
+}
+
+// 获取所有有效的管理会话
+func GetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, _, _ := logincheck.SessionFromRequest(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logincheck.GlobalSessionManager.ListSessions(sessionID))
+}
+
+// 吊销指定的管理会话
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	publicID := mux.Vars(r)["id"]
+
+	if logincheck.GlobalSessionManager.RevokeSession(publicID) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "会话 %s 已吊销", publicID)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "未找到会话 %s", publicID)
+	}
+}
+
 // 获取所有客户端列表
-func GetClientsHandler(w http.ResponseWriter, r *http.Request) {
-	user.Manager.Mutex.RLock()
-	clients := make([]user.Client, 0, len(user.Manager.Clients))
-	for _, client := range user.Manager.Clients {
-		clients = append(clients, *client)
+func (srv *Server) GetClientsHandler(w http.ResponseWriter, r *http.Request) {
+	srv.clients.Mutex.RLock()
+	clients := make([]user.ClientInfo, 0, len(srv.clients.Clients))
+	for _, client := range srv.clients.Clients {
+		clients = append(clients, client.Info())
 	}
-	user.Manager.Mutex.RUnlock()
+	srv.clients.Mutex.RUnlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(clients)
 }
 
 // 踢出指定客户端
-func KickClientHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) KickClientHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	clientID := vars["id"]
 
-	user.Manager.Mutex.Lock()
-	if client, exists := user.Manager.Clients[clientID]; exists {
-		client.Conn.Close()
-		delete(user.Manager.Clients, clientID)
+	if srv.disconnectClient(clientID, "已被管理员踢出") {
+		srv.audit(databasetool.AuditKick, adminActor(r), clientID, requestIP(r), true, "")
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintf(w, "客户端 %s 已被踢出", clientID)
 	} else {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "未找到客户端 %s", clientID)
 	}
-	user.Manager.Mutex.Unlock()
 }
 
-// 发送消息给指定客户端
-func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+// 以系统公告的形式发送消息给指定客户端
+func (srv *Server) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	clientID := vars["id"]
 
@@ -110,9 +240,8 @@ func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user.Manager.Mutex.RLock()
-	if client, exists := user.Manager.Clients[clientID]; exists {
-		err := writeFramedBytes(client.Conn, []byte(message.Content))
+	if conn, exists := srv.onlineConn(clientID); exists {
+		err := sendAnnouncement(conn, clientID, message.Content, time.Now())
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(w, "发送消息失败: %v", err)
@@ -124,19 +253,73 @@ func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "未找到客户端 %s", clientID)
 	}
-	user.Manager.Mutex.RUnlock()
+}
+
+// 通过SSE推送服务器事件：客户端连接/断开/踢出、消息计数和文件传输
+func (srv *Server) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "当前连接不支持事件推送")
+		return
+	}
+
+	stream, unsubscribe := srv.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// 定期发送注释行保持连接，避免被代理或浏览器判定为空闲
+	heartbeat := time.NewTicker(25 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-stream:
+			if !ok {
+				// 服务器正在关闭
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("序列化事件失败", "event", event.Type, "err", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
 }
 
 // 获取服务器IP和端口信息
-func GetServerInfoHandler(w http.ResponseWriter, r *http.Request) {
-	serverInfo := struct {
+func (srv *Server) GetServerInfoHandler(w http.ResponseWriter, r *http.Request) {
+	var serverInfo struct {
 		IP   string `json:"ip"`
 		Port int    `json:"port"`
-	}{
-		IP:   TcpAddr.IP.String(),
-		Port: TcpAddr.Port,
+	}
+	if addr, ok := srv.Addr().(*net.TCPAddr); ok {
+		serverInfo.IP = addr.IP.String()
+		serverInfo.Port = addr.Port
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(serverInfo)
 }
+
+// 通过签名链接下载待接收文件
+func (srv *Server) DownloadFileHandler(w http.ResponseWriter, r *http.Request) {
+	srv.files.ServeDownload(w, r, mux.Vars(r)["transferid"])
+}
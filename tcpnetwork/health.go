@@ -0,0 +1,124 @@
+package tcpnetwork
+
+//健康检查与就绪检查
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// healthCheckTimeout 单次检查(数据库Ping等)的最长时间
+const healthCheckTimeout = 3 * time.Second
+
+// CheckResult 单项检查结果
+type CheckResult struct {
+	Status string `json:"status"`          // ok 或 fail
+	Error  string `json:"error,omitempty"` // 失败原因
+}
+
+// HealthReport 检查报告
+type HealthReport struct {
+	Status string                 `json:"status"` // 全部通过为 ok，否则为 fail
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Healthy 是否全部检查通过
+func (r HealthReport) Healthy() bool {
+	return r.Status == "ok"
+}
+
+// checkListener 检查TCP服务是否仍在接受连接，关闭过程中不算异常
+func (srv *Server) checkListener() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !srv.serving && !srv.shuttingDown {
+		return errors.New("TCP服务未在监听")
+	}
+	return nil
+}
+
+// checkShutdown 关闭开始后不再就绪，让负载均衡器提前摘除
+func (srv *Server) checkShutdown() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.shuttingDown {
+		return errors.New("服务器正在关闭")
+	}
+	return nil
+}
+
+// checkGoroutines 检查协程数是否超过上限
+func (srv *Server) checkGoroutines() error {
+	count := runtime.NumGoroutine()
+	if srv.maxGoroutines > 0 && count > srv.maxGoroutines {
+		return fmt.Errorf("协程数 %d 超过上限 %d", count, srv.maxGoroutines)
+	}
+	return nil
+}
+
+// checkDatabase 检查数据库连接
+func (srv *Server) checkDatabase(ctx context.Context) error {
+	sqlDB, err := srv.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// checkStorage 检查文件存储目录是否可写
+func (srv *Server) checkStorage() error {
+	return srv.files.CheckStorage()
+}
+
+// CheckHealth 执行检查并返回报告
+// 存活检查(ready 为 false)只检查进程本身：TCP监听和协程数；
+// 就绪检查(ready 为 true)另外检查数据库、文件存储和是否正在关闭，未通过时不应再接收流量
+func (srv *Server) CheckHealth(ctx context.Context, ready bool) HealthReport {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	checks := map[string]func() error{
+		"tcp_listener": srv.checkListener,
+		"goroutines":   srv.checkGoroutines,
+	}
+	if ready {
+		checks["database"] = func() error { return srv.checkDatabase(ctx) }
+		checks["storage"] = srv.checkStorage
+		checks["shutdown"] = srv.checkShutdown
+	}
+
+	report := HealthReport{Status: "ok", Checks: make(map[string]CheckResult, len(checks))}
+	for name, check := range checks {
+		if err := check(); err != nil {
+			report.Status = "fail"
+			report.Checks[name] = CheckResult{Status: "fail", Error: err.Error()}
+			continue
+		}
+		report.Checks[name] = CheckResult{Status: "ok"}
+	}
+	return report
+}
+
+// writeHealth 输出检查报告，未通过时返回503
+func writeHealth(w http.ResponseWriter, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !report.Healthy() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// 存活检查，无需登录，供systemd、负载均衡器判断是否需要重启
+func (srv *Server) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, srv.CheckHealth(r.Context(), false))
+}
+
+// 就绪检查，无需登录，未通过时负载均衡器应暂停转发流量
+func (srv *Server) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, srv.CheckHealth(r.Context(), true))
+}
@@ -0,0 +1,723 @@
+package tcpnetwork_test
+
+import (
+	"bytes"
+	"connection_server_linux/chattest"
+	"connection_server_linux/databasetool"
+	"connection_server_linux/events"
+	"connection_server_linux/filetransfer"
+	"connection_server_linux/frame"
+	"connection_server_linux/logincheck"
+	"connection_server_linux/tcpnetwork"
+	"connection_server_linux/user"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// testServer 在随机端口上运行的聊天服务器，使用临时SQLite数据库和临时存储目录
+type testServer struct {
+	t    *testing.T
+	srv  *tcpnetwork.Server
+	db   *gorm.DB
+	addr string
+}
+
+func startServer(t *testing.T) *testServer {
+	t.Helper()
+
+	dir := t.TempDir()
+	db, err := databasetool.OpenDB(filepath.Join(dir, "chat.db"))
+	if err != nil {
+		t.Fatalf("打开测试数据库失败: %v", err)
+	}
+	srv, err := tcpnetwork.NewServer(tcpnetwork.Options{
+		DB:         db,
+		StorageDir: filepath.Join(dir, "files"),
+		HTTPSPort:  8443,
+		// 所有连接都来自127.0.0.1，每个服务器使用自己的限流器和事件中心，测试之间互不影响
+		LoginLimiter: logincheck.NewLoginLimiter(),
+		Events:       events.NewHub(),
+	})
+	if err != nil {
+		t.Fatalf("创建服务器失败: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听随机端口失败: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			t.Errorf("关闭服务器失败: %v", err)
+		}
+		if err := <-served; err != nil {
+			t.Errorf("Serve 返回错误: %v", err)
+		}
+		if err := srv.Close(); err != nil {
+			t.Errorf("关闭数据库失败: %v", err)
+		}
+	})
+	return &testServer{t: t, srv: srv, db: db, addr: l.Addr().String()}
+}
+
+// register 注册用户，失败时终止测试
+func (s *testServer) register(name string) string {
+	s.t.Helper()
+	id, err := chattest.Register(s.addr, name, name+"-pwd")
+	if err != nil {
+		s.t.Fatalf("注册 %s 失败: %v", name, err)
+	}
+	return id
+}
+
+// login 登录用户，失败时终止测试，测试结束时断开
+func (s *testServer) login(name string) *chattest.Client {
+	s.t.Helper()
+	c, err := chattest.Login(s.addr, name, name+"-pwd")
+	if err != nil {
+		s.t.Fatalf("登录 %s 失败: %v", name, err)
+	}
+	s.t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// logout 断开连接并等待服务器清理完成，之后该用户按离线处理
+func (s *testServer) logout(c *chattest.Client) {
+	s.t.Helper()
+	c.Close()
+	deadline := time.Now().Add(chattest.DefaultTimeout)
+	for s.srv.Clients().IsOnline(c.ID) {
+		if time.Now().After(deadline) {
+			s.t.Fatalf("用户 %s 断开后仍在线", c.Name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitOnline 等待服务器完成登录后的初始化(发送暂存消息)并进入消息循环
+// 客户端在收到好友列表时服务器可能仍在补发离线消息，用一次无害的往返确认
+func waitOnline(t *testing.T, c *chattest.Client) {
+	t.Helper()
+	if err := c.FileMessage("file_link", "sync"); err != nil {
+		t.Fatalf("发送同步消息失败: %v", err)
+	}
+	if _, err := c.Expect("file_error"); err != nil {
+		t.Fatalf("等待同步响应失败: %v", err)
+	}
+}
+
+func expect(t *testing.T, c *chattest.Client, msgType string) chattest.Packet {
+	t.Helper()
+	p, err := c.Expect(msgType)
+	if err != nil {
+		t.Fatalf("用户 %s: %v", c.Name, err)
+	}
+	return p
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	s := startServer(t)
+
+	id := s.register("alice")
+	if id == "" || id == "0" {
+		t.Fatalf("注册返回的用户ID无效: %q", id)
+	}
+	if _, err := chattest.Register(s.addr, "alice", "other"); err == nil || !strings.Contains(err.Error(), "用户名已存在") {
+		t.Fatalf("重复注册应失败, err = %v", err)
+	}
+	if _, err := chattest.Register(s.addr, "", "pwd"); err == nil {
+		t.Fatal("空用户名注册应失败")
+	}
+
+	if _, err := chattest.Login(s.addr, "alice", "wrong"); err == nil || !strings.Contains(err.Error(), "用户名或密码错误") {
+		t.Fatalf("密码错误时登录应失败, err = %v", err)
+	}
+	if _, err := chattest.Login(s.addr, "nobody", "pwd"); err == nil || !strings.Contains(err.Error(), "账号不存在") {
+		t.Fatalf("不存在的账号登录应失败, err = %v", err)
+	}
+
+	c := s.login("alice")
+	if c.ID != id {
+		t.Fatalf("登录返回的用户ID = %s, 注册时为 %s", c.ID, id)
+	}
+	if len(c.Friends) != 0 {
+		t.Fatalf("新用户的好友列表 = %v, 期望为空", c.Friends)
+	}
+	if !s.srv.Clients().IsOnline(id) {
+		t.Fatal("登录后用户应在线")
+	}
+
+	s.logout(c)
+	num, _ := strconv.Atoi(id)
+	record, err := databasetool.FindUserById(s.db, num)
+	if err != nil {
+		t.Fatalf("查询用户失败: %v", err)
+	}
+	if record.Status != 0 {
+		t.Fatalf("断开后数据库中的状态 = %d, 期望离线", record.Status)
+	}
+}
+
+// 聊天客户端的注册、登录和断开在审计日志中使用同一种操作者：用户ID
+func TestChatAuditActor(t *testing.T) {
+	s := startServer(t)
+	id := s.register("alice")
+	if _, err := chattest.Login(s.addr, "alice", "wrong"); err == nil {
+		t.Fatal("密码错误时登录应失败")
+	}
+	s.logout(s.login("alice"))
+
+	var logs []databasetool.AuditLog
+	deadline := time.Now().Add(chattest.DefaultTimeout)
+	for {
+		var err error
+		logs, _, err = databasetool.QueryAuditLogs(s.db, databasetool.AuditFilter{}, 0, 100)
+		if err != nil {
+			t.Fatalf("查询审计日志失败: %v", err)
+		}
+		// 断开的记录在清理连接时写入，可能晚于 IsOnline 变为 false
+		if len(logs) >= 4 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(logs) != 4 {
+		t.Fatalf("审计日志 %d 条, 期望 4 条: %+v", len(logs), logs)
+	}
+	for _, entry := range logs {
+		if entry.Actor != id || entry.Target != id {
+			t.Errorf("%s(成功=%v) 的操作者和目标 = %q, %q, 期望都为 %s", entry.Action, entry.Success, entry.Actor, entry.Target, id)
+		}
+	}
+}
+
+// 被管理员踢出的用户在连接关闭后从在线列表移除，数据库中记录为离线
+func TestKickMarksUserOffline(t *testing.T) {
+	s := startServer(t)
+	s.register("alice")
+	c := s.login("alice")
+	waitOnline(t, c)
+
+	r := mux.SetURLVars(httptest.NewRequest("POST", "/api/clients/"+c.ID+"/kick", nil), map[string]string{"id": c.ID})
+	w := httptest.NewRecorder()
+	s.srv.KickClientHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("踢出状态码 = %d, 期望 200, 响应: %s", w.Code, w.Body.String())
+	}
+	expect(t, c, "force_logout")
+
+	id, _ := strconv.Atoi(c.ID)
+	deadline := time.Now().Add(chattest.DefaultTimeout)
+	for {
+		record, err := databasetool.FindUserById(s.db, id)
+		if err != nil {
+			t.Fatalf("查询用户失败: %v", err)
+		}
+		if record.Status == 0 && !record.LeaveTime.IsZero() && !s.srv.Clients().IsOnline(c.ID) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("踢出后 Status = %d, LeaveTime = %v, 在线 = %v, 期望离线", record.Status, record.LeaveTime, s.srv.Clients().IsOnline(c.ID))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 同一进程中的两个服务器各自持有登录限流器和事件中心
+func TestServersAreIndependent(t *testing.T) {
+	a := startServer(t)
+	b := startServer(t)
+	a.register("alice")
+	b.register("alice")
+
+	stream, unsubscribe := a.srv.Events().Subscribe()
+	defer unsubscribe()
+
+	// 在 a 上连续输错密码直到被锁定，b 上的同名账号不受影响
+	for i := 0; i < logincheck.DefaultFreeAttempts+1; i++ {
+		chattest.Login(a.addr, "alice", "wrong")
+	}
+	if _, err := chattest.Login(a.addr, "alice", "alice-pwd"); err == nil || !strings.Contains(err.Error(), "登录失败次数过多") {
+		t.Fatalf("a 上的 alice 应被锁定, err = %v", err)
+	}
+	b.login("alice")
+
+	// b 的事件不会发布到 a 的事件中心
+	select {
+	case event := <-stream:
+		t.Fatalf("a 的事件中心收到了 b 的事件: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+	a.srv.Events().Publish(events.ClientConnected, nil)
+	if event := <-stream; event.Type != events.ClientConnected {
+		t.Fatalf("收到事件 %s, 期望 %s", event.Type, events.ClientConnected)
+	}
+}
+
+func TestFirstPacketMustBeLoginOrRegister(t *testing.T) {
+	s := startServer(t)
+
+	c, err := chattest.Dial(s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.SendMessage("1", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitClosed(); err != nil {
+		t.Fatalf("未登录就发送消息时服务器应断开连接: %v", err)
+	}
+}
+
+// 非TCP连接(没有IP地址)也能登录，不会因取不到IP而崩溃
+func TestLoginOverPipe(t *testing.T) {
+	s := startServer(t)
+	s.register("alice")
+
+	server, conn := net.Pipe()
+	defer conn.Close()
+	go s.srv.HandleConnection(server)
+
+	conn.SetDeadline(time.Now().Add(chattest.DefaultTimeout))
+	if err := frame.WriteJSON(conn, map[string]string{"type": "login", "name": "alice", "pwd": "alice-pwd"}); err != nil {
+		t.Fatalf("发送登录请求失败: %v", err)
+	}
+	_, payload, err := frame.Read(conn)
+	if err != nil {
+		t.Fatalf("读取登录响应失败: %v", err)
+	}
+	var resp tcpnetwork.LoginResponse
+	if err := json.Unmarshal(payload, &resp); err != nil || !resp.Success {
+		t.Fatalf("登录响应 = %s, %v, 期望成功", payload, err)
+	}
+
+	// net.Pipe 没有缓冲，读取好友列表后服务器才进入消息循环
+	if _, _, err := frame.Read(conn); err != nil {
+		t.Fatalf("读取好友列表失败: %v", err)
+	}
+
+	// 直接交给 HandleConnection 的连接不受 Shutdown 管理，关闭前等待清理完成
+	id := strings.TrimPrefix(resp.Message, "id:")
+	conn.Close()
+	deadline := time.Now().Add(chattest.DefaultTimeout)
+	for s.srv.Clients().IsOnline(id) {
+		if time.Now().After(deadline) {
+			t.Fatalf("用户 %s 断开后仍在线", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOnlineMessage(t *testing.T) {
+	s := startServer(t)
+	s.register("alice")
+	s.register("bob")
+	alice := s.login("alice")
+	bob := s.login("bob")
+
+	if err := alice.SendMessage(bob.ID, "hi bob"); err != nil {
+		t.Fatal(err)
+	}
+	msg := expect(t, bob, "message")
+	if msg.String("content") != "hi bob" {
+		t.Fatalf("消息内容 = %q", msg.String("content"))
+	}
+	if msg.String("sendid") != alice.ID || msg.String("receiveid") != bob.ID {
+		t.Fatalf("消息的发送者/接收者 = %s/%s, 期望 %s/%s", msg.String("sendid"), msg.String("receiveid"), alice.ID, bob.ID)
+	}
+
+	// 发送者ID以登录身份为准，客户端填写的无效
+	if err := bob.Send(map[string]string{"type": "message", "sendid": "999", "receiveid": alice.ID, "content": "reply"}); err != nil {
+		t.Fatal(err)
+	}
+	if reply := expect(t, alice, "message"); reply.String("sendid") != bob.ID {
+		t.Fatalf("回复的发送者 = %s, 期望 %s", reply.String("sendid"), bob.ID)
+	}
+
+	var count int64
+	s.db.Model(&databasetool.Unsendchat{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("双方在线时不应暂存消息, 暂存了 %d 条", count)
+	}
+}
+
+// 消息循环更新活动时间和消息数的同时，管理后台读取客户端列表，用 -race 运行时检查数据竞争
+// 向不读取数据的接收者转发消息时，其他用户仍可登录
+func TestSlowReceiverDoesNotBlockLogin(t *testing.T) {
+	s := startServer(t)
+	s.register("alice")
+	s.register("bob")
+	s.register("carol")
+
+	// bob 通过没有缓冲的 net.Pipe 登录，之后不再读取，服务器向他写入时会阻塞
+	server, bob := net.Pipe()
+	defer bob.Close()
+	go s.srv.HandleConnection(server)
+	if err := frame.WriteJSON(bob, map[string]string{"type": "login", "name": "bob", "pwd": "bob-pwd"}); err != nil {
+		t.Fatalf("发送登录请求失败: %v", err)
+	}
+	var resp tcpnetwork.LoginResponse
+	for _, what := range []string{"登录响应", "好友列表"} {
+		_, payload, err := frame.Read(bob)
+		if err != nil {
+			t.Fatalf("读取%s失败: %v", what, err)
+		}
+		if resp.Message == "" {
+			json.Unmarshal(payload, &resp)
+		}
+	}
+	bobID := strings.TrimPrefix(resp.Message, "id:")
+
+	alice := s.login("alice")
+	if err := alice.SendMessage(bobID, "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	loggedIn := make(chan error, 1)
+	go func() {
+		c, err := chattest.Login(s.addr, "carol", "carol-pwd")
+		if err == nil {
+			c.Close()
+		}
+		loggedIn <- err
+	}()
+	select {
+	case err := <-loggedIn:
+		if err != nil {
+			t.Fatalf("carol 登录失败: %v", err)
+		}
+	case <-time.After(chattest.DefaultTimeout):
+		t.Fatal("向慢接收者转发消息时其他用户无法登录")
+	}
+
+	// 读出阻塞的消息，之后断开并等待清理完成
+	bob.SetReadDeadline(time.Now().Add(chattest.DefaultTimeout))
+	if _, _, err := frame.Read(bob); err != nil {
+		t.Fatalf("读取转发的消息失败: %v", err)
+	}
+	bob.Close()
+	deadline := time.Now().Add(chattest.DefaultTimeout)
+	for s.srv.Clients().IsOnline(bobID) {
+		if time.Now().After(deadline) {
+			t.Fatalf("用户 %s 断开后仍在线", bobID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientListWhileMessagesFlow(t *testing.T) {
+	s := startServer(t)
+	s.register("alice")
+	s.register("bob")
+	alice := s.login("alice")
+	bob := s.login("bob")
+
+	const messages = 50
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			rec := httptest.NewRecorder()
+			s.srv.GetClientsHandler(rec, httptest.NewRequest("GET", "/api/clients", nil))
+			var clients []user.ClientInfo
+			if err := json.Unmarshal(rec.Body.Bytes(), &clients); err != nil {
+				t.Errorf("解析客户端列表失败: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < messages; i++ {
+		if err := alice.SendMessage(bob.ID, strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < messages; i++ {
+		expect(t, bob, "message")
+	}
+	close(stop)
+	wg.Wait()
+
+	rec := httptest.NewRecorder()
+	s.srv.GetClientsHandler(rec, httptest.NewRequest("GET", "/api/clients", nil))
+	var clients []user.ClientInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &clients); err != nil {
+		t.Fatalf("解析客户端列表失败: %v", err)
+	}
+	for _, c := range clients {
+		if c.ID == alice.ID {
+			if c.MessageCount < messages {
+				t.Fatalf("alice 的消息数 = %d, 期望至少 %d", c.MessageCount, messages)
+			}
+			if c.LastActive.Before(c.ConnectTime) {
+				t.Fatalf("alice 的最后活动时间 %v 早于登录时间 %v", c.LastActive, c.ConnectTime)
+			}
+			return
+		}
+	}
+	t.Fatalf("客户端列表中没有 alice: %+v", clients)
+}
+
+func TestOfflineMessageDeliveredOnLogin(t *testing.T) {
+	s := startServer(t)
+	s.register("alice")
+	bobID := s.register("bob")
+	alice := s.login("alice")
+
+	for _, content := range []string{"first", "second"} {
+		if err := alice.SendMessage(bobID, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitOnline(t, alice)
+
+	chats, err := databasetool.GetUnsendChatsByReciveID(s.db, bobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chats) != 2 {
+		t.Fatalf("暂存消息数 = %d, 期望 2", len(chats))
+	}
+
+	// 暂存消息按发送时间倒序补发，这里只检查是否全部送达
+	bob := s.login("bob")
+	received := map[string]bool{}
+	for range chats {
+		msg := expect(t, bob, "message")
+		if msg.String("sendid") != alice.ID {
+			t.Fatalf("暂存消息的发送者 = %s, 期望 %s", msg.String("sendid"), alice.ID)
+		}
+		received[msg.String("content")] = true
+	}
+	if !received["first"] || !received["second"] {
+		t.Fatalf("收到的暂存消息 = %v", received)
+	}
+	waitOnline(t, bob)
+
+	if chats, _ := databasetool.GetUnsendChatsByReciveID(s.db, bobID); len(chats) != 0 {
+		t.Fatalf("送达后仍有 %d 条暂存消息", len(chats))
+	}
+}
+
+func TestFriendFlow(t *testing.T) {
+	s := startServer(t)
+	s.register("alice")
+	s.register("bob")
+	alice := s.login("alice")
+	bob := s.login("bob")
+
+	if err := alice.AddFriend("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if resp := expect(t, alice, "addfriend_response"); resp.String("status") != "success" {
+		t.Fatalf("添加好友响应 = %v", resp.Msg)
+	}
+	req := expect(t, bob, "addfriend_request")
+	if req.String("addid") != alice.ID || req.String("addname") != "alice" {
+		t.Fatalf("好友请求 = %v", req.Msg)
+	}
+
+	if err := bob.AcceptFriend("alice"); err != nil {
+		t.Fatal(err)
+	}
+	accepted := expect(t, alice, "acceptfriend_response")
+	if accepted.String("userid") != bob.ID || accepted.String("username") != "bob" {
+		t.Fatalf("接受好友通知 = %v", accepted.Msg)
+	}
+
+	// 重新登录后双方的好友列表都包含对方，在线状态来自服务器的连接
+	s.logout(alice)
+	alice = s.login("alice")
+	if len(alice.Friends) != 1 || alice.Friends[0].UserID != bob.ID || !alice.Friends[0].Online {
+		t.Fatalf("alice 的好友列表 = %+v", alice.Friends)
+	}
+	s.logout(bob)
+	s.logout(alice)
+	bob = s.login("bob")
+	if len(bob.Friends) != 1 || bob.Friends[0].Name != "alice" || bob.Friends[0].Online {
+		t.Fatalf("bob 的好友列表 = %+v", bob.Friends)
+	}
+}
+
+func TestFriendRequestToOfflineUser(t *testing.T) {
+	s := startServer(t)
+	s.register("alice")
+	s.register("bob")
+	alice := s.login("alice")
+
+	if err := alice.AddFriend("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if resp := expect(t, alice, "addfriend_response"); resp.String("status") != "success" {
+		t.Fatalf("添加好友响应 = %v", resp.Msg)
+	}
+
+	if err := alice.AddFriend("nobody"); err != nil {
+		t.Fatal(err)
+	}
+	if resp := expect(t, alice, "addfriend_response"); resp.String("status") != "fail" {
+		t.Fatalf("添加不存在的用户应失败, 响应 = %v", resp.Msg)
+	}
+
+	bob := s.login("bob")
+	if req := expect(t, bob, "addfriend_request"); req.String("addid") != alice.ID {
+		t.Fatalf("上线后收到的好友请求 = %v", req.Msg)
+	}
+
+	// alice 离线时 bob 接受，通知暂存到 alice 上线
+	s.logout(alice)
+	if err := bob.AcceptFriend("alice"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, bob, "acceptfriend_response")
+	alice = s.login("alice")
+	if notice := expect(t, alice, "friend_accepted"); notice.String("userid") != bob.ID {
+		t.Fatalf("上线后收到的好友接受通知 = %v", notice.Msg)
+	}
+	if len(alice.Friends) != 1 || alice.Friends[0].UserID != bob.ID {
+		t.Fatalf("alice 的好友列表 = %+v", alice.Friends)
+	}
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestFileTransferOnline(t *testing.T) {
+	s := startServer(t)
+	s.register("alice")
+	s.register("bob")
+	alice := s.login("alice")
+	bob := s.login("bob")
+
+	data := randomBytes(t, 300*1024)
+	if err := alice.SendFile(bob.ID, "t1", "photo.png", data, 50*1024); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded := expect(t, alice, "file_uploaded"); uploaded.String("transferid") != "t1" {
+		t.Fatalf("上传完成通知 = %v", uploaded.Msg)
+	}
+
+	offer := expect(t, bob, "file_offer")
+	if offer.String("filename") != "photo.png" || offer.String("sendid") != alice.ID || offer.String("size") != "307200" {
+		t.Fatalf("文件邀约 = %v", offer.Msg)
+	}
+	got, err := bob.ReceiveFile(offer.String("transferid"))
+	if err != nil {
+		t.Fatalf("接收文件失败: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("收到的文件内容与发送的不一致")
+	}
+	if done := expect(t, alice, "file_accepted"); done.String("transferid") != "t1" || done.String("receiveid") != bob.ID {
+		t.Fatalf("文件送达通知 = %v", done.Msg)
+	}
+}
+
+func TestFileTransferOffline(t *testing.T) {
+	s := startServer(t)
+	s.register("alice")
+	bobID := s.register("bob")
+	alice := s.login("alice")
+
+	data := randomBytes(t, 10*1024)
+	if err := alice.SendFile(bobID, "t2", "notes.txt", data, 0); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, alice, "file_uploaded")
+
+	bob := s.login("bob")
+	offer := expect(t, bob, "file_offer")
+	if offer.String("filename") != "notes.txt" {
+		t.Fatalf("上线后收到的文件邀约 = %v", offer.Msg)
+	}
+
+	// 没有处理邀约就下线，再次登录时仍会收到
+	bob.Close()
+	if err := bob.WaitClosed(); err != nil {
+		t.Fatal(err)
+	}
+	bob = s.login("bob")
+	if again := expect(t, bob, "file_offer"); again.String("transferid") != offer.String("transferid") {
+		t.Fatalf("再次登录收到的文件邀约 = %v", again.Msg)
+	}
+
+	// 拒收后文件被删除，发送方收到通知，再次确认会失败
+	if err := bob.FileMessage("file_decline", offer.String("transferid")); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, alice, "file_declined")
+	if err := bob.FileMessage("file_accept", offer.String("transferid")); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, bob, "file_error")
+}
+
+func TestFileHashMismatch(t *testing.T) {
+	s := startServer(t)
+	s.register("alice")
+	bobID := s.register("bob")
+	alice := s.login("alice")
+
+	header := `{"type":"file_transfer","transferid":"bad","filename":"a.bin","size":"4","receiveid":"` + bobID +
+		`","sha256":"0000000000000000000000000000000000000000000000000000000000000000"}`
+	if err := alice.SendRaw(frame.TypeFileHeader, []byte(header)); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.SendRaw(frame.TypeFileChunk, filetransfer.EncodeChunk("bad", []byte("1234"))); err != nil {
+		t.Fatal(err)
+	}
+	if fail := expect(t, alice, "file_error"); fail.String("transferid") != "bad" {
+		t.Fatalf("校验失败通知 = %v", fail.Msg)
+	}
+}
+
+func TestShutdownNotifiesClients(t *testing.T) {
+	s := startServer(t)
+	s.register("alice")
+	alice := s.login("alice")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.srv.Shutdown(ctx); err != nil {
+		t.Fatalf("关闭服务器失败: %v", err)
+	}
+	expect(t, alice, "server_shutdown")
+	if err := alice.WaitClosed(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chattest.Dial(s.addr); err == nil {
+		t.Fatal("关闭后不应再接受连接")
+	}
+}
@@ -0,0 +1,179 @@
+package tcpnetwork
+
+//监控指标：在线人数、登录、消息路由、离线积压及各处理函数的耗时
+import (
+	"connection_server_linux/databasetool"
+	"connection_server_linux/filetransfer"
+	"connection_server_linux/metrics"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 登录结果，作为 chat_login_attempts_total 的 result 标签
+const (
+	loginSuccess     = "success"
+	loginUnknownUser = "unknown_user"
+	loginBadPassword = "bad_password"
+	loginLocked      = "locked"
+	loginDisabled    = "disabled"
+	loginBanned      = "banned"
+	loginError       = "error"
+)
+
+var (
+	connectionsTotal = metrics.NewCounter("chat_connections_total", "接受的TCP连接总数")
+	loginAttempts    = metrics.NewCounterVec("chat_login_attempts_total", "TCP客户端登录次数，按结果分组", "result")
+	messagesRouted   = metrics.NewCounter("chat_messages_routed_total", "直接转发给在线接收者的聊天消息数")
+	messagesQueued   = metrics.NewCounter("chat_messages_queued_total", "接收者离线而暂存的聊天消息数")
+
+	tcpHandlerDuration = metrics.NewHistogramVec("chat_tcp_handler_duration_seconds",
+		"TCP消息处理耗时，按消息类型分组", metrics.DefBuckets, "handler")
+	httpRequestDuration = metrics.NewHistogramVec("chat_http_request_duration_seconds",
+		"HTTP请求处理耗时，按路由和方法分组", metrics.DefBuckets, "route", "method")
+	httpRequests = metrics.NewCounterVec("chat_http_requests_total",
+		"HTTP请求数，按路由、方法和状态码分组", "route", "method", "code")
+)
+
+// servers 已创建且未关闭的聊天服务器，在线人数和积压消息数按所有实例汇总
+var (
+	serversMu sync.Mutex
+	servers   = make(map[*Server]bool)
+)
+
+func registerServer(srv *Server) {
+	serversMu.Lock()
+	servers[srv] = true
+	serversMu.Unlock()
+}
+
+func unregisterServer(srv *Server) {
+	serversMu.Lock()
+	delete(servers, srv)
+	serversMu.Unlock()
+}
+
+// liveServers 返回当前所有聊天服务器
+func liveServers() []*Server {
+	serversMu.Lock()
+	defer serversMu.Unlock()
+	list := make([]*Server, 0, len(servers))
+	for srv := range servers {
+		list = append(list, srv)
+	}
+	return list
+}
+
+func init() {
+	for _, result := range []string{loginSuccess, loginUnknownUser, loginBadPassword, loginLocked, loginDisabled, loginBanned, loginError} {
+		loginAttempts.WithLabelValues(result)
+	}
+
+	metrics.NewGaugeFunc("chat_connected_clients", "当前在线的客户端数", func() float64 {
+		total := 0
+		for _, srv := range liveServers() {
+			srv.clients.Mutex.RLock()
+			total += len(srv.clients.Clients)
+			srv.clients.Mutex.RUnlock()
+		}
+		return float64(total)
+	})
+	metrics.NewGaugeFunc("chat_unsent_messages", "Unsendchat表中等待接收者上线的暂存消息数", func() float64 {
+		var total int64
+		for _, srv := range liveServers() {
+			count, err := databasetool.CountUnsendChats(srv.db)
+			if err != nil {
+				slog.Error("统计暂存消息失败", "err", err)
+				return math.NaN()
+			}
+			total += count
+		}
+		return float64(total)
+	})
+}
+
+// countLogin 记录一次TCP登录结果
+func countLogin(result string) {
+	loginAttempts.WithLabelValues(result).Inc()
+}
+
+// handlerLabel 将消息类型转换为指标标签，未知类型归为一类，避免客户端制造大量标签
+func handlerLabel(msgType string) string {
+	switch msgType {
+	case "login", "register", "message", "changepwd", "changename", "addfriend", "acceptfriend":
+		return msgType
+	}
+	if filetransfer.IsFileMessage(msgType) {
+		return msgType
+	}
+	return "unknown"
+}
+
+// observeHandler 记录TCP消息处理耗时
+func observeHandler(handler string, start time.Time) {
+	tcpHandlerDuration.WithLabelValues(handler).ObserveSince(start)
+}
+
+// statusRecorder 记录响应状态码，并保留 Flusher 供SSE使用
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// methodLabel 非标准的请求方法归为一类
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "other"
+}
+
+// MetricsMiddleware 统计HTTP请求数和耗时，路由标签使用路由模板而不是实际路径
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		method := methodLabel(r.Method)
+		httpRequestDuration.WithLabelValues(route, method).ObserveSince(start)
+		httpRequests.WithLabelValues(route, method, strconv.Itoa(recorder.status)).Inc()
+	})
+}
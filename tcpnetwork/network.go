@@ -2,105 +2,26 @@ package tcpnetwork
 
 import (
 	"connection_server_linux/databasetool"
+	"connection_server_linux/events"
+	"connection_server_linux/filetransfer"
+	"connection_server_linux/frame"
 	"connection_server_linux/friendupdate"
+	"connection_server_linux/logging"
+	"connection_server_linux/logincheck"
 	"connection_server_linux/user"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"math"
 	"net"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"gorm.io/gorm"
 )
 
-// FileHeader 文件头信息
-type FileHeader struct {
-	Type      string   `json:"type"`      // 固定为"file_transfer"
-	Filename  string   `json:"filename"`  // 文件名
-	Size      FileSize `json:"size"`      // 文件大小(兼容字符串/数字)
-	SendID    string   `json:"sendid"`    // 发送者ID
-	ReceiveID string   `json:"receiveid"` // 接收者ID
-}
-
-// FileSize 兼容字符串和数字的文件大小字段
-type FileSize string
-
-// UnmarshalJSON 兼容客户端发送的数字或字符串大小
-func (s *FileSize) UnmarshalJSON(data []byte) error {
-	if len(data) == 0 {
-		*s = ""
-		return nil
-	}
-
-	if data[0] == '"' {
-		var value string
-		if err := json.Unmarshal(data, &value); err != nil {
-			return err
-		}
-		*s = FileSize(value)
-		return nil
-	}
-
-	var value json.Number
-	if err := json.Unmarshal(data, &value); err != nil {
-		return err
-	}
-	*s = FileSize(value.String())
-	return nil
-}
-
-// String 返回字符串形式的文件大小
-func (s FileSize) String() string {
-	return string(s)
-}
-
-// FileStorage 文件存储结构
-type FileStorage struct {
-	Filename   string
-	FilePath   string // 添加文件路径字段
-	FileSize   int64
-	Received   int64
-	SendTime   time.Time
-	SenderID   string
-	ReceiverID string
-}
-
-var (
-	pendingFiles    = make(map[string]*FileStorage)   // 待接收文件缓存
-	uploadSessions  = make(map[string]*UploadSession) // 正在接收中的文件会话
-	fileMutex       sync.Mutex                        // 文件操作互斥锁
-	fileStoragePath string                            // 文件存储目录
-)
-
-var (
-	TcpAddr *net.TCPAddr // 全局TCP地址
-	db      *gorm.DB     // 添加全局db引用
-)
-
-func InitDBConnection(globalDB *gorm.DB) {
-	db = globalDB
-}
-
-func init() {
-	if wd, err := os.Getwd(); err == nil {
-		fileStoragePath = filepath.Join(wd, "file_storage")
-	} else {
-		fileStoragePath = filepath.Join(".", "file_storage")
-	}
-
-	if err := os.MkdirAll(fileStoragePath, 0755); err != nil {
-		log.Printf("创建文件存储目录失败 %s: %v", fileStoragePath, err)
-	}
-}
-
 // LoginRequest 客户端登录请求结构
 type LoginRequest struct {
 	Type     string `json:"type"` // 消息类型，固定为"login"
@@ -130,55 +51,14 @@ type RegisterResponse struct {
 	Message string `json:"message"` // 返回消息
 }
 
-// UploadSession 文件上传中的临时会话
-type UploadSession struct {
-	FileKey    string
-	FilePath   string
-	File       *os.File
-	FileSize   int64
-	Received   int64
-	SenderID   string
-	ReceiverID string
-	Filename   string
-}
-
 // readFramedPacket 读取 8 字节包头：4字节类型 + 4字节长度
 func readFramedPacket(conn net.Conn) (uint32, []byte, error) {
-	header := make([]byte, 8)
-	if _, err := io.ReadFull(conn, header); err != nil {
-		return 0, nil, fmt.Errorf("读取包头失败: %v", err)
-	}
-
-	packetType := binary.BigEndian.Uint32(header[:4])
-	payloadLen := binary.BigEndian.Uint32(header[4:])
-	if payloadLen == 0 {
-		return packetType, nil, errors.New("空消息")
-	}
-
-	payload := make([]byte, payloadLen)
-	if _, err := io.ReadFull(conn, payload); err != nil {
-		return packetType, nil, fmt.Errorf("读取消息体失败: %v", err)
-	}
-
-	return packetType, payload, nil
+	return frame.Read(conn)
 }
 
 // writeFramedPacket 写入 8 字节包头和消息体
 func writeFramedPacket(conn net.Conn, packetType uint32, payload []byte) error {
-	header := make([]byte, 8)
-	binary.BigEndian.PutUint32(header[:4], packetType)
-	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
-
-	if _, err := conn.Write(header); err != nil {
-		return fmt.Errorf("写入包头失败: %v", err)
-	}
-	if len(payload) == 0 {
-		return nil
-	}
-	if _, err := conn.Write(payload); err != nil {
-		return fmt.Errorf("写入消息体失败: %v", err)
-	}
-	return nil
+	return frame.Write(conn, packetType, payload)
 }
 
 // writeFramedBytes 写入 JSON 包，类型固定为 1
@@ -210,9 +90,8 @@ func sendRegisterResponse(conn net.Conn, status string, userID uint, message str
 }
 
 // handleLogin 处理登录验证
-func handleLogin(conn net.Conn, cleanData []byte) (*user.Client, error) {
-	fmt.Println("清理后的登录数据:", string(cleanData))
-
+// logger 为该连接的日志记录器，登录成功后附加用户ID保存到 Client.Log
+func (srv *Server) handleLogin(conn net.Conn, cleanData []byte, logger *slog.Logger) (*user.Client, error) {
 	var loginReq LoginRequest
 	if err := json.Unmarshal(cleanData, &loginReq); err != nil {
 		return nil, fmt.Errorf("解析登录数据失败: %v", err)
@@ -223,48 +102,102 @@ func handleLogin(conn net.Conn, cleanData []byte) (*user.Client, error) {
 	}
 
 	username := loginReq.Username
-	userRecord, err := databasetool.FindUserByName(db, username)
+	ip := remoteIP(conn)
+	logger.Debug("收到登录请求", "username", username)
+	if wait, ok := srv.limiter.Check(logincheck.SurfaceTCP, ip, username); !ok {
+		sendLoginResponse(conn, false, lockoutMessage(wait))
+		countLogin(loginLocked)
+		srv.audit(databasetool.AuditLogin, "", "", ip, false, "用户名: "+username+"，登录已被锁定")
+		return nil, fmt.Errorf("登录被限流: ip=%s 用户名=%s", ip, username)
+	}
+
+	userRecord, err := databasetool.FindUserByName(srv.db, username)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
+			srv.limiter.Fail(logincheck.SurfaceTCP, ip, username)
 			sendLoginResponse(conn, false, "账号不存在")
+			countLogin(loginUnknownUser)
+			srv.audit(databasetool.AuditLogin, "", "", ip, false, "用户名: "+username+"，账号不存在")
 			return nil, errors.New("账号不存在")
 		}
 		sendLoginResponse(conn, false, "数据库错误")
+		countLogin(loginError)
 		return nil, fmt.Errorf("数据库查询错误: %v", err)
 	}
 
 	if userRecord.Password != loginReq.Password {
-		sendLoginResponse(conn, false, "用户名或密码错误")
+		if wait := srv.limiter.Fail(logincheck.SurfaceTCP, ip, username); wait > 0 {
+			sendLoginResponse(conn, false, lockoutMessage(wait))
+		} else {
+			sendLoginResponse(conn, false, "用户名或密码错误")
+		}
+		countLogin(loginBadPassword)
+		srv.audit(databasetool.AuditLogin, userActor(userRecord.ID), userActor(userRecord.ID), ip, false, "密码错误")
 		return nil, errors.New("用户名或密码错误")
 	}
+	srv.limiter.Succeed(logincheck.SurfaceTCP, username)
+
+	if userRecord.Disabled {
+		sendLoginResponse(conn, false, "账号已被禁用，请联系管理员")
+		countLogin(loginDisabled)
+		srv.audit(databasetool.AuditLogin, userActor(userRecord.ID), userActor(userRecord.ID), ip, false, "账号已被禁用")
+		return nil, fmt.Errorf("用户 %s 已被禁用", username)
+	}
+
+	if ban, err := databasetool.FindActiveBan(srv.db, databasetool.BanKindUser, strconv.FormatUint(uint64(userRecord.ID), 10), time.Now()); err == nil {
+		sendLoginResponse(conn, false, banMessage("账号", ban))
+		countLogin(loginBanned)
+		srv.audit(databasetool.AuditLogin, userActor(userRecord.ID), ban.Target, ip, false, banMessage("账号", ban))
+		return nil, fmt.Errorf("用户 %s 处于封禁中", username)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		sendLoginResponse(conn, false, "数据库错误")
+		countLogin(loginError)
+		return nil, fmt.Errorf("查询封禁记录失败: %v", err)
+	}
 
-	userRecord.Status = 1
-	userRecord.Ip = conn.RemoteAddr().(*net.TCPAddr).IP.String()
-	userRecord.LeaveTime = time.Now()
-	if err := db.Save(&userRecord).Error; err != nil {
+	// LeaveTime 保留上次离线的时间，断开时再更新
+	if err := databasetool.UserOnline(srv.db, int(userRecord.ID), ip); err != nil {
 		sendLoginResponse(conn, false, "服务器错误")
+		countLogin(loginError)
 		return nil, fmt.Errorf("更新用户状态失败: %v", err)
 	}
 
 	client := &user.Client{
 		Conn:        conn,
 		ID:          fmt.Sprintf("%d", userRecord.ID),
-		IP:          conn.RemoteAddr().(*net.TCPAddr).IP.String(),
+		IP:          ip,
 		ConnectTime: time.Now(),
-		LastActive:  time.Now(),
 		Friends:     make([]user.FriendInfo, 0),
 	}
+	client.Touch(client.ConnectTime)
+	client.Log = logger.With("user", client.ID)
 
-	user.Manager.Mutex.Lock()
-	user.Manager.Clients[client.ID] = client
-	user.Manager.Mutex.Unlock()
+	srv.clients.Mutex.Lock()
+	srv.clients.Clients[client.ID] = client
+	srv.clients.Mutex.Unlock()
 
 	sendLoginResponse(conn, true, "id:"+fmt.Sprintf("%d", userRecord.ID))
+	countLogin(loginSuccess)
+	srv.audit(databasetool.AuditLogin, client.ID, client.ID, ip, true, "")
 	return client, nil
 }
 
+// remoteIP 客户端的IP，不是TCP连接(如测试中的 net.Pipe)时返回空字符串
+func remoteIP(conn net.Conn) string {
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && tcpAddr.IP != nil {
+		return tcpAddr.IP.String()
+	}
+	return ""
+}
+
+// lockoutMessage 登录被锁定时返回给客户端的提示
+func lockoutMessage(wait time.Duration) string {
+	seconds := int(math.Ceil(wait.Seconds()))
+	return fmt.Sprintf("登录失败次数过多，请在%d秒后重试", seconds)
+}
+
 // handleRegister 处理注册请求
-func handleRegister(conn net.Conn, cleanData []byte) error {
+func (srv *Server) handleRegister(conn net.Conn, cleanData []byte, logger *slog.Logger) error {
 	var registerReq RegisterRequest
 	if err := json.Unmarshal(cleanData, &registerReq); err != nil {
 		return fmt.Errorf("解析注册数据失败: %v", err)
@@ -279,7 +212,7 @@ func handleRegister(conn net.Conn, cleanData []byte) error {
 		return errors.New("用户名或密码不能为空")
 	}
 
-	if _, err := databasetool.FindUserByName(db, registerReq.Username); err == nil {
+	if _, err := databasetool.FindUserByName(srv.db, registerReq.Username); err == nil {
 		sendRegisterResponse(conn, "fail", 0, "用户名已存在")
 		return errors.New("用户名已存在")
 	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
@@ -287,23 +220,21 @@ func handleRegister(conn net.Conn, cleanData []byte) error {
 		return fmt.Errorf("查询用户名失败: %v", err)
 	}
 
-	ip := ""
-	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && tcpAddr.IP != nil {
-		ip = tcpAddr.IP.String()
-	}
-
-	userID, err := databasetool.RegisterUser(db, registerReq.Username, registerReq.Password, ip)
+	ip := remoteIP(conn)
+	userID, err := databasetool.RegisterUser(srv.db, registerReq.Username, registerReq.Password, ip)
 	if err != nil {
 		sendRegisterResponse(conn, "fail", 0, "注册失败")
 		return fmt.Errorf("注册用户失败: %v", err)
 	}
 
 	sendRegisterResponse(conn, "success", userID, "注册成功")
+	logger.Info("注册成功", "username", registerReq.Username, "user", userID)
+	srv.audit(databasetool.AuditRegister, userActor(userID), userActor(userID), ip, true, "用户名: "+registerReq.Username)
 	return nil
 }
 
 // handleInitialConnection 处理TCP首条消息，支持登录和注册
-func handleInitialConnection(conn net.Conn) (*user.Client, error) {
+func (srv *Server) handleInitialConnection(conn net.Conn, logger *slog.Logger) (*user.Client, error) {
 	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
 	defer conn.SetReadDeadline(time.Time{})
 
@@ -319,14 +250,18 @@ func handleInitialConnection(conn net.Conn) (*user.Client, error) {
 		Type string `json:"type"`
 	}
 	if err := json.Unmarshal(cleanData, &msgType); err != nil {
+		frame.CountError(frame.ErrorInvalidJSON)
 		return nil, fmt.Errorf("解析首条消息类型失败: %v", err)
 	}
 
+	start := time.Now()
 	switch msgType.Type {
 	case "login":
-		return handleLogin(conn, cleanData)
+		defer observeHandler("login", start)
+		return srv.handleLogin(conn, cleanData, logger)
 	case "register":
-		if err := handleRegister(conn, cleanData); err != nil {
+		defer observeHandler("register", start)
+		if err := srv.handleRegister(conn, cleanData, logger); err != nil {
 			return nil, err
 		}
 		return nil, nil
@@ -336,39 +271,64 @@ func handleInitialConnection(conn net.Conn) (*user.Client, error) {
 }
 
 // HandleConnection 处理新TCP连接
-func HandleConnection(conn net.Conn) {
+func (srv *Server) HandleConnection(conn net.Conn) {
 	defer conn.Close()
+	connectionsTotal.Inc()
+
+	// 每个连接分配一个ID，该连接上的所有日志都带有这个ID
+	logger := slog.Default().With("conn", logging.NewID(), "remote", conn.RemoteAddr().String())
+	logger.Debug("接受TCP连接")
+
+	// 被封禁的IP在处理首包前直接拒绝
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip := tcpAddr.IP.String()
+		if ban, err := databasetool.FindActiveBan(srv.db, databasetool.BanKindIP, ip, time.Now()); err == nil {
+			sendLoginResponse(conn, false, banMessage("该IP", ban))
+			logger.Warn("拒绝被封禁IP的连接", "ban", ban.ID)
+			return
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Error("查询IP封禁记录失败", "err", err)
+		}
+	}
 
-	client, err := handleInitialConnection(conn)
+	client, err := srv.handleInitialConnection(conn, logger)
 	if err != nil {
-		log.Printf("首包处理失败: %v", err)
+		logger.Warn("首包处理失败", "err", err)
 		return
 	}
 	if client == nil {
 		return
 	}
-	log.Printf("新客户端连接: %s", client.ID)
+	client.Log.Info("新客户端连接")
+	srv.events.Publish(events.ClientConnected, map[string]interface{}{
+		"id":           client.ID,
+		"ip":           client.IP,
+		"connect_time": client.ConnectTime,
+	})
 
 	// 2. 初始化好友列表
-	if err := setupFriendList(client); err != nil {
-		log.Printf("初始化好友列表失败 %s: %v", client.ID, err)
+	if err := srv.setupFriendList(client); err != nil {
+		client.Log.Error("初始化好友列表失败", "err", err)
 		return
 	}
 
 	// 3. 检查并发送待接收消息
-	chats, err := databasetool.GetUnsendChatsByReciveID(db, client.ID)
+	chats, err := databasetool.GetUnsendChatsByReciveID(srv.db, client.ID)
 	if err != nil {
-		log.Printf("检查待接收消息失败 %s: %v", client.ID, err)
+		client.Log.Error("检查待接收消息失败", "err", err)
 		return
 	}
 
 	for _, chat := range chats {
 		// 处理不同类型的暂存消息
 		if strings.HasPrefix(chat.Content, "file:") {
-			// 处理文件消息
-			filekey := strings.TrimPrefix(chat.Content, "file:")
-			if err := checkPendingFiles(client, filekey); err != nil {
-				log.Printf("发送待接收文件失败 %s: %v", client.ID, err)
+			// 旧版本用暂存消息记录离线文件，文件邀约现在由 OfferPending 统一补发，这里只删除记录
+		} else if chat.Sendid == systemSenderID && strings.HasPrefix(chat.Content, announcementPrefix) {
+			// 处理离线期间的系统公告
+			content := strings.TrimPrefix(chat.Content, announcementPrefix)
+			if err := sendAnnouncement(client.Conn, client.ID, content, chat.SendTime); err != nil {
+				client.Log.Error("发送系统公告失败", "err", err)
+				continue
 			}
 		} else if strings.HasPrefix(chat.Content, "addfriend_request:") {
 			// 处理好友请求
@@ -389,12 +349,12 @@ func HandleConnection(conn net.Conn) {
 				// 发送好友请求
 				reqBytes, err := json.Marshal(addFriendReq)
 				if err != nil {
-					log.Printf("序列化好友请求失败 %s: %v", client.ID, err)
+					client.Log.Error("序列化好友请求失败", "err", err)
 					continue
 				}
 
 				if err := writeFramedBytes(client.Conn, reqBytes); err != nil {
-					log.Printf("发送好友请求失败 %s: %v", client.ID, err)
+					client.Log.Error("发送好友请求失败", "err", err)
 					continue
 				}
 			}
@@ -417,12 +377,12 @@ func HandleConnection(conn net.Conn) {
 				// 发送好友接受通知
 				noticeBytes, err := json.Marshal(acceptNotice)
 				if err != nil {
-					log.Printf("序列化好友接受通知失败 %s: %v", client.ID, err)
+					client.Log.Error("序列化好友接受通知失败", "err", err)
 					continue
 				}
 
 				if err := writeFramedBytes(client.Conn, noticeBytes); err != nil {
-					log.Printf("发送好友接受通知失败 %s: %v", client.ID, err)
+					client.Log.Error("发送好友接受通知失败", "err", err)
 					continue
 				}
 			}
@@ -438,74 +398,86 @@ func HandleConnection(conn net.Conn) {
 
 			msgBytes, err := json.Marshal(chatMsg)
 			if err != nil {
-				log.Printf("序列化消息失败 %s: %v", client.ID, err)
+				client.Log.Error("序列化暂存消息失败", "log_id", chat.Logid, "err", err)
 				continue
 			}
 
 			if err := writeFramedBytes(client.Conn, msgBytes); err != nil {
-				log.Printf("发送消息失败 %s: %v", client.ID, err)
+				client.Log.Error("发送暂存消息失败", "log_id", chat.Logid, "err", err)
 				continue
 			}
 		}
 
 		// 从数据库中删除已发送的消息
-		if err := databasetool.DeleteUnsendChat(db, chat.Logid); err != nil {
-			log.Printf("删除已发送消息失败 %s: %v", client.ID, err)
+		if err := databasetool.DeleteUnsendChat(srv.db, chat.Logid); err != nil {
+			client.Log.Error("删除已发送消息失败", "log_id", chat.Logid, "err", err)
 			continue
 		}
 	}
 
+	client.Log.Debug("已发送暂存消息", "count", len(chats))
+
+	// 补发尚未处理的文件邀约，文件被确认、拒收或超过保存期限之前每次登录都会收到
+	if count, err := srv.files.OfferPending(client.ID, client.Conn); err != nil {
+		client.Log.Error("发送待接收文件邀约失败", "err", err)
+	} else if count > 0 {
+		client.Log.Debug("已发送待接收文件邀约", "count", count)
+	}
+
 	// 4. 进入消息处理循环
-	messageLoop(client)
+	srv.messageLoop(client)
 }
 
 // setupFriendList 初始化好友列表
-func setupFriendList(client *user.Client) error {
+func (srv *Server) setupFriendList(client *user.Client) error {
 
 	s := client.ID
 	num, err := strconv.Atoi(s)
 	if err != nil {
-		log.Fatal("转换失败:", err)
+		return fmt.Errorf("无效的用户ID %q: %v", s, err)
 	}
 	// 查询用户数据
-	userRecord, err := databasetool.FindUserById(db, num)
+	userRecord, err := databasetool.FindUserById(srv.db, num)
 	if err != nil {
 		return fmt.Errorf("查询用户失败: %v", err)
 	}
 
-	fmt.Println(userRecord.Relation)
 	// 解析好友关系字节
 	friendStatuses := friendupdate.AnalyzeRelationByte(userRecord.Relation)
 	friendStatuses[0] = int(userRecord.ID) //第一个位置放自己的ID
 
-	fmt.Println(friendStatuses)
-	// 构建好友列表
+	// 构建好友列表，客户端已加入管理器，写回时需持有管理器的锁
+	friends := make([]user.FriendInfo, 0)
 	for i := 1; i < len(friendStatuses); i++ {
 		if friendStatuses[i] == friendupdate.Friend {
 
 			friendID := fmt.Sprintf("%d", i)
-			friend, err := databasetool.FindUserById(db, i)
+			friend, err := databasetool.FindUserById(srv.db, i)
 			if err != nil {
 				if errors.Is(err, gorm.ErrRecordNotFound) {
 					continue // 跳过不存在的好友
 				}
-				fmt.Errorf("查询好友失败: %v", err)
+				client.Log.Error("查询好友失败", "friend", i, "err", err)
 				continue
 			}
 
-			client.Friends = append(client.Friends, user.FriendInfo{
+			friends = append(friends, user.FriendInfo{
 				UserID: friendID,
 				Name:   friend.Name,
 				Status: friendStatuses[i],
-				Online: friend.Status == 1,
+				Online: srv.clients.IsOnline(friendID),
 			})
 		}
 	}
 
+	srv.clients.Mutex.Lock()
+	client.Friends = friends
+	srv.clients.Mutex.Unlock()
+
 	// 发送好友列表
 	friendListMsg := user.FriendListMessage{
 		Type:    "friend_list",
-		Friends: client.Friends,
+		Friends: friends,
 	}
 	msgBytes, err := json.Marshal(friendListMsg)
 	if err != nil {
@@ -516,83 +488,105 @@ func setupFriendList(client *user.Client) error {
 		return fmt.Errorf("发送好友列表失败: %v", err)
 	}
 
+	client.Log.Debug("已发送好友列表", "friends", len(friends))
 	return nil
 }
 
 // messageLoop 消息处理循环
-func messageLoop(client *user.Client) {
+func (srv *Server) messageLoop(client *user.Client) {
 	for {
 		packetType, messageData, err := readFramedPacket(client.Conn)
 		if err != nil {
-			log.Printf("客户端 %s 断开连接: %v", client.ID, err)
-			cleanupClient(client)
+			client.Log.Info("客户端断开连接", "err", err)
+			srv.cleanupClient(client)
 			return
 		}
 
-		client.LastActive = time.Now()
+		now := time.Now()
+		client.Touch(now)
 		switch packetType {
-		case 1:
-			if err := handleMessage(client, messageData); err != nil {
-				log.Printf("处理JSON消息失败 %s: %v", client.ID, err)
+		case frame.TypeJSON:
+			srv.events.Publish(events.MessageCount, map[string]interface{}{
+				"id":            client.ID,
+				"message_count": client.CountMessage(),
+				"last_active":   now,
+			})
+			if err := srv.handleMessage(client, messageData); err != nil {
+				client.Log.Warn("处理JSON消息失败", "err", err)
 			}
-		case 2:
-			if err := handleFileChunk(client, messageData); err != nil {
-				log.Printf("处理文件数据失败 %s: %v", client.ID, err)
+		case frame.TypeFileChunk:
+			start := time.Now()
+			if err := srv.files.HandleChunk(client.ID, client.Conn, messageData); err != nil {
+				client.Log.Warn("处理文件数据失败", "err", err)
 			}
-		case 3:
-			if err := handleFileHeaderPacket(client, messageData); err != nil {
-				log.Printf("处理文件头失败 %s: %v", client.ID, err)
+			observeHandler("file_chunk", start)
+		case frame.TypeFileHeader:
+			start := time.Now()
+			if err := srv.files.HandleHeader(client.ID, client.Conn, messageData); err != nil {
+				client.Log.Warn("处理文件头失败", "err", err)
 			}
+			observeHandler("file_header", start)
 		default:
-			log.Printf("未知包类型 %d 来自 %s", packetType, client.ID)
+			frame.CountError(frame.ErrorUnknownType)
+			client.Log.Warn("未知包类型", "packet_type", packetType)
 		}
 	}
 }
 
 // cleanupClient 清理客户端资源
-func cleanupClient(client *user.Client) {
-
-	if err := db.Model(&databasetool.User{}).Where("id = ?", client.ID).Updates(map[string]interface{}{
-		"Status":    0, // 0表示离线
-		"LeaveTime": time.Now(),
-	}).Error; err != nil {
-		log.Printf("更新用户状态失败 %s: %v", client.ID, err)
+func (srv *Server) cleanupClient(client *user.Client) {
+	srv.files.ReleaseClient(client.ID, client.Conn)
+
+	// 从管理器移除，同一账号已重新登录时不影响新连接
+	srv.clients.Mutex.Lock()
+	current := srv.clients.Clients[client.ID] == client
+	if current {
+		delete(srv.clients.Clients, client.ID)
+	}
+	srv.clients.Mutex.Unlock()
+
+	// 已被新连接取代时账号仍在线，不修改数据库中的状态
+	if current {
+		if id, err := strconv.Atoi(client.ID); err == nil {
+			if err := databasetool.UserOffline(srv.db, id); err != nil {
+				client.Log.Error("更新用户状态失败", "err", err)
+			}
+		}
 	}
 
-	// 从管理器移除
-	user.Manager.Mutex.Lock()
-	delete(user.Manager.Clients, client.ID)
-	user.Manager.Mutex.Unlock()
-
-	// 更新数据库状态
-	db := databasetool.InitDB()
-	defer func() {
-		if sqlDB, err := db.DB(); err == nil {
-			sqlDB.Close()
-		}
-	}()
+	srv.audit(databasetool.AuditLogout, client.ID, client.ID, client.IP, true, fmt.Sprintf("在线 %s，收到 %d 条消息", time.Since(client.ConnectTime).Round(time.Second), client.MessageCount()))
 
+	srv.events.Publish(events.ClientDisconnected, map[string]interface{}{
+		"id":            client.ID,
+		"ip":            client.IP,
+		"message_count": client.MessageCount(),
+	})
 }
 
 // handleMessage 处理单条消息
-func handleMessage(client *user.Client, messageData []byte) error {
-	log.Printf("收到来自 %s 的消息: %s", client.ID, messageData)
+func (srv *Server) handleMessage(client *user.Client, messageData []byte) error {
+	start := time.Now()
 
 	messageStr := strings.TrimSpace(string(messageData))
 	if len(messageStr) == 0 {
+		frame.CountError(frame.ErrorInvalidJSON)
 		return errors.New("空消息")
 	}
 
 	// 解析消息类型
 	var jsonMap map[string]interface{}
 	if err := json.Unmarshal(messageData, &jsonMap); err != nil {
+		frame.CountError(frame.ErrorInvalidJSON)
 		return fmt.Errorf("解析JSON失败: %v", err)
 	}
 
 	msgType, ok := jsonMap["type"].(string)
 	if !ok {
+		frame.CountError(frame.ErrorInvalidJSON)
 		return errors.New("消息缺少type字段")
 	}
+	defer observeHandler(handlerLabel(msgType), start)
+	client.Log.Debug("收到消息", "type", msgType, "size", len(messageData))
 
 	// 根据消息类型处理
 	switch msgType {
@@ -602,6 +596,11 @@ func handleMessage(client *user.Client, messageData []byte) error {
 			return fmt.Errorf("解析聊天消息失败: %v", err)
 		}
 		chatMsg.SendID = client.ID
+		receiverID, err := user.ValidateID(chatMsg.ReceiveID)
+		if err != nil {
+			return err
+		}
+		chatMsg.ReceiveID = receiverID
 
 		// 序列化消息
 		messageBytes, err := json.Marshal(chatMsg)
@@ -609,37 +608,39 @@ func handleMessage(client *user.Client, messageData []byte) error {
 			return fmt.Errorf("序列化消息失败: %v", err)
 		}
 
-		// 发送给接收者
-		user.Manager.Mutex.Lock()
-		defer user.Manager.Mutex.Unlock()
-
-		if receiverClient, ok := user.Manager.Clients[chatMsg.ReceiveID]; ok {
-			if err := writeFramedBytes(receiverClient.Conn, messageBytes); err != nil {
+		// 发送给接收者，写入时不持有客户端表的锁，慢接收者不影响其他用户登录和收发
+		if receiverConn, ok := srv.onlineConn(chatMsg.ReceiveID); ok {
+			if err := writeFramedBytes(receiverConn, messageBytes); err != nil {
 				return fmt.Errorf("发送消息失败: %v", err)
 			}
+			messagesRouted.Inc()
 		} else {
-			log.Printf("接收者 %s 不在线", chatMsg.ReceiveID)
+			client.Log.Debug("接收者不在线，暂存消息", "receiver", chatMsg.ReceiveID)
 			// 如果接收者不在线，将消息暂存
-			if err := databasetool.CreateUnsendChat(db, chatMsg.SendID, chatMsg.ReceiveID, chatMsg.Content); err != nil {
+			if err := databasetool.CreateUnsendChat(srv.db, chatMsg.SendID, chatMsg.ReceiveID, chatMsg.Content); err != nil {
 				return fmt.Errorf("暂存消息失败: %v", err)
 			}
+			messagesQueued.Inc()
 		}
 	case "changepwd":
-		return handleChangePassword(client, []byte(messageStr))
+		return srv.handleChangePassword(client, []byte(messageStr))
 	case "changename":
-		return handleChangeName(client, []byte(messageStr))
+		return srv.handleChangeName(client, []byte(messageStr))
 	case "addfriend":
-		return handleAddFriend(client, []byte(messageStr))
+		return srv.handleAddFriend(client, []byte(messageStr))
 	case "acceptfriend":
-		return handleAcceptFriend(client, []byte(messageStr))
+		return srv.handleAcceptFriend(client, []byte(messageStr))
 	default:
+		if filetransfer.IsFileMessage(msgType) {
+			return srv.files.HandleMessage(client.ID, client.Conn, msgType, []byte(messageStr))
+		}
 		return fmt.Errorf("未知消息类型: %s", msgType)
 	}
 
 	return nil
 }
 
-func handleAcceptFriend(client *user.Client, messageData []byte) error {
+func (srv *Server) handleAcceptFriend(client *user.Client, messageData []byte) error {
 	response := map[string]interface{}{
 		"type":       "acceptfriend_response",
 		"status":     "success",
@@ -666,27 +667,29 @@ func handleAcceptFriend(client *user.Client, messageData []byte) error {
 		return fmt.Errorf("发送者ID转换失败: %v", err)
 	}
 	// 获取发送者用户信息
-	senderUser, err := databasetool.FindUserById(db, senderID)
+	senderUser, err := databasetool.FindUserById(srv.db, senderID)
 	if err != nil {
 		return fmt.Errorf("查询发送者用户失败: %v", err)
 	}
 	response["username"] = senderUser.Name
 	// 获取接收者用户信息
-	receiverUser, err := databasetool.FindUserByName(db, acceptFriendRequest.AddName)
+	receiverUser, err := databasetool.FindUserByName(srv.db, acceptFriendRequest.AddName)
 	if err != nil {
 		return fmt.Errorf("查询接收者用户失败: %v", err)
 	}
 	// 接收者ID已经是整数类型(uint)，直接转换为int
 	receiverID := int(receiverUser.ID)
 	// 添加好友
-	if err := databasetool.BeFriend(db, senderID, receiverID); err != nil {
+	if err := databasetool.BeFriend(srv.db, senderID, receiverID); err != nil {
+		srv.audit(databasetool.AuditFriendAccept, client.ID, strconv.Itoa(receiverID), client.IP, false, err.Error())
 		return fmt.Errorf("添加好友失败: %v", err)
 	}
+	srv.audit(databasetool.AuditFriendAccept, client.ID, strconv.Itoa(receiverID), client.IP, true, "")
 	// 检查好友是否在线
 	friendIDStr := fmt.Sprintf("%d", receiverID)
-	user.Manager.Mutex.Lock()
-	friendClient, online := user.Manager.Clients[friendIDStr]
-	user.Manager.Mutex.Unlock()
+	srv.clients.Mutex.Lock()
+	friendClient, online := srv.clients.Clients[friendIDStr]
+	srv.clients.Mutex.Unlock()
 
 	responseBytes, err := json.Marshal(response)
 	if err != nil {
@@ -702,7 +705,7 @@ func handleAcceptFriend(client *user.Client, messageData []byte) error {
 	} else {
 		// 好友不在线，暂存消息
 		content := fmt.Sprintf("friend_accepted:%s:%s", client.ID, senderUser.Name)
-		if err := databasetool.CreateUnsendChat(db, client.ID, friendIDStr, content); err != nil {
+		if err := databasetool.CreateUnsendChat(srv.db, client.ID, friendIDStr, content); err != nil {
 			return fmt.Errorf("暂存好友接受通知失败: %v", err)
 		}
 
@@ -715,7 +718,7 @@ func handleAcceptFriend(client *user.Client, messageData []byte) error {
 	return nil
 }
 
-func handleAddFriend(client *user.Client, messageData []byte) error {
+func (srv *Server) handleAddFriend(client *user.Client, messageData []byte) error {
 	response := map[string]interface{}{
 		"type":    "addfriend_response",
 		"status":  "success",
@@ -766,7 +769,7 @@ func handleAddFriend(client *user.Client, messageData []byte) error {
 	}
 
 	// 获取发送者用户信息
-	senderUser, err := databasetool.FindUserById(db, senderID)
+	senderUser, err := databasetool.FindUserById(srv.db, senderID)
 	if err != nil {
 		response["status"] = "fail"
 		response["message"] = "服务器错误"
@@ -822,7 +825,7 @@ func handleAddFriend(client *user.Client, messageData []byte) error {
 
 	if useNameQuery {
 		// 根据名称查询
-		friendUser, err = databasetool.FindUserByName(db, req.AddName)
+		friendUser, err = databasetool.FindUserByName(srv.db, req.AddName)
 		if err != nil {
 			response["status"] = "fail"
 			response["message"] = "找不到用户名为 " + req.AddName + " 的用户"
@@ -841,7 +844,7 @@ func handleAddFriend(client *user.Client, messageData []byte) error {
 		friendID = int(friendUser.ID)
 	} else {
 		// 根据ID查询
-		friendUser, err = databasetool.FindUserById(db, friendID)
+		friendUser, err = databasetool.FindUserById(srv.db, friendID)
 		if err != nil {
 			response["status"] = "fail"
 			response["message"] = "该用户不存在"
@@ -888,9 +891,9 @@ func handleAddFriend(client *user.Client, messageData []byte) error {
 
 	// 检查好友是否在线
 	friendIDStr := fmt.Sprintf("%d", friendID)
-	user.Manager.Mutex.Lock()
-	friendClient, online := user.Manager.Clients[friendIDStr]
-	user.Manager.Mutex.Unlock()
+	srv.clients.Mutex.Lock()
+	friendClient, online := srv.clients.Clients[friendIDStr]
+	srv.clients.Mutex.Unlock()
 
 	if online {
 		// 好友在线，直接发送请求
@@ -912,7 +915,7 @@ func handleAddFriend(client *user.Client, messageData []byte) error {
 	} else {
 		// 好友不在线，暂存消息
 		content := fmt.Sprintf("addfriend_request:%s:%s", client.ID, senderUser.Name)
-		if err := databasetool.CreateUnsendChat(db, client.ID, friendIDStr, content); err != nil {
+		if err := databasetool.CreateUnsendChat(srv.db, client.ID, friendIDStr, content); err != nil {
 			response["status"] = "fail"
 			response["message"] = "暂存好友请求失败"
 
@@ -928,7 +931,7 @@ func handleAddFriend(client *user.Client, messageData []byte) error {
 			return fmt.Errorf("暂存好友请求失败: %v", err)
 		}
 
-		log.Printf("用户 %s 的好友请求已暂存，等待用户 %s 上线", client.ID, friendIDStr)
+		client.Log.Info("好友请求已暂存，等待对方上线", "friend", friendIDStr)
 	}
 
 	// 发送成功响应
@@ -941,12 +944,13 @@ func handleAddFriend(client *user.Client, messageData []byte) error {
 		return fmt.Errorf("发送响应失败: %v", err)
 	}
 
-	log.Printf("用户 %s 发送好友请求给用户 %s", client.ID, friendIDStr)
+	srv.audit(databasetool.AuditFriendRequest, client.ID, friendIDStr, client.IP, true, "")
+	client.Log.Info("发送好友请求", "friend", friendIDStr)
 	return nil
 }
 
 // handleChangePassword 处理修改密码请求
-func handleChangePassword(client *user.Client, messageData []byte) error {
+func (srv *Server) handleChangePassword(client *user.Client, messageData []byte) error {
 	response := map[string]interface{}{
 		"type":    "changepwd_response",
 		"status":  "success",
@@ -993,7 +997,7 @@ func handleChangePassword(client *user.Client, messageData []byte) error {
 		return fmt.Errorf("解析密码修改请求失败: %v", err)
 	}
 
-	userRecord, err := databasetool.FindUserById(db, id)
+	userRecord, err := databasetool.FindUserById(srv.db, id)
 	if err != nil {
 		response["status"] = "fail"
 		response["message"] = "修改密码失败，服务器繁忙"
@@ -1022,11 +1026,12 @@ func handleChangePassword(client *user.Client, messageData []byte) error {
 		if err := writeFramedBytes(client.Conn, responseBytes); err != nil {
 			return fmt.Errorf("发送响应失败: %v", err)
 		}
+		srv.audit(databasetool.AuditChangePassword, client.ID, client.ID, client.IP, false, "原密码不正确")
 		return errors.New("当前密码不正确")
 	}
 
 	// 更新数据库中的密码
-	if err := databasetool.ChangePassword(db, id, req.NewPassword); err != nil {
+	if err := databasetool.ChangePassword(srv.db, id, req.NewPassword); err != nil {
 		response["status"] = "fail"
 		response["message"] = "修改密码失败，服务器繁忙"
 		// 发送响应
@@ -1051,12 +1056,13 @@ func handleChangePassword(client *user.Client, messageData []byte) error {
 		return fmt.Errorf("发送响应失败: %v", err)
 	}
 
-	log.Printf("用户 %s 修改密码成功", client.ID)
+	srv.audit(databasetool.AuditChangePassword, client.ID, client.ID, client.IP, true, "")
+	client.Log.Info("修改密码成功")
 	return nil
 }
 
 // handleChangeName 处理修改昵称请求
-func handleChangeName(client *user.Client, messageData []byte) error {
+func (srv *Server) handleChangeName(client *user.Client, messageData []byte) error {
 	response := map[string]interface{}{
 		"type":   "changename_response",
 		"status": "success",
@@ -1100,7 +1106,7 @@ func handleChangeName(client *user.Client, messageData []byte) error {
 	}
 
 	// 更新数据库中的昵称
-	if err := databasetool.ChangeName(db, id, req.NewName); err != nil {
+	if err := databasetool.ChangeName(srv.db, id, req.NewName); err != nil {
 		response["status"] = "fail"
 		// 发送响应
 		responseBytes, err := json.Marshal(response)
@@ -1122,307 +1128,7 @@ func handleChangeName(client *user.Client, messageData []byte) error {
 		return fmt.Errorf("发送响应失败: %v", err)
 	}
 
-	log.Printf("用户 %s 修改昵称成功", client.ID)
-	return nil
-}
-
-// handleFileHeaderPacket 处理客户端发送的文件头包(type=3)
-func handleFileHeaderPacket(client *user.Client, data []byte) error {
-	var header FileHeader
-	if err := json.Unmarshal(data, &header); err != nil {
-		return fmt.Errorf("解析文件头失败: %v", err)
-	}
-
-	fileSize, err := strconv.ParseInt(string(header.Size), 10, 64)
-	if err != nil {
-		return fmt.Errorf("无效的文件大小: %v", err)
-	}
-
-	fileMutex.Lock()
-	if oldSession, ok := uploadSessions[client.ID]; ok {
-		if oldSession.File != nil {
-			_ = oldSession.File.Close()
-		}
-		if oldSession.FilePath != "" {
-			_ = os.Remove(oldSession.FilePath)
-		}
-		delete(uploadSessions, client.ID)
-	}
-
-	fileKey := fmt.Sprintf("%s_%s_%d_%s", header.SendID, header.ReceiveID, time.Now().UnixNano(), header.Filename)
-	filePath := filepath.Join(fileStoragePath, fileKey)
-
-	file, err := os.Create(filePath)
-	if err != nil {
-		fileMutex.Unlock()
-		return fmt.Errorf("无法创建文件: %v", err)
-	}
-
-	uploadSessions[client.ID] = &UploadSession{
-		FileKey:    fileKey,
-		FilePath:   filePath,
-		File:       file,
-		FileSize:   fileSize,
-		SenderID:   header.SendID,
-		ReceiverID: header.ReceiveID,
-		Filename:   header.Filename,
-	}
-	fileMutex.Unlock()
-
-	user.Manager.Mutex.RLock()
-	receiverClient, online := user.Manager.Clients[header.ReceiveID]
-	user.Manager.Mutex.RUnlock()
-
-	if online {
-		notifyMsg := map[string]interface{}{
-			"type":      "file_notify",
-			"filename":  header.Filename,
-			"size":      header.Size.String(),
-			"sendid":    header.SendID,
-		}
-		notifyBytes, _ := json.Marshal(notifyMsg)
-		if err := writeFramedPacket(receiverClient.Conn, 3, notifyBytes); err != nil {
-			log.Printf("发送文件通知失败 %s -> %s: %v", client.ID, header.ReceiveID, err)
-		}
-	}
-
-	return nil
-}
-
-// handleFileChunk 处理客户端发送的文件数据包(type=2)
-func handleFileChunk(client *user.Client, data []byte) error {
-	fileMutex.Lock()
-	session, ok := uploadSessions[client.ID]
-	fileMutex.Unlock()
-	if !ok {
-		return fmt.Errorf("未找到文件上传会话: %s", client.ID)
-	}
-
-	if session.File != nil {
-		if _, err := session.File.Write(data); err != nil {
-			return fmt.Errorf("写入临时文件失败: %v", err)
-		}
-	}
-
-	session.Received += int64(len(data))
-
-	user.Manager.Mutex.RLock()
-	receiverClient, online := user.Manager.Clients[session.ReceiverID]
-	user.Manager.Mutex.RUnlock()
-
-	if online {
-		if err := writeFramedPacket(receiverClient.Conn, 2, data); err != nil {
-			log.Printf("转发文件数据失败 %s -> %s: %v", client.ID, session.ReceiverID, err)
-		}
-	}
-
-	if session.Received >= session.FileSize {
-		if session.File != nil {
-			_ = session.File.Close()
-			session.File = nil
-		}
-
-		fileMutex.Lock()
-		delete(uploadSessions, client.ID)
-		fileMutex.Unlock()
-
-		if !online {
-			fileMutex.Lock()
-			pendingFiles[session.FileKey] = &FileStorage{
-				Filename:   session.Filename,
-				FilePath:   session.FilePath,
-				FileSize:   session.FileSize,
-				Received:   session.Received,
-				SendTime:   time.Now(),
-				SenderID:   session.SenderID,
-				ReceiverID: session.ReceiverID,
-			}
-			fileMutex.Unlock()
-
-			if err := databasetool.CreateUnsendChat(db, session.SenderID, session.ReceiverID, "file:"+session.FileKey); err != nil {
-				return fmt.Errorf("创建离线文件消息失败: %v", err)
-			}
-			log.Printf("文件 %s 已暂存，等待接收者 %s 上线", session.Filename, session.ReceiverID)
-			return nil
-		}
-
-		if err := os.Remove(session.FilePath); err != nil {
-			log.Printf("删除临时文件失败 %s: %v", session.FilePath, err)
-		}
-		log.Printf("文件 %s 传输完成", session.Filename)
-	}
-
-	return nil
-}
-
-// handleFileTransfer 处理文件传输
-func handleFileTransfer(client *user.Client, data []byte) error {
-	var header FileHeader
-	if err := json.Unmarshal(data, &header); err != nil {
-		return fmt.Errorf("解析文件头失败: %v", err)
-	}
-
-	fileSize, err := strconv.ParseInt(string(header.Size), 10, 64)
-	if err != nil {
-		return fmt.Errorf("无效的文件大小: %v", err)
-	}
-
-	// 检查接收者是否在线
-	user.Manager.Mutex.Lock()
-	receiver, ok := user.Manager.Clients[header.ReceiveID]
-	user.Manager.Mutex.Unlock()
-
-	if ok {
-		// 接收者在线，准备直接传输
-		return forwardFileToReceiver(client, receiver, &header, fileSize)
-	} else {
-		// 接收者离线，暂存文件
-		return storePendingFile(client, &header, fileSize)
-	}
-}
-
-// forwardFileToReceiver 转发文件给接收者
-func forwardFileToReceiver(sender *user.Client, receiver *user.Client, header *FileHeader, fileSize int64) error {
-	// 1. 通知接收者准备接收文件
-	notifyMsg := map[string]interface{}{
-		"type":      "file_notify",
-		"filename":  header.Filename,
-		"size":      header.Size.String(),
-		"sendid":    header.SendID,
-	}
-	notifyBytes, _ := json.Marshal(notifyMsg)
-	if err := writeFramedBytes(receiver.Conn, notifyBytes); err != nil {
-		return fmt.Errorf("发送文件通知失败: %v", err)
-	}
-
-	// 2. 从发送者读取文件数据并转发给接收者
-	buf := make([]byte, 1024*1024) // 1MB缓冲区
-	var received int64 = 0
-
-	for received < fileSize {
-		n, err := sender.Conn.Read(buf)
-		if err != nil {
-			return fmt.Errorf("读取文件数据失败: %v", err)
-		}
-
-		// 转发给接收者
-		if _, err := receiver.Conn.Write(buf[:n]); err != nil {
-			return fmt.Errorf("转发文件数据失败: %v", err)
-		}
-
-		received += int64(n)
-		log.Printf("文件传输进度: %d/%d (%.2f%%)", received, fileSize, float64(received)*100/float64(fileSize))
-	}
-
-	log.Printf("文件 %s 传输完成", header.Filename)
-	return nil
-}
-
-// storePendingFile 存储待接收文件到文件系统
-func storePendingFile(sender *user.Client, header *FileHeader, fileSize int64) error {
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
-
-	// 生成唯一文件名防止冲突
-	fileKey := fmt.Sprintf("%s_%s_%d_%s",
-		header.SendID,
-		header.ReceiveID,
-		time.Now().UnixNano(),
-		header.Filename)
-	filePath := filepath.Join(fileStoragePath, fileKey)
-
-	// 创建文件
-	file, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("无法创建文件: %v", err)
-	}
-	defer file.Close()
-
-	// 读取并存储文件数据
-	buf := make([]byte, 1024*1024) // 1MB缓冲区
-	var received int64 = 0
-
-	for received < fileSize {
-		n, err := sender.Conn.Read(buf)
-		if err != nil {
-			os.Remove(filePath) // 删除不完整的文件
-			return fmt.Errorf("读取文件数据失败: %v", err)
-		}
-
-		if _, err := file.Write(buf[:n]); err != nil {
-			os.Remove(filePath) // 删除不完整的文件
-			return fmt.Errorf("写入文件失败: %v", err)
-		}
-
-		received += int64(n)
-	}
-
-	// 保存文件信息
-	pendingFiles[fileKey] = &FileStorage{
-		Filename:   header.Filename,
-		FilePath:   filePath,
-		FileSize:   fileSize,
-		Received:   received,
-		SendTime:   time.Now(),
-		SenderID:   header.SendID,
-		ReceiverID: header.ReceiveID,
-	}
-
-	log.Printf("文件 %s 已暂存到 %s，等待接收者 %s 上线", header.Filename, filePath, header.ReceiveID)
-	databasetool.CreateUnsendChat(db, header.SendID, header.ReceiveID, "file:"+fileKey)
-	return nil
-}
-
-// checkPendingFiles 发送待接收文件
-func checkPendingFiles(client *user.Client, fkey string) error {
-	fileMutex.Lock()
-	file, ok := pendingFiles[fkey]
-	if !ok || file.ReceiverID != client.ID {
-		fileMutex.Unlock()
-		return nil
-	}
-	fileMutex.Unlock()
-
-	notifyMsg := map[string]interface{}{
-		"type":      "file_notify",
-		"filename":  file.Filename,
-		"size":      strconv.FormatInt(file.FileSize, 10),
-		"sendid":    file.SenderID,
-	}
-	notifyBytes, _ := json.Marshal(notifyMsg)
-	if err := writeFramedPacket(client.Conn, 3, notifyBytes); err != nil {
-		return fmt.Errorf("发送文件通知失败: %v", err)
-	}
-
-	fileData, err := os.Open(file.FilePath)
-	if err != nil {
-		return fmt.Errorf("无法打开存储的文件: %v", err)
-	}
-	defer fileData.Close()
-
-	buf := make([]byte, 1024*1024)
-	for {
-		n, err := fileData.Read(buf)
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("读取存储文件失败: %v", err)
-		}
-		if n == 0 {
-			break
-		}
-
-		if err := writeFramedPacket(client.Conn, 2, buf[:n]); err != nil {
-			return fmt.Errorf("发送文件数据失败: %v", err)
-		}
-	}
-
-	fileMutex.Lock()
-	delete(pendingFiles, fkey)
-	fileMutex.Unlock()
-
-	if err := os.Remove(file.FilePath); err != nil {
-		log.Printf("删除已发送的离线文件失败 %s: %v", file.FilePath, err)
-	}
-
-	log.Printf("离线文件 %s 已发送给 %s", file.Filename, client.ID)
+	srv.audit(databasetool.AuditRename, client.ID, client.ID, client.IP, true, "新用户名: "+req.NewName)
+	client.Log.Info("修改昵称成功")
 	return nil
 }
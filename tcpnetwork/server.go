@@ -0,0 +1,134 @@
+package tcpnetwork
+
+//聊天服务器实例：持有TCP监听、在线客户端、文件存储、数据库、登录限流器和事件中心，同一进程中可以运行多个互不影响的实例
+//管理后台的会话和监控指标仍是进程级的，见 Options
+import (
+	"connection_server_linux/events"
+	"connection_server_linux/filetransfer"
+	"connection_server_linux/logincheck"
+	"connection_server_linux/user"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 定期清理的间隔
+const (
+	fileCleanupInterval    = 10 * time.Minute // 检查过期文件和遗留文件
+	limiterCleanupInterval = 10 * time.Minute // 清除登录限流器中过期的失败记录
+)
+
+// Options 创建聊天服务器所需的依赖和参数
+// 管理后台的会话(logincheck.GlobalSessionManager)由鉴权中间件直接使用，监控指标注册在 metrics.Default，
+// 两者都是进程级的：同一进程中的多个实例共享管理员会话，在线人数等指标按所有实例汇总
+type Options struct {
+	DB            *gorm.DB                 // 已完成表结构迁移的数据库，Close 时关闭
+	StorageDir    string                   // 文件传输的存储目录，不存在时自动创建
+	HTTPSPort     int                      // 签发文件下载链接使用的HTTPS端口
+	FileTTL       time.Duration            // 待接收文件的保存期限，为0时永久保存
+	MaxGoroutines int                      // 健康检查允许的最大协程数，为0时不检查
+	LoginLimiter  *logincheck.LoginLimiter // TCP登录和管理后台登录的限流器，为nil时使用 logincheck.GlobalLoginLimiter
+	Events        *events.Hub              // 管理后台订阅的事件中心，为nil时使用 events.Default
+}
+
+// Server 聊天服务器
+// TCP客户端的登录、消息转发、好友和文件传输，以及管理后台的HTTP接口都通过它访问共享状态
+type Server struct {
+	db            *gorm.DB
+	clients       *user.ClientManager      // 已登录的客户端
+	files         *filetransfer.Manager    // 文件传输管理器
+	limiter       *logincheck.LoginLimiter // 登录限流器
+	events        *events.Hub              // 服务器事件中心
+	maxGoroutines int
+	stopCleanup   context.CancelFunc // 停止文件存储和登录限流器的定期清理
+
+	mu           sync.Mutex
+	listener     net.Listener      // 正在监听的TCP listener
+	serving      bool              // Serve 的接受循环正在运行
+	shuttingDown bool              // 已开始关闭，不再接受连接
+	activeConns  map[net.Conn]bool // 所有未关闭的连接，包括尚未登录的
+	connWG       sync.WaitGroup    // 所有连接处理协程
+}
+
+// NewServer 创建聊天服务器，创建后调用 Serve 开始接受连接
+func NewServer(opts Options) (*Server, error) {
+	if opts.DB == nil {
+		return nil, errors.New("数据库连接不能为空")
+	}
+
+	if opts.LoginLimiter == nil {
+		opts.LoginLimiter = logincheck.GlobalLoginLimiter
+	}
+	if opts.Events == nil {
+		opts.Events = events.Default
+	}
+
+	srv := &Server{
+		db:            opts.DB,
+		clients:       user.NewClientManager(),
+		limiter:       opts.LoginLimiter,
+		events:        opts.Events,
+		maxGoroutines: opts.MaxGoroutines,
+		activeConns:   make(map[net.Conn]bool),
+	}
+	files, err := filetransfer.NewManager(opts.DB, opts.StorageDir, opts.HTTPSPort, opts.FileTTL, srv.onlineConn, srv.events)
+	if err != nil {
+		return nil, err
+	}
+	srv.files = files
+
+	cleanupCtx, cancel := context.WithCancel(context.Background())
+	srv.stopCleanup = cancel
+	go files.RunCleanup(cleanupCtx, fileCleanupInterval)
+	go srv.limiter.RunCleanup(cleanupCtx, limiterCleanupInterval)
+
+	registerServer(srv)
+	return srv, nil
+}
+
+// Addr 正在监听的TCP地址，尚未调用 Serve 时返回nil
+func (srv *Server) Addr() net.Addr {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.listener == nil {
+		return nil
+	}
+	return srv.listener.Addr()
+}
+
+// Clients 服务器的在线客户端管理器
+func (srv *Server) Clients() *user.ClientManager {
+	return srv.clients
+}
+
+// Events 服务器的事件中心，关闭HTTPS服务器前调用其 Close 以结束SSE长连接
+func (srv *Server) Events() *events.Hub {
+	return srv.events
+}
+
+// Close 停止定期清理并关闭数据库，需在 Shutdown 和HTTPS服务器关闭之后调用
+func (srv *Server) Close() error {
+	srv.stopCleanup()
+	unregisterServer(srv)
+	sqlDB, err := srv.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// onlineConn 查找在线用户的连接
+func (srv *Server) onlineConn(userID string) (net.Conn, bool) {
+	srv.clients.Mutex.RLock()
+	defer srv.clients.Mutex.RUnlock()
+
+	client, ok := srv.clients.Clients[userID]
+	if !ok {
+		return nil, false
+	}
+	return client.Conn, true
+}
@@ -0,0 +1,148 @@
+package tcpnetwork
+
+//TCP监听与优雅关闭
+import (
+	"connection_server_linux/databasetool"
+	"connection_server_linux/frame"
+	"connection_server_linux/user"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// connCloseTimeout 关闭连接后等待各连接处理协程完成清理的最长时间
+const connCloseTimeout = 5 * time.Second
+
+// ShutdownMessage 服务器关闭前下发给在线客户端的通知
+type ShutdownMessage struct {
+	Type    string `json:"type"`    // 固定为"server_shutdown"
+	Message string `json:"message"` // 提示信息
+	Time    string `json:"time"`    // 通知时间
+}
+
+// Serve 在 l 上接受TCP连接，每个连接由单独的协程处理
+// 调用 Shutdown 后返回 nil，listener 被其他方式关闭时返回错误
+func (srv *Server) Serve(l net.Listener) error {
+	srv.mu.Lock()
+	if srv.shuttingDown {
+		srv.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	srv.listener = l
+	srv.serving = true
+	srv.mu.Unlock()
+	defer func() {
+		srv.mu.Lock()
+		srv.serving = false
+		srv.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			srv.mu.Lock()
+			closing := srv.shuttingDown
+			srv.mu.Unlock()
+			if closing {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			slog.Warn("接受连接失败", "err", err)
+			continue
+		}
+
+		srv.mu.Lock()
+		if srv.shuttingDown {
+			srv.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		srv.activeConns[conn] = true
+		srv.connWG.Add(1)
+		srv.mu.Unlock()
+
+		go func() {
+			defer func() {
+				srv.mu.Lock()
+				delete(srv.activeConns, conn)
+				srv.mu.Unlock()
+				srv.connWG.Done()
+			}()
+			srv.HandleConnection(conn)
+		}()
+	}
+}
+
+// Shutdown 优雅关闭TCP服务：
+// 停止接受新连接，通知在线客户端服务器即将关闭，等待进行中的上传完成(最长到 ctx 结束)，
+// 然后关闭所有连接，等待各连接完成清理，最后把仍标记为在线的用户全部设为离线
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mu.Lock()
+	srv.shuttingDown = true
+	if srv.listener != nil {
+		srv.listener.Close()
+	}
+	srv.mu.Unlock()
+
+	srv.notifyShutdown()
+
+	if aborted := srv.files.Drain(ctx); aborted > 0 {
+		slog.Warn("关闭超时，部分上传被中止", "count", aborted)
+	}
+
+	srv.mu.Lock()
+	for conn := range srv.activeConns {
+		conn.Close()
+	}
+	srv.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		srv.connWG.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-time.After(connCloseTimeout):
+		err = errors.New("等待连接清理超时")
+	}
+
+	// 正常情况下 cleanupClient 已逐个设为离线，这里兜底处理清理未完成的连接
+	count, dbErr := databasetool.MarkAllUsersOffline(srv.db, time.Now())
+	if dbErr != nil {
+		return errors.Join(err, dbErr)
+	}
+	if count > 0 {
+		slog.Info("已将剩余在线用户设为离线", "count", count)
+	}
+	return err
+}
+
+// notifyShutdown 向所有在线客户端发送 server_shutdown 通知
+func (srv *Server) notifyShutdown() {
+	msg := ShutdownMessage{
+		Type:    "server_shutdown",
+		Message: "服务器即将关闭，请稍后重新连接",
+		Time:    time.Now().Round(0).String(),
+	}
+
+	srv.clients.Mutex.RLock()
+	clients := make([]*user.Client, 0, len(srv.clients.Clients))
+	for _, client := range srv.clients.Clients {
+		clients = append(clients, client)
+	}
+	srv.clients.Mutex.RUnlock()
+
+	for _, client := range clients {
+		if err := frame.WriteJSON(client.Conn, msg); err != nil {
+			client.Log.Warn("发送关闭通知失败", "err", err)
+		}
+	}
+	slog.Info("已通知在线客户端服务器即将关闭", "clients", len(clients))
+}
@@ -0,0 +1,324 @@
+package tcpnetwork
+
+//账号管理http请求处理
+import (
+	"connection_server_linux/databasetool"
+	"connection_server_linux/events"
+	"connection_server_linux/frame"
+	"connection_server_linux/friendupdate"
+	"connection_server_linux/logging"
+	"connection_server_linux/logincheck"
+	"connection_server_linux/user"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// 用户名和密码的最大长度，与User表的字段长度一致
+const (
+	maxNameLength     = databasetool.MaxUserNameLength
+	maxPasswordLength = databasetool.MaxUserPasswordLength
+)
+
+// 分页参数
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// userView 返回给管理后台的用户信息，不包含密码
+type userView struct {
+	ID           uint      `json:"id"`
+	Name         string    `json:"name"`
+	IP           string    `json:"ip"`
+	RegisterTime time.Time `json:"register_time"`
+	LeaveTime    time.Time `json:"leave_time"`
+	Online       bool      `json:"online"`
+	Disabled     bool      `json:"disabled"`
+	FriendCount  int       `json:"friend_count"`
+}
+
+// newUserView 组装用户信息，在线状态取自当前连接
+func (srv *Server) newUserView(record *databasetool.User) userView {
+	online := srv.clients.IsOnline(strconv.FormatUint(uint64(record.ID), 10))
+
+	return userView{
+		ID:           record.ID,
+		Name:         record.Name,
+		IP:           record.Ip,
+		RegisterTime: record.RegisterTime,
+		LeaveTime:    record.LeaveTime,
+		Online:       online,
+		Disabled:     record.Disabled,
+		FriendCount:  countFriends(record.Relation),
+	}
+}
+
+// countFriends 统计关系字节中的好友数量
+func countFriends(relation []byte) int {
+	count := 0
+	statuses := friendupdate.AnalyzeRelationByte(relation)
+	for i := 1; i < len(statuses); i++ {
+		if statuses[i] == friendupdate.Friend {
+			count++
+		}
+	}
+	return count
+}
+
+// disconnectClient 通知在线用户被强制下线并断开连接
+// 连接关闭后由 cleanupClient 从管理器移除并将数据库中的状态设为离线
+// 返回值: 用户是否在线
+func (srv *Server) disconnectClient(userID string, reason string) bool {
+	srv.clients.Mutex.RLock()
+	client, exists := srv.clients.Clients[userID]
+	srv.clients.Mutex.RUnlock()
+	if !exists {
+		return false
+	}
+
+	_ = frame.WriteJSON(client.Conn, map[string]string{
+		"type":   "force_logout",
+		"reason": reason,
+	})
+	client.Conn.Close()
+	srv.events.Publish(events.ClientKicked, map[string]interface{}{
+		"id":     userID,
+		"ip":     client.IP,
+		"reason": reason,
+	})
+	return true
+}
+
+// clearRelations 清除其他用户与被删除用户之间的好友/请求/拉黑关系
+func (srv *Server) clearRelations(id int, relation []byte) {
+	if err := databasetool.ClearRelations(srv.db, id, relation); err != nil {
+		slog.Error("清除好友关系失败", "user", id, "err", err)
+	}
+}
+
+// lookupUser 解析路径中的用户ID并查询用户，失败时直接写入响应
+func (srv *Server) lookupUser(w http.ResponseWriter, r *http.Request) (int, *databasetool.User, bool) {
+	idStr, err := user.ValidateID(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "无效的用户ID")
+		return 0, nil, false
+	}
+	id, _ := strconv.Atoi(idStr)
+
+	record, err := databasetool.FindUserById(srv.db, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "未找到用户 %d", id)
+			return 0, nil, false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "查询用户失败: %v", err)
+		return 0, nil, false
+	}
+	return id, record, true
+}
+
+// adminName 返回当前操作的管理员，用于日志
+func adminName(r *http.Request) string {
+	_, session, _ := logincheck.SessionFromRequest(r)
+	return session.UserID
+}
+
+// 分页查询注册用户，支持 q(用户名或ID)、page、page_size 参数
+func (srv *Server) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if err != nil || pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	records, total, err := databasetool.ListUsers(srv.db, query, (page-1)*pageSize, pageSize)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "查询用户失败: %v", err)
+		return
+	}
+
+	users := make([]userView, 0, len(records))
+	for i := range records {
+		users = append(users, srv.newUserView(&records[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users":     users,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// 查看单个用户的详细信息
+func (srv *Server) GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	_, record, ok := srv.lookupUser(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(srv.newUserView(record))
+}
+
+// 重置用户密码，用户在线时强制下线
+func (srv *Server) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	id, record, ok := srv.lookupUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "密码不能为空")
+		return
+	}
+	if len(req.Password) > maxPasswordLength {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "密码长度不能超过%d个字符", maxPasswordLength)
+		return
+	}
+
+	if err := databasetool.ChangePassword(srv.db, id, req.Password); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "重置密码失败: %v", err)
+		return
+	}
+	srv.disconnectClient(strconv.Itoa(id), "密码已被管理员重置，请重新登录")
+	logging.FromContext(r.Context()).Info("重置用户密码", "admin", adminName(r), "user", id, "name", record.Name)
+	srv.audit(databasetool.AuditChangePassword, adminActor(r), strconv.Itoa(id), requestIP(r), true, "管理员重置密码")
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "用户 %s 的密码已重置", record.Name)
+}
+
+// 修改用户名，新用户名不能与其他用户重复
+func (srv *Server) RenameUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, record, ok := srv.lookupUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "无效的请求格式")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxNameLength {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "用户名不能为空且不能超过%d个字符", maxNameLength)
+		return
+	}
+
+	if existing, err := databasetool.FindUserByName(srv.db, name); err == nil {
+		if existing.ID != record.ID {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintf(w, "用户名 %s 已存在", name)
+			return
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "查询用户名失败: %v", err)
+		return
+	}
+
+	if err := databasetool.ChangeName(srv.db, id, name); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "修改用户名失败: %v", err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("修改用户名", "admin", adminName(r), "user", id, "old_name", record.Name, "new_name", name)
+	srv.audit(databasetool.AuditRename, adminActor(r), strconv.Itoa(id), requestIP(r), true, fmt.Sprintf("%s -> %s", record.Name, name))
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "用户名已修改为 %s", name)
+}
+
+// 禁用用户，用户在线时强制下线
+func (srv *Server) DisableUserHandler(w http.ResponseWriter, r *http.Request) {
+	srv.setUserDisabled(w, r, true)
+}
+
+// 启用被禁用的用户
+func (srv *Server) EnableUserHandler(w http.ResponseWriter, r *http.Request) {
+	srv.setUserDisabled(w, r, false)
+}
+
+// setUserDisabled 禁用/启用用户的公共处理
+func (srv *Server) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	id, record, ok := srv.lookupUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := databasetool.SetUserDisabled(srv.db, id, disabled); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "更新用户状态失败: %v", err)
+		return
+	}
+
+	action, auditAction := "启用", databasetool.AuditEnableUser
+	if disabled {
+		action, auditAction = "禁用", databasetool.AuditDisableUser
+		srv.disconnectClient(strconv.Itoa(id), "账号已被禁用")
+	}
+	logging.FromContext(r.Context()).Info(action+"用户", "admin", adminName(r), "user", id, "name", record.Name)
+	srv.audit(auditAction, adminActor(r), strconv.Itoa(id), requestIP(r), true, "")
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "用户 %s 已%s", record.Name, action)
+}
+
+// 删除用户及其暂存消息，用户在线时强制下线
+func (srv *Server) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, record, ok := srv.lookupUser(w, r)
+	if !ok {
+		return
+	}
+
+	idStr := strconv.Itoa(id)
+	srv.disconnectClient(idStr, "账号已被删除")
+	if err := databasetool.DeleteUser(srv.db, id); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "删除用户失败: %v", err)
+		return
+	}
+	if err := databasetool.DeleteUnsendChatsByReciveID(srv.db, idStr); err != nil {
+		logging.FromContext(r.Context()).Error("删除用户的暂存消息失败", "user", id, "err", err)
+	}
+	srv.clearRelations(id, record.Relation)
+	logging.FromContext(r.Context()).Info("删除用户", "admin", adminName(r), "user", id, "name", record.Name)
+	srv.audit(databasetool.AuditDeleteUser, adminActor(r), idStr, requestIP(r), true, "用户名: "+record.Name)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "用户 %s 已删除", record.Name)
+}
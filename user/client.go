@@ -1,8 +1,10 @@
 package user
 //客户端管理
 import (
+	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -33,13 +35,59 @@ type FriendListMessage struct {
 }
 
 // Client 结构体用于存储客户端连接信息
+// 消息循环更新活动时间和消息数时不持有管理器的锁，这两项用原子操作读写，其他字段登录后不再修改
 type Client struct {
-	Conn        net.Conn    `json:"-"`
-	ID          string      `json:"id"`
-	IP          string      `json:"ip"`
-	ConnectTime time.Time   `json:"connect_time"`
-	LastActive  time.Time   `json:"last_active"`
-	Friends     []FriendInfo `json:"friends"`
+	Conn        net.Conn
+	ID          string
+	IP          string
+	ConnectTime time.Time
+	Friends     []FriendInfo // 持有管理器的锁时写入
+	Log         *slog.Logger // 带有连接ID和用户ID的日志记录器
+
+	lastActive   atomic.Int64 // Unix纳秒
+	messageCount atomic.Int64 // 登录后收到的JSON消息数
+}
+
+// ClientInfo 客户端状态快照，供管理后台展示
+type ClientInfo struct {
+	ID           string       `json:"id"`
+	IP           string       `json:"ip"`
+	ConnectTime  time.Time    `json:"connect_time"`
+	LastActive   time.Time    `json:"last_active"`
+	MessageCount int64        `json:"message_count"`
+	Friends      []FriendInfo `json:"friends"`
+}
+
+// Touch 记录一次活动
+func (c *Client) Touch(now time.Time) {
+	c.lastActive.Store(now.UnixNano())
+}
+
+// LastActive 最后一次活动的时间
+func (c *Client) LastActive() time.Time {
+	return time.Unix(0, c.lastActive.Load())
+}
+
+// CountMessage 消息数加一，返回新的消息数
+func (c *Client) CountMessage() int64 {
+	return c.messageCount.Add(1)
+}
+
+// MessageCount 登录后收到的JSON消息数
+func (c *Client) MessageCount() int64 {
+	return c.messageCount.Load()
+}
+
+// Info 返回客户端状态快照，调用者需持有管理器的读锁以读取 Friends
+func (c *Client) Info() ClientInfo {
+	return ClientInfo{
+		ID:           c.ID,
+		IP:           c.IP,
+		ConnectTime:  c.ConnectTime,
+		LastActive:   c.LastActive(),
+		MessageCount: c.MessageCount(),
+		Friends:      c.Friends,
+	}
 }
 
 // ClientManager 用于管理所有客户端连接
@@ -48,6 +96,17 @@ type ClientManager struct {
 	Mutex      sync.RWMutex
 }
 
-var Manager = &ClientManager{
-	Clients: make(map[string]*Client),
+// NewClientManager 创建空的客户端管理器，每个聊天服务器实例持有一个
+func NewClientManager() *ClientManager {
+	return &ClientManager{
+		Clients: make(map[string]*Client),
+	}
+}
+
+// IsOnline 用户当前是否有已登录的连接，在线状态以此为准，数据库中的Status仅作记录
+func (m *ClientManager) IsOnline(id string) bool {
+	m.Mutex.RLock()
+	defer m.Mutex.RUnlock()
+	_, ok := m.Clients[id]
+	return ok
 }
\ No newline at end of file
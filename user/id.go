@@ -0,0 +1,15 @@
+package user
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ValidateID 校验客户端提供的用户ID，只接受正整数，返回规范化后的ID
+func ValidateID(id string) (string, error) {
+	num, err := strconv.ParseUint(id, 10, 32)
+	if err != nil || num == 0 {
+		return "", fmt.Errorf("无效的用户ID: %q", id)
+	}
+	return strconv.FormatUint(num, 10), nil
+}
@@ -0,0 +1,17 @@
+package user
+
+import "testing"
+
+func TestValidateID(t *testing.T) {
+	for in, want := range map[string]string{"1": "1", "42": "42", "007": "7"} {
+		got, err := ValidateID(in)
+		if err != nil || got != want {
+			t.Errorf("ValidateID(%q) = %q, %v, 期望 %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "0", "-1", "1/../2", "../1", "1 ", "abc", "99999999999"} {
+		if got, err := ValidateID(in); err == nil {
+			t.Errorf("ValidateID(%q) = %q, 期望返回错误", in, got)
+		}
+	}
+}